@@ -0,0 +1,463 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/repo"
+)
+
+// Version of the format written by [Export]. Bump it on changes that older
+// versions can't read, [Import] refuses versions newer than this one
+const FormatVersion = 1
+
+const formatName = "voidh"
+
+// The first line of an export, every other line is a [fileRecord] or an [albumRecord].
+// There are no play stats or playlists in the library yet, they'll get records of their own
+type header struct {
+	Type       string `json:"type"`
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	ExportedAt int64  `json:"exported_at"`
+}
+
+type fileRecord struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	// Hashes are hex encoded, same as they are printed everywhere else
+	Hash      string `json:"hash"`
+	Size      int64  `json:"size"`
+	ModTimeMs int64  `json:"mtime,omitempty"`
+	// Set for audio files only
+	AudioHash     string              `json:"audio_hash,omitempty"`
+	Tags          []tagRecord         `json:"tags,omitempty"`
+	Properties    *propertiesRecord   `json:"properties,omitempty"`
+	VirtualTracks []virtualRecord     `json:"virtual_tracks,omitempty"`
+	Pictures      []pictureLinkRecord `json:"pictures,omitempty"`
+}
+
+// Exports written before it had names of its own have Key and Value, which read the same
+type tagRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type propertiesRecord struct {
+	Codec         string `json:"codec"`
+	SampleRate    uint32 `json:"sample_rate"`
+	Channels      uint8  `json:"channels"`
+	BitsPerSample uint8  `json:"bits_per_sample"`
+	SamplesTotal  uint64 `json:"samples_total"`
+	Bitrate       uint32 `json:"bitrate"`
+}
+
+type virtualRecord struct {
+	TrackNum    uint8  `json:"track_num"`
+	Title       string `json:"title"`
+	Performer   string `json:"performer"`
+	Isrc        string `json:"isrc"`
+	StartSample uint64 `json:"start_sample"`
+	EndSample   uint64 `json:"end_sample"`
+	SampleRate  uint32 `json:"sample_rate"`
+}
+
+type pictureLinkRecord struct {
+	Hash     string `json:"hash"`
+	MimeType string `json:"mime_type"`
+	Width    uint32 `json:"width"`
+	Height   uint32 `json:"height"`
+	Size     uint64 `json:"size"`
+	PicType  uint32 `json:"pic_type"`
+	Desc     string `json:"desc"`
+}
+
+type albumRecord struct {
+	Type     string              `json:"type"`
+	Dir      string              `json:"dir"`
+	Pictures []pictureLinkRecord `json:"pictures"`
+}
+
+const (
+	recordHeader = "header"
+	recordFile   = "file"
+	recordAlbum  = "album"
+)
+
+// Returned by [Import] for input it can't make sense of
+type FormatErr struct {
+	Line int
+	Msg  string
+}
+
+func (err FormatErr) Error() string {
+	return fmt.Sprintf("line %d: %s", err.Line, err.Msg)
+}
+
+// Counts of what [Import] did
+type ImportStats struct {
+	Files int
+	// Files that got the record of a file with the same contents under another name
+	Moved  int
+	Albums int
+}
+
+// Writes the whole library as NDJSON, one file or album per line. History of changes is left out,
+// it belongs to the library it happened in
+func Export(store repo.Store, w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+
+	if err := encoder.Encode(header{
+		Type:       recordHeader,
+		Format:     formatName,
+		Version:    FormatVersion,
+		ExportedAt: time.Now().UnixMilli(),
+	}); err != nil {
+		return err
+	}
+
+	// Directories are exported in the order they're first seen, the map only tells if they were
+	dirs := []string{}
+	seen := map[string]bool{}
+	for f, err := range store.List(repo.Query{Order: []repo.Order{{Key: repo.Filename{}}}}) {
+		if err != nil {
+			return err
+		}
+
+		record, err := fileRecordMake(store, f.Name)
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+
+		if dir := filepath.Dir(f.Name); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	for _, dir := range dirs {
+		pics, err := store.AlbumPictures(dir)
+		if err != nil {
+			return err
+		}
+		if len(pics) == 0 {
+			continue
+		}
+		if err := encoder.Encode(albumRecord{Type: recordAlbum, Dir: dir, Pictures: pictureRecords(pics)}); err != nil {
+			return err
+		}
+	}
+
+	return buffered.Flush()
+}
+
+// Restores an export into the library, in a single transaction. Files that aren't in the
+// library under their exported name, but are under another one with the same hash, are
+// assumed to be moved, and get the exported record under the new name
+func Import(store repo.Store, r io.Reader) (ImportStats, error) {
+	var result ImportStats
+
+	files := []fileRecord{}
+	albums := []albumRecord{}
+
+	scanner := bufio.NewScanner(r)
+	// Lines of files with lots of tags and pictures can get long
+	scanner.Buffer(nil, 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line += 1
+		data := scanner.Bytes()
+
+		var kind struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &kind); err != nil {
+			return result, FormatErr{line, err.Error()}
+		}
+
+		if line == 1 {
+			var h header
+			if err := json.Unmarshal(data, &h); err != nil || h.Type != recordHeader || h.Format != formatName {
+				return result, FormatErr{line, "not a voidh export"}
+			}
+			if h.Version > FormatVersion {
+				return result, FormatErr{line, fmt.Sprintf(
+					"format version %d is newer than the latest supported one, %d",
+					h.Version,
+					FormatVersion,
+				)}
+			}
+			continue
+		}
+
+		switch kind.Type {
+		case recordFile:
+			var record fileRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return result, FormatErr{line, err.Error()}
+			}
+			files = append(files, record)
+		case recordAlbum:
+			var record albumRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return result, FormatErr{line, err.Error()}
+			}
+			albums = append(albums, record)
+		default:
+			return result, FormatErr{line, fmt.Sprintf("unknown record type %q", kind.Type)}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	if line == 0 {
+		return result, FormatErr{0, "empty input"}
+	}
+
+	// Names are resolved before writing, since there's nothing to read with inside a batch
+	names, moved, err := namesResolve(store, files)
+	if err != nil {
+		return result, err
+	}
+
+	err = store.BatchFrom(repo.SourceImport, func(tx repo.Tx) error {
+		for i, record := range files {
+			if err := fileRecordApply(tx, record, names[i]); err != nil {
+				return fmt.Errorf("%s: %w", record.Name, err)
+			}
+		}
+		for _, record := range albums {
+			pics, err := pictureLinks(record.Pictures)
+			if err != nil {
+				return fmt.Errorf("%s: %w", record.Dir, err)
+			}
+			if err := tx.ReplaceAlbumPictures(record.Dir, pics); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	result.Files = len(files)
+	result.Moved = moved
+	result.Albums = len(albums)
+	return result, nil
+}
+
+func fileRecordMake(store repo.Store, name string) (fileRecord, error) {
+	af, err := store.GetByPath(name)
+	if err != nil {
+		return fileRecord{}, err
+	}
+
+	result := fileRecord{
+		Type: recordFile,
+		Name: af.FsFile.Name,
+		Hash: hex.EncodeToString(af.FsFile.Hash),
+		Size: af.FsFile.Size,
+	}
+	if !af.FsFile.ModTime.IsZero() {
+		result.ModTimeMs = af.FsFile.ModTime.UnixMilli()
+	}
+	if af.Tags != nil {
+		props := af.Properties
+		result.AudioHash = hex.EncodeToString(af.AudioStreamHash)
+		for _, tag := range af.Tags {
+			result.Tags = append(result.Tags, tagRecord{Key: tag.Key, Value: tag.Value})
+		}
+		result.Properties = &propertiesRecord{
+			Codec:         props.Codec,
+			SampleRate:    props.SampleRate,
+			Channels:      props.Channels,
+			BitsPerSample: props.BitsPerSample,
+			SamplesTotal:  props.SamplesTotal,
+			Bitrate:       props.Bitrate,
+		}
+	}
+
+	tracks, err := store.VirtualTracks(name)
+	if err != nil {
+		return result, err
+	}
+	for _, track := range tracks {
+		result.VirtualTracks = append(result.VirtualTracks, virtualRecord{
+			TrackNum:    track.TrackNum,
+			Title:       track.Title,
+			Performer:   track.Performer,
+			Isrc:        track.Isrc,
+			StartSample: track.StartSample,
+			EndSample:   track.EndSample,
+			SampleRate:  track.SampleRate,
+		})
+	}
+
+	pics, err := store.FilePictures(name)
+	if err != nil {
+		return result, err
+	}
+	result.Pictures = pictureRecords(pics)
+
+	return result, nil
+}
+
+// Picks the name each record ends up under, returning how many of them were moved
+func namesResolve(store repo.Store, files []fileRecord) ([]string, int, error) {
+	result := []string{}
+	moved := 0
+
+	exported := map[string]bool{}
+	for _, record := range files {
+		exported[record.Name] = true
+	}
+	claimed := map[string]bool{}
+
+	for _, record := range files {
+		name := record.Name
+
+		_, err := store.GetByPath(name)
+		switch {
+		case err == nil:
+		case errors.Is(err, repo.NotFoundErr):
+			hash, err := hex.DecodeString(record.Hash)
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s: %w", record.Name, err)
+			}
+			sameHash, err := store.GetByHash(hash)
+			if err != nil {
+				return nil, 0, err
+			}
+			// Files that have records of their own keep them
+			for _, f := range sameHash {
+				if !exported[f.Name] && !claimed[f.Name] {
+					name = f.Name
+					claimed[name] = true
+					moved += 1
+					break
+				}
+			}
+		default:
+			return nil, 0, err
+		}
+
+		result = append(result, name)
+	}
+
+	return result, moved, nil
+}
+
+func fileRecordApply(tx repo.Tx, record fileRecord, name string) error {
+	fsFile := file.FsFile{Name: name, Size: record.Size}
+	var err error
+	if fsFile.Hash, err = hex.DecodeString(record.Hash); err != nil {
+		return err
+	}
+	if record.ModTimeMs != 0 {
+		fsFile.ModTime = time.UnixMilli(record.ModTimeMs)
+	}
+
+	if record.Properties == nil {
+		if err := tx.Insert(fsFile); err != nil {
+			return err
+		}
+	} else {
+		af := file.AudioFile{
+			FsFile: fsFile,
+			Tags:   []file.Tag{},
+			Properties: file.AudioProperties{
+				Codec:         record.Properties.Codec,
+				SampleRate:    record.Properties.SampleRate,
+				Channels:      record.Properties.Channels,
+				BitsPerSample: record.Properties.BitsPerSample,
+				SamplesTotal:  record.Properties.SamplesTotal,
+				Bitrate:       record.Properties.Bitrate,
+			},
+		}
+		for _, tag := range record.Tags {
+			af.Tags = append(af.Tags, file.Tag{Key: tag.Key, Value: tag.Value})
+		}
+		if af.AudioStreamHash, err = hex.DecodeString(record.AudioHash); err != nil {
+			return err
+		}
+		if len(af.AudioStreamHash) == 0 {
+			af.AudioStreamHash = nil
+		}
+		if err := tx.UpsertAudioFile(af); err != nil {
+			return err
+		}
+	}
+
+	tracks := []file.VirtualTrack{}
+	for _, track := range record.VirtualTracks {
+		tracks = append(tracks, file.VirtualTrack{
+			Source:      name,
+			TrackNum:    track.TrackNum,
+			Title:       track.Title,
+			Performer:   track.Performer,
+			Isrc:        track.Isrc,
+			StartSample: track.StartSample,
+			EndSample:   track.EndSample,
+			SampleRate:  track.SampleRate,
+		})
+	}
+	if err := tx.ReplaceVirtualTracks(name, tracks); err != nil {
+		return err
+	}
+
+	pics, err := pictureLinks(record.Pictures)
+	if err != nil {
+		return err
+	}
+	return tx.ReplaceFilePictures(name, pics)
+}
+
+func pictureRecords(pics []file.PictureLink) []pictureLinkRecord {
+	result := []pictureLinkRecord{}
+	for _, pic := range pics {
+		result = append(result, pictureLinkRecord{
+			Hash:     hex.EncodeToString(pic.Hash),
+			MimeType: pic.MimeType,
+			Width:    pic.Width,
+			Height:   pic.Height,
+			Size:     pic.Size,
+			PicType:  pic.PicType,
+			Desc:     pic.Desc,
+		})
+	}
+	return result
+}
+
+func pictureLinks(records []pictureLinkRecord) ([]file.PictureLink, error) {
+	result := []file.PictureLink{}
+	for _, record := range records {
+		hash, err := hex.DecodeString(record.Hash)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, file.PictureLink{
+			Picture: file.Picture{
+				Hash:     hash,
+				MimeType: record.MimeType,
+				Width:    record.Width,
+				Height:   record.Height,
+				Size:     record.Size,
+			},
+			PicType: record.PicType,
+			Desc:    record.Desc,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,86 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/repo"
+)
+
+func testStore(t *testing.T) repo.Store {
+	store, err := repo.Init(repo.Config{DatabasePath: filepath.Join(t.TempDir(), "voidh.db")})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(store.Close)
+	return &store
+}
+
+func TestExportImport(t *testing.T) {
+	src := testStore(t)
+
+	song := file.AudioFile{
+		FsFile:          file.FsFile{Name: "/old/song.flac", Hash: []byte{1}, Size: 100},
+		AudioStreamHash: []byte{2},
+		Tags:            []file.Tag{{Key: file.TagTitle, Value: "Song"}, {Key: file.TagArtist, Value: "Artist"}},
+		Properties:      file.AudioProperties{Codec: "flac", SampleRate: 44100, BitsPerSample: 16, SamplesTotal: 441000},
+	}
+	cover := file.PictureLink{Picture: file.Picture{Hash: []byte{3}, MimeType: "image/jpeg", Width: 1, Height: 1}, PicType: 3}
+	assert.Nil(t, src.UpsertAudioFile(song))
+	assert.Nil(t, src.ReplaceFilePictures(song.FsFile.Name, []file.PictureLink{cover}))
+	assert.Nil(t, src.ReplaceVirtualTracks(song.FsFile.Name, []file.VirtualTrack{{TrackNum: 1, Title: "Part", EndSample: 10}}))
+	assert.Nil(t, src.Insert(file.FsFile{Name: "/old/notes.txt", Hash: []byte{4}}))
+	assert.Nil(t, src.ReplaceAlbumPictures("/old", []file.PictureLink{cover}))
+
+	var exported bytes.Buffer
+	assert.Nil(t, Export(src, &exported))
+	assert.Equal(t, 4, strings.Count(exported.String(), "\n"))
+	assert.Contains(t, exported.String(), `"tags":[{"key":"TITLE","value":"Song"},{"key":"ARTIST","value":"Artist"}]`)
+
+	// The song has been moved, and the new machine already knows about it under the new name
+	dst := testStore(t)
+	assert.Nil(t, dst.Insert(file.FsFile{Name: "/new/song.flac", Hash: []byte{1}}))
+
+	stats, err := Import(dst, bytes.NewReader(exported.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, ImportStats{Files: 2, Moved: 1, Albums: 1}, stats)
+
+	af, err := dst.GetByPath("/new/song.flac")
+	assert.Nil(t, err)
+	assert.Equal(t, song.Tags, af.Tags)
+	assert.Equal(t, song.Properties, af.Properties)
+	assert.Equal(t, song.AudioStreamHash, af.AudioStreamHash)
+
+	pics, err := dst.FilePictures("/new/song.flac")
+	assert.Nil(t, err)
+	assert.Len(t, pics, 1)
+	tracks, err := dst.VirtualTracks("/new/song.flac")
+	assert.Nil(t, err)
+	assert.Len(t, tracks, 1)
+	pics, err = dst.AlbumPictures("/old")
+	assert.Nil(t, err)
+	assert.Len(t, pics, 1)
+
+	_, err = dst.GetByPath("/old/notes.txt")
+	assert.Nil(t, err)
+	_, err = dst.GetByPath("/old/song.flac")
+	assert.True(t, errors.Is(err, repo.NotFoundErr))
+}
+
+func TestImportRejects(t *testing.T) {
+	store := repo.NewMemory()
+
+	_, err := Import(store, strings.NewReader(`{"type":"header","format":"voidh","version":99}`))
+	assert.Equal(t, 1, err.(FormatErr).Line)
+
+	_, err = Import(store, strings.NewReader("{\"type\":\"header\",\"format\":\"voidh\",\"version\":1}\n{\"type\":\"playlist\"}"))
+	assert.Equal(t, 2, err.(FormatErr).Line)
+
+	_, err = Import(store, strings.NewReader(`{"type":"file","name":"/a.flac"}`))
+	assert.Equal(t, 1, err.(FormatErr).Line)
+}
@@ -0,0 +1,101 @@
+package artwork
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wetfloo/voidh/file/flac"
+	"github.com/wetfloo/voidh/file/id3v2"
+	"github.com/wetfloo/voidh/file/mp4"
+)
+
+// Picture as found in a file, before it ends up in the cache
+type Embedded struct {
+	PicType  flac.PicType
+	Desc     string
+	MimeType string
+	Data     []byte
+}
+
+var id3Magic = []byte("ID3")
+
+// Extracts all pictures embedded into the audio file. Files of unknown formats have none
+func Extract(path string) ([]Embedded, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".flac":
+		stream, err := flac.ReadStream(f, flac.ReadCfg{ReadMetadata: true, ReadFrames: false})
+		if err != nil {
+			return nil, err
+		}
+
+		result := []Embedded{}
+		for _, block := range stream.Metadata {
+			if pic, ok := block.(flac.Picture); ok {
+				result = append(result, Embedded{
+					PicType:  pic.PicType,
+					Desc:     pic.Desc,
+					MimeType: pic.MimeType,
+					Data:     pic.Data,
+				})
+			}
+		}
+		return result, nil
+
+	case ".m4a", ".m4b", ".mp4", ".alac":
+		covers, err := mp4.ReadCovers(f)
+		if err != nil {
+			return nil, err
+		}
+
+		// There's no picture type in MP4, the first cover is considered the front one
+		result := []Embedded{}
+		for i, cover := range covers {
+			picType := flac.PicTypeCoverFront
+			if i > 0 {
+				picType = flac.PicTypeOther
+			}
+			result = append(result, Embedded{
+				PicType:  picType,
+				MimeType: cover.MimeType,
+				Data:     cover.Data,
+			})
+		}
+		return result, nil
+
+	default:
+		input := bufio.NewReader(f)
+		magic, err := input.Peek(len(id3Magic))
+		if err != nil || !bytes.Equal(magic, id3Magic) {
+			return []Embedded{}, nil
+		}
+
+		tag, err := id3v2.ReadTag(input)
+		if err != nil {
+			return nil, err
+		}
+		pics, err := tag.Pictures()
+		if err != nil {
+			return nil, err
+		}
+
+		result := []Embedded{}
+		for _, pic := range pics {
+			result = append(result, Embedded{
+				PicType:  flac.PicType(pic.PicType),
+				Desc:     pic.Desc,
+				MimeType: pic.MimeType,
+				Data:     pic.Data,
+			})
+		}
+		return result, nil
+	}
+}
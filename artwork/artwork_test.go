@@ -0,0 +1,156 @@
+package artwork
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file/flac"
+	"github.com/wetfloo/voidh/file/mp4"
+)
+
+func flacEncode(t *testing.T, pic flac.Picture) []byte {
+	streamInfo := flac.RawBlock{
+		Type: flac.MetadataBlockTypeStreamInfo,
+		Data: make([]byte, 34),
+	}
+	var buf bytes.Buffer
+	err := flac.WriteRawMetadata(&buf, []flac.RawBlock{streamInfo, pic.Marshal()})
+	assert.Nil(t, err)
+	return buf.Bytes()
+}
+
+// ID3v2.3 tag with a single APIC frame, followed by something resembling audio
+func id3Encode(pic Embedded) []byte {
+	frameData := []byte{0}
+	frameData = append(frameData, []byte(pic.MimeType+"\x00")...)
+	frameData = append(frameData, byte(pic.PicType))
+	frameData = append(frameData, []byte(pic.Desc+"\x00")...)
+	frameData = append(frameData, pic.Data...)
+
+	frame := []byte("APIC")
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(frameData)))
+	frame = append(frame, 0, 0)
+	frame = append(frame, frameData...)
+
+	// Sizes of tags are syncsafe, 7 bits in each byte
+	size := len(frame)
+	result := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	result = append(result, frame...)
+	return append(result, 0xFF, 0xFB, 0x90, 0x00)
+}
+
+func mp4Atom(name string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	result := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	result = append(result, name...)
+	return append(result, body...)
+}
+
+type mp4Cover struct {
+	dataType uint32
+	data     []byte
+}
+
+// File with a 'covr' item, holding a data atom for each of the covers
+func mp4Encode(covers ...mp4Cover) []byte {
+	datas := [][]byte{}
+	for _, cover := range covers {
+		data := binary.BigEndian.AppendUint32(nil, cover.dataType)
+		data = append(data, 0, 0, 0, 0)
+		datas = append(datas, mp4Atom("data", data, cover.data))
+	}
+
+	// 'meta' is a full atom, with 4 bytes of version and flags before its children
+	return append(
+		mp4Atom("ftyp", []byte("M4A \x00\x00\x00\x00")),
+		mp4Atom("moov", mp4Atom("udta", mp4Atom("meta", []byte{0, 0, 0, 0}, mp4Atom("ilst", mp4Atom("covr", datas...)))))...,
+	)
+}
+
+func TestExtract(t *testing.T) {
+	png := pngEncode(t, 4, 4)
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE0}
+
+	tests := []struct {
+		name     string
+		contents []byte
+		expected []Embedded
+	}{
+		{
+			name: "song.flac",
+			contents: flacEncode(t, flac.Picture{
+				PicType:  flac.PicTypeCoverBack,
+				MimeType: "image/png",
+				Desc:     "back",
+				Width:    4,
+				Height:   4,
+				Data:     png,
+			}),
+			expected: []Embedded{{PicType: flac.PicTypeCoverBack, Desc: "back", MimeType: "image/png", Data: png}},
+		},
+		{
+			name:     "song.mp3",
+			contents: id3Encode(Embedded{PicType: flac.PicTypeCoverFront, Desc: "front", MimeType: "image/jpeg", Data: jpeg}),
+			expected: []Embedded{{PicType: flac.PicTypeCoverFront, Desc: "front", MimeType: "image/jpeg", Data: jpeg}},
+		},
+		{
+			// ID3 tags are found by their contents, not by the extension
+			name:     "song.wav",
+			contents: id3Encode(Embedded{PicType: flac.PicTypeArtist, MimeType: "image/png", Data: png}),
+			expected: []Embedded{{PicType: flac.PicTypeArtist, MimeType: "image/png", Data: png}},
+		},
+		{
+			name:     "song.m4a",
+			contents: mp4Encode(mp4Cover{mp4.DataTypeJpeg, jpeg}, mp4Cover{mp4.DataTypePng, png}),
+			expected: []Embedded{
+				{PicType: flac.PicTypeCoverFront, MimeType: "image/jpeg", Data: jpeg},
+				{PicType: flac.PicTypeOther, MimeType: "image/png", Data: png},
+			},
+		},
+		{
+			name:     "empty.m4a",
+			contents: mp4Encode(),
+			expected: []Embedded{},
+		},
+		{
+			name:     "song.ogg",
+			contents: []byte("OggS"),
+			expected: []Embedded{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.name)
+			assert.Nil(t, os.WriteFile(path, test.contents, 0o644))
+
+			result, err := Extract(path)
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestSidecars(t *testing.T) {
+	dir := t.TempDir()
+	png := pngEncode(t, 4, 4)
+	for _, name := range []string{"cover.jpg", "Folder.PNG", "back.png", "notes.jpg", "cover.txt"} {
+		assert.Nil(t, os.WriteFile(filepath.Join(dir, name), png, 0o644))
+	}
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "front.jpg"), 0o755))
+
+	result, err := Sidecars(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []Embedded{
+		{PicType: flac.PicTypeCoverFront, Desc: "Folder.PNG", MimeType: "image/png", Data: png},
+		{PicType: flac.PicTypeCoverBack, Desc: "back.png", MimeType: "image/png", Data: png},
+		{PicType: flac.PicTypeCoverFront, Desc: "cover.jpg", MimeType: "image/jpeg", Data: png},
+	}, result)
+
+	assert.True(t, IsSidecar("/music/Album/folder.png"))
+	assert.False(t, IsSidecar("/music/Album/notes.jpg"))
+}
@@ -0,0 +1,100 @@
+package artwork
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+// On-disk storage of pictures, deduplicated by the hash of their contents
+type Cache struct {
+	dir      string
+	thumbCfg ThumbCfg
+}
+
+func NewCache(dir string, thumbCfg ThumbCfg) (Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Cache{}, err
+	}
+	return Cache{dir: dir, thumbCfg: thumbCfg}, nil
+}
+
+// Stores the picture in the cache, unless the same one is already there
+func (cache Cache) Put(pic Embedded) (file.PictureLink, error) {
+	hash := sha1.Sum(pic.Data)
+	result := file.PictureLink{
+		Picture: file.Picture{
+			Hash:     hash[:],
+			MimeType: pic.MimeType,
+			Size:     uint64(len(pic.Data)),
+		},
+		PicType: uint32(pic.PicType),
+		Desc:    pic.Desc,
+	}
+
+	// Declared mime type and dimensions are only used if the image headers can't be read
+	if info, err := ImageInfoRead(pic.Data); err == nil {
+		result.MimeType = info.MimeType
+		result.Width = info.Width
+		result.Height = info.Height
+	}
+	// Thumbnails are only made of images there's a decoder for, and that are small enough to decode
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(pic.Data))
+	decodable := err == nil && cache.thumbCfg.decodable(cfg)
+
+	path := cache.Path(result.Hash)
+	if _, err := os.Stat(path); err != nil {
+		if err := pictureWrite(path, pic.Data); err != nil {
+			return result, err
+		}
+	}
+
+	// Thumbnails of pictures already in the cache may be missing, if they were cached
+	// before Eager was set, or if making them failed back then. Existing ones are kept
+	if decodable && cache.thumbCfg.Eager {
+		return result, cache.thumbnailsMake(result.Hash)
+	}
+	return result, nil
+}
+
+// Writes to a temporary file first, so a crash never leaves a truncated picture under a valid hash
+func pictureWrite(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Path of the cached picture with the given hash. Pictures are spread across
+// subdirectories by the first byte of their hash, to keep directories small
+func (cache Cache) Path(hash []byte) string {
+	hexHash := hex.EncodeToString(hash)
+	return filepath.Join(cache.dir, hexHash[:2], hexHash)
+}
+
+func (cache Cache) Open(hash []byte) (*os.File, error) {
+	return os.Open(cache.Path(hash))
+}
@@ -0,0 +1,96 @@
+package artwork
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file/flac"
+)
+
+func TestCachePutDedup(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultThumbCfg()
+	cfg.Eager = false
+	cache, err := NewCache(dir, cfg)
+	assert.Nil(t, err)
+
+	png := pngEncode(t, 4, 2)
+	front, err := cache.Put(Embedded{PicType: flac.PicTypeCoverFront, MimeType: "image/jpeg", Data: png})
+	assert.Nil(t, err)
+	// Decoded properties win over the declared ones
+	assert.Equal(t, "image/png", front.MimeType)
+	assert.EqualValues(t, 4, front.Width)
+	assert.EqualValues(t, 2, front.Height)
+
+	back, err := cache.Put(Embedded{PicType: flac.PicTypeCoverBack, Desc: "back", MimeType: "image/png", Data: png})
+	assert.Nil(t, err)
+	assert.Equal(t, front.Picture, back.Picture)
+	assert.EqualValues(t, flac.PicTypeCoverBack, back.PicType)
+	assert.Equal(t, "back", back.Desc)
+
+	// The same picture is stored once, and nothing is left behind by writing it
+	files := []string{}
+	err = filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{cache.Path(front.Hash)}, files)
+
+	stored, err := os.ReadFile(cache.Path(front.Hash))
+	assert.Nil(t, err)
+	assert.Equal(t, png, stored)
+}
+
+func TestCachePutEagerCached(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultThumbCfg()
+	cfg.Eager = false
+	lazy, err := NewCache(dir, cfg)
+	assert.Nil(t, err)
+	pic := Embedded{PicType: flac.PicTypeCoverFront, MimeType: "image/png", Data: pngEncode(t, 4, 4)}
+	link, err := lazy.Put(pic)
+	assert.Nil(t, err)
+
+	// Thumbnails are made for pictures cached before, as soon as they're put again
+	cfg.Eager = true
+	eager, err := NewCache(dir, cfg)
+	assert.Nil(t, err)
+	_, err = eager.Put(pic)
+	assert.Nil(t, err)
+	for _, size := range cfg.Sizes {
+		assert.FileExists(t, eager.ThumbnailPath(link.Hash, size))
+	}
+}
+
+func TestCachePutWebp(t *testing.T) {
+	cache, err := NewCache(t.TempDir(), DefaultThumbCfg())
+	assert.Nil(t, err)
+
+	// There's no decoder for it, its headers are read all the same
+	link, err := cache.Put(Embedded{PicType: flac.PicTypeCoverFront, MimeType: "image/jpeg", Data: webpEncode(100, 50)})
+	assert.Nil(t, err)
+	assert.Equal(t, "image/webp", link.MimeType)
+	assert.EqualValues(t, 100, link.Width)
+	assert.EqualValues(t, 50, link.Height)
+	assert.FileExists(t, cache.Path(link.Hash))
+}
+
+func TestCachePutTooLarge(t *testing.T) {
+	cfg := DefaultThumbCfg()
+	cfg.MaxPixels = 15
+	cache, err := NewCache(t.TempDir(), cfg)
+	assert.Nil(t, err)
+
+	// Cached without thumbnails, which can't be made later either
+	link, err := cache.Put(Embedded{PicType: flac.PicTypeCoverFront, MimeType: "image/png", Data: pngEncode(t, 4, 4)})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 4, link.Width)
+	assert.NoFileExists(t, cache.ThumbnailPath(link.Hash, cfg.Sizes[0]))
+	_, err = cache.Thumbnail(link.Hash, cfg.Sizes[0])
+	assert.ErrorIs(t, err, ImageTooLargeErr)
+}
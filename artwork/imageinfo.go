@@ -0,0 +1,194 @@
+package artwork
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+var UnknownImageFormatErr = fmt.Errorf("unknown image format")
+
+// What the image headers say about the image itself
+type ImageInfo struct {
+	MimeType string
+	Width    uint32
+	Height   uint32
+	// Bits per pixel
+	ColorDepth uint32
+	// Amount of colors in the palette, for indexed images. Zero otherwise
+	ColorsCount uint32
+}
+
+var (
+	pngMagic  = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+	jpegMagic = []byte{0xFF, 0xD8}
+	gifMagic  = []byte("GIF8")
+	riffMagic = []byte("RIFF")
+	webpMagic = []byte("WEBP")
+)
+
+// Reads image properties out of its headers, without decoding the image itself
+func ImageInfoRead(data []byte) (ImageInfo, error) {
+	switch {
+	case bytes.HasPrefix(data, pngMagic):
+		return pngInfoRead(data)
+	case bytes.HasPrefix(data, jpegMagic):
+		return jpegInfoRead(data)
+	case bytes.HasPrefix(data, gifMagic):
+		return gifInfoRead(data)
+	case len(data) >= 12 && bytes.Equal(data[:4], riffMagic) && bytes.Equal(data[8:12], webpMagic):
+		return webpInfoRead(data)
+	}
+
+	return ImageInfo{}, UnknownImageFormatErr
+}
+
+func pngInfoRead(data []byte) (ImageInfo, error) {
+	result := ImageInfo{MimeType: "image/png"}
+
+	// Signature, then IHDR chunk length and type
+	const ihdrOffset = 8 + 4 + 4
+	if len(data) < ihdrOffset+10 || string(data[12:16]) != "IHDR" {
+		return result, fmt.Errorf("png: no IHDR chunk")
+	}
+	ihdr := data[ihdrOffset:]
+	result.Width = binary.BigEndian.Uint32(ihdr[0:4])
+	result.Height = binary.BigEndian.Uint32(ihdr[4:8])
+	bitDepth := uint32(ihdr[8])
+
+	switch colorType := ihdr[9]; colorType {
+	case 0:
+		result.ColorDepth = bitDepth
+	case 2:
+		result.ColorDepth = bitDepth * 3
+	case 3:
+		result.ColorDepth = bitDepth
+		result.ColorsCount = pngPaletteLen(data)
+	case 4:
+		result.ColorDepth = bitDepth * 2
+	case 6:
+		result.ColorDepth = bitDepth * 4
+	default:
+		return result, fmt.Errorf("png: invalid color type %d", colorType)
+	}
+
+	return result, nil
+}
+
+// Amount of entries in the PLTE chunk, if there's one
+func pngPaletteLen(data []byte) uint32 {
+	pos := len(pngMagic)
+	for pos+8 <= len(data) {
+		chunkLen := binary.BigEndian.Uint32(data[pos : pos+4])
+		switch string(data[pos+4 : pos+8]) {
+		case "PLTE":
+			return chunkLen / 3
+		case "IDAT", "IEND":
+			return 0
+		}
+		// Length, type, data and CRC
+		pos += 8 + int(chunkLen) + 4
+	}
+	return 0
+}
+
+func jpegInfoRead(data []byte) (ImageInfo, error) {
+	result := ImageInfo{MimeType: "image/jpeg"}
+
+	pos := len(jpegMagic)
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return result, fmt.Errorf("jpeg: expected a marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		// Fill bytes
+		if marker == 0xFF {
+			pos += 1
+			continue
+		}
+		// Standalone markers have no length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			pos += 2
+			continue
+		}
+
+		segmentLen := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		isSof := marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC
+		if isSof {
+			if segmentLen < 8 || pos+2+segmentLen > len(data) {
+				return result, fmt.Errorf("jpeg: truncated frame header")
+			}
+			sof := data[pos+4:]
+			precision := uint32(sof[0])
+			result.Height = uint32(binary.BigEndian.Uint16(sof[1:3]))
+			result.Width = uint32(binary.BigEndian.Uint16(sof[3:5]))
+			result.ColorDepth = precision * uint32(sof[5])
+			return result, nil
+		}
+
+		pos += 2 + segmentLen
+	}
+
+	return result, fmt.Errorf("jpeg: no frame header found")
+}
+
+func gifInfoRead(data []byte) (ImageInfo, error) {
+	result := ImageInfo{MimeType: "image/gif"}
+
+	// Signature with version, then logical screen descriptor
+	if len(data) < 13 {
+		return result, fmt.Errorf("gif: truncated header")
+	}
+	result.Width = uint32(binary.LittleEndian.Uint16(data[6:8]))
+	result.Height = uint32(binary.LittleEndian.Uint16(data[8:10]))
+
+	packed := data[10]
+	bitsPerPixel := uint32(packed&0b111) + 1
+	result.ColorDepth = bitsPerPixel
+	if packed&0b1000_0000 != 0 {
+		result.ColorsCount = 1 << bitsPerPixel
+	}
+
+	return result, nil
+}
+
+func webpInfoRead(data []byte) (ImageInfo, error) {
+	result := ImageInfo{MimeType: "image/webp", ColorDepth: 24}
+
+	// RIFF header, then the first chunk header
+	const chunkOffset = 12
+	if len(data) < chunkOffset+8+10 {
+		return result, fmt.Errorf("webp: truncated header")
+	}
+	chunk := data[chunkOffset+8:]
+
+	switch string(data[chunkOffset : chunkOffset+4]) {
+	case "VP8 ":
+		// Frame tag, then start code
+		if !bytes.Equal(chunk[3:6], []byte{0x9D, 0x01, 0x2A}) {
+			return result, fmt.Errorf("webp: invalid VP8 start code")
+		}
+		result.Width = uint32(binary.LittleEndian.Uint16(chunk[6:8]) & 0x3FFF)
+		result.Height = uint32(binary.LittleEndian.Uint16(chunk[8:10]) & 0x3FFF)
+	case "VP8L":
+		if chunk[0] != 0x2F {
+			return result, fmt.Errorf("webp: invalid VP8L signature")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[1:5])
+		result.Width = bits&0x3FFF + 1
+		result.Height = (bits>>14)&0x3FFF + 1
+		if bits&(1<<28) != 0 {
+			result.ColorDepth = 32
+		}
+	case "VP8X":
+		if chunk[0]&0b0001_0000 != 0 {
+			result.ColorDepth = 32
+		}
+		result.Width = (uint32(chunk[4]) | uint32(chunk[5])<<8 | uint32(chunk[6])<<16) + 1
+		result.Height = (uint32(chunk[7]) | uint32(chunk[8])<<8 | uint32(chunk[9])<<16) + 1
+	default:
+		return result, fmt.Errorf("webp: unknown chunk %q", data[chunkOffset:chunkOffset+4])
+	}
+
+	return result, nil
+}
@@ -0,0 +1,84 @@
+package artwork
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file/flac"
+)
+
+func pngEncode(t *testing.T, width int, height int) []byte {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)))
+	assert.Nil(t, err)
+	return buf.Bytes()
+}
+
+func TestImageInfoReadPng(t *testing.T) {
+	info, err := ImageInfoRead(pngEncode(t, 32, 16))
+	assert.Nil(t, err)
+	assert.Equal(t, "image/png", info.MimeType)
+	assert.EqualValues(t, 32, info.Width)
+	assert.EqualValues(t, 16, info.Height)
+}
+
+// Headers of a lossless WebP image, without any pixels
+func webpEncode(width int, height int) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBPVP8L\x00\x00\x00\x00")
+	// No alpha: 14 bits of width-1, then 14 bits of height-1
+	bits := uint32(width-1) | uint32(height-1)<<14
+	data = append(data, 0x2F, byte(bits), byte(bits>>8), byte(bits>>16), byte(bits>>24))
+	return append(data, make([]byte, 8)...)
+}
+
+func TestImageInfoReadWebpLossless(t *testing.T) {
+	info, err := ImageInfoRead(webpEncode(100, 50))
+	assert.Nil(t, err)
+	assert.Equal(t, "image/webp", info.MimeType)
+	assert.EqualValues(t, 100, info.Width)
+	assert.EqualValues(t, 50, info.Height)
+}
+
+func TestImageInfoReadUnknown(t *testing.T) {
+	_, err := ImageInfoRead([]byte("definitely not an image"))
+	assert.Equal(t, UnknownImageFormatErr, err)
+}
+
+func TestValidatePictureFileIcon(t *testing.T) {
+	pic := flac.Picture{
+		PicType:    flac.PicTypeFileIcon,
+		MimeType:   "image/png",
+		Width:      64,
+		Height:     64,
+		ColorDepth: 32,
+		Data:       pngEncode(t, 64, 64),
+	}
+
+	problems := ValidatePicture(pic, DefaultValidateCfg())
+	assert.Len(t, problems, 1)
+	assert.Equal(t, ProblemFileIconInvalid, problems[0].Kind)
+
+	fixed, ok := pictureFix(pic, problems)
+	assert.True(t, ok)
+	assert.Equal(t, flac.PicTypeOther, fixed.PicType)
+}
+
+func TestValidatePictureMismatch(t *testing.T) {
+	pic := flac.Picture{
+		PicType:    flac.PicTypeCoverFront,
+		MimeType:   "image/jpeg",
+		Width:      10,
+		Height:     20,
+		ColorDepth: 32,
+		Data:       pngEncode(t, 20, 20),
+	}
+
+	kinds := []PictureProblemKind{}
+	for _, problem := range ValidatePicture(pic, DefaultValidateCfg()) {
+		kinds = append(kinds, problem.Kind)
+	}
+	assert.Equal(t, []PictureProblemKind{ProblemMimeTypeMismatch, ProblemWidthMismatch}, kinds)
+}
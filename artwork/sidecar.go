@@ -0,0 +1,79 @@
+package artwork
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wetfloo/voidh/file/flac"
+)
+
+var sidecarNames = map[string]flac.PicType{
+	"cover":    flac.PicTypeCoverFront,
+	"front":    flac.PicTypeCoverFront,
+	"folder":   flac.PicTypeCoverFront,
+	"albumart": flac.PicTypeCoverFront,
+	"back":     flac.PicTypeCoverBack,
+}
+
+var sidecarExts = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// Checks if the file is a picture, describing the whole album in its directory, like cover.jpg
+func IsSidecar(path string) bool {
+	_, _, ok := sidecarKind(path)
+	return ok
+}
+
+// Reads all sidecar pictures in the album directory
+func Sidecars(dir string) ([]Embedded, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Embedded{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		picType, mimeType, ok := sidecarKind(entry.Name())
+		if !ok {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return result, err
+		}
+		result = append(result, Embedded{
+			PicType:  picType,
+			Desc:     entry.Name(),
+			MimeType: mimeType,
+			Data:     data,
+		})
+	}
+
+	return result, nil
+}
+
+func sidecarKind(path string) (flac.PicType, string, bool) {
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+
+	mimeType, ok := sidecarExts[strings.ToLower(ext)]
+	if !ok {
+		return 0, "", false
+	}
+	picType, ok := sidecarNames[strings.ToLower(strings.TrimSuffix(base, ext))]
+	if !ok {
+		return 0, "", false
+	}
+
+	return picType, mimeType, true
+}
@@ -0,0 +1,216 @@
+package artwork
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Returned for pictures with more pixels than [ThumbCfg] lets decode
+var ImageTooLargeErr = fmt.Errorf("image too large to decode")
+
+type ThumbFormat string
+
+const (
+	ThumbFormatJpeg ThumbFormat = "jpeg"
+	ThumbFormatPng  ThumbFormat = "png"
+)
+
+type ThumbCfg struct {
+	// Bounding box sizes, in pixels. Thumbnails keep the aspect ratio of the original and fit into a square
+	Sizes       []int
+	Format      ThumbFormat
+	JpegQuality int
+	// Whether to make thumbnails of all sizes as soon as a new picture is cached,
+	// instead of waiting for them to be requested
+	Eager bool
+	// Pictures with more pixels than that aren't decoded, they'd take 4 bytes of memory for each
+	MaxPixels int64
+}
+
+func DefaultThumbCfg() ThumbCfg {
+	return ThumbCfg{
+		Sizes:       []int{128, 256, 512},
+		Format:      ThumbFormatJpeg,
+		JpegQuality: 85,
+		Eager:       true,
+		MaxPixels:   6000 * 6000,
+	}
+}
+
+// Whether the image with the given header is small enough to be decoded
+func (cfg ThumbCfg) decodable(imageCfg image.Config) bool {
+	return int64(imageCfg.Width)*int64(imageCfg.Height) <= cfg.MaxPixels
+}
+
+func (format ThumbFormat) ext() string {
+	switch format {
+	case ThumbFormatPng:
+		return ".png"
+	default:
+		return ".jpg"
+	}
+}
+
+// Path of the thumbnail of the given size for the picture with the given hash, whether it exists or not
+func (cache Cache) ThumbnailPath(hash []byte, size int) string {
+	hexHash := hex.EncodeToString(hash)
+	return filepath.Join(
+		cache.dir,
+		"thumbs",
+		strconv.Itoa(size),
+		hexHash[:2],
+		hexHash+cache.thumbCfg.Format.ext(),
+	)
+}
+
+// Returns the path to the thumbnail of the given size, making it first if needed.
+// Pictures smaller than the requested size are never upscaled, just re-encoded
+func (cache Cache) Thumbnail(hash []byte, size int) (string, error) {
+	if size <= 0 {
+		return "", fmt.Errorf("invalid thumbnail size %d", size)
+	}
+
+	path := cache.ThumbnailPath(hash, size)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	src, err := cache.Open(hash)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	// Headers alone are read first, decoding the image takes memory for all of its pixels
+	imageCfg, _, err := image.DecodeConfig(bufio.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+	if !cache.thumbCfg.decodable(imageCfg) {
+		return "", fmt.Errorf("%dx%d: %w", imageCfg.Width, imageCfg.Height, ImageTooLargeErr)
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(bufio.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+
+	if err := cache.thumbnailWrite(path, resizeToFit(img, size)); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (cache Cache) thumbnailsMake(hash []byte) error {
+	for _, size := range cache.thumbCfg.Sizes {
+		if _, err := cache.Thumbnail(hash, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cache Cache) thumbnailWrite(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	output := bufio.NewWriter(tmp)
+	switch cache.thumbCfg.Format {
+	case ThumbFormatPng:
+		err = png.Encode(output, img)
+	default:
+		err = jpeg.Encode(output, img, &jpeg.Options{Quality: cache.thumbCfg.JpegQuality})
+	}
+	if err == nil {
+		err = output.Flush()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Scales the image down to fit into a square of the given size, keeping the aspect ratio
+func resizeToFit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW <= size && srcH <= size {
+		return img
+	}
+
+	dstW, dstH := size, size
+	if srcW > srcH {
+		dstH = max(1, srcH*size/srcW)
+	} else {
+		dstW = max(1, srcW*size/srcH)
+	}
+
+	// Working on a plain RGBA copy is much faster than going through the generic color interface
+	src := image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	return resizeBox(src, dstW, dstH)
+}
+
+// Downscales using a box filter: every destination pixel is an average
+// of all source pixels it covers. Not suited for upscaling
+func resizeBox(src *image.RGBA, dstW int, dstH int) *image.RGBA {
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y += 1 {
+		y0 := y * srcH / dstH
+		y1 := max(y0+1, (y+1)*srcH/dstH)
+
+		for x := 0; x < dstW; x += 1 {
+			x0 := x * srcW / dstW
+			x1 := max(x0+1, (x+1)*srcW/dstW)
+
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy += 1 {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx += 1 {
+					px := row[sx*4 : sx*4+4]
+					r += uint64(px[0])
+					g += uint64(px[1])
+					b += uint64(px[2])
+					a += uint64(px[3])
+					count += 1
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / count),
+				G: uint8(g / count),
+				B: uint8(b / count),
+				A: uint8(a / count),
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,31 @@
+package artwork
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResizeToFitKeepsAspectRatio(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	result := resizeToFit(src, 100)
+	assert.Equal(t, 100, result.Bounds().Dx())
+	assert.Equal(t, 50, result.Bounds().Dy())
+}
+
+func TestResizeToFitNoUpscale(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	result := resizeToFit(src, 100)
+	assert.Equal(t, src.Bounds(), result.Bounds())
+}
+
+func TestResizeBoxAverages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.SetRGBA(0, 0, color.RGBA{R: 200, A: 255})
+	src.SetRGBA(1, 0, color.RGBA{R: 100, A: 255})
+
+	result := resizeBox(src, 1, 1)
+	assert.Equal(t, color.RGBA{R: 150, A: 255}, result.RGBAAt(0, 0))
+}
@@ -0,0 +1,241 @@
+package artwork
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/wetfloo/voidh/file/flac"
+)
+
+type PictureProblemKind int
+
+const (
+	ProblemUndecodable PictureProblemKind = iota
+	ProblemMimeTypeMismatch
+	ProblemWidthMismatch
+	ProblemHeightMismatch
+	ProblemColorDepthMismatch
+	ProblemColorsCountMismatch
+	ProblemOversize
+	ProblemFileIconInvalid
+)
+
+type PictureProblem struct {
+	Kind     PictureProblemKind
+	Declared string
+	Actual   string
+}
+
+func (problem PictureProblem) String() string {
+	switch problem.Kind {
+	case ProblemUndecodable:
+		return fmt.Sprintf("image can't be decoded: %s", problem.Actual)
+	case ProblemMimeTypeMismatch:
+		return fmt.Sprintf("declared mime type is %s, but the image is %s", problem.Declared, problem.Actual)
+	case ProblemWidthMismatch:
+		return fmt.Sprintf("declared width is %s, but the image is %s pixels wide", problem.Declared, problem.Actual)
+	case ProblemHeightMismatch:
+		return fmt.Sprintf("declared height is %s, but the image is %s pixels high", problem.Declared, problem.Actual)
+	case ProblemColorDepthMismatch:
+		return fmt.Sprintf("declared color depth is %s, but the image has %s bits per pixel", problem.Declared, problem.Actual)
+	case ProblemColorsCountMismatch:
+		return fmt.Sprintf("declared colors count is %s, but the image palette has %s", problem.Declared, problem.Actual)
+	case ProblemOversize:
+		return fmt.Sprintf("image is too big: %s, the limit is %s", problem.Actual, problem.Declared)
+	case ProblemFileIconInvalid:
+		return fmt.Sprintf("file icon must be a 32x32 PNG, but is %s", problem.Actual)
+	}
+	return "unknown problem"
+}
+
+type ValidateCfg struct {
+	// Embedded pictures with more bytes than this are reported. Zero disables the check
+	MaxSize uint64
+	// Embedded pictures wider or higher than this are reported. Zero disables the check
+	MaxDimension uint32
+}
+
+func DefaultValidateCfg() ValidateCfg {
+	return ValidateCfg{
+		MaxSize:      1 << 20,
+		MaxDimension: 3000,
+	}
+}
+
+// Checks the declared properties of the PICTURE block against the image data it carries
+func ValidatePicture(pic flac.Picture, cfg ValidateCfg) []PictureProblem {
+	result := []PictureProblem{}
+
+	if cfg.MaxSize > 0 && uint64(len(pic.Data)) > cfg.MaxSize {
+		result = append(result, PictureProblem{
+			Kind:     ProblemOversize,
+			Declared: fmt.Sprintf("%d bytes", cfg.MaxSize),
+			Actual:   fmt.Sprintf("%d bytes", len(pic.Data)),
+		})
+	}
+
+	// Pictures can be linked instead of embedded, there's no data to validate then
+	if pic.MimeType == "-->" {
+		return result
+	}
+
+	info, err := ImageInfoRead(pic.Data)
+	if err != nil {
+		return append(result, PictureProblem{Kind: ProblemUndecodable, Actual: err.Error()})
+	}
+
+	if !mimeTypeEq(pic.MimeType, info.MimeType) {
+		result = append(result, PictureProblem{
+			Kind:     ProblemMimeTypeMismatch,
+			Declared: pic.MimeType,
+			Actual:   info.MimeType,
+		})
+	}
+	if pic.Width != info.Width {
+		result = append(result, uintMismatch(ProblemWidthMismatch, pic.Width, info.Width))
+	}
+	if pic.Height != info.Height {
+		result = append(result, uintMismatch(ProblemHeightMismatch, pic.Height, info.Height))
+	}
+	if pic.ColorDepth != info.ColorDepth {
+		result = append(result, uintMismatch(ProblemColorDepthMismatch, pic.ColorDepth, info.ColorDepth))
+	}
+	if pic.ColorsCount != info.ColorsCount {
+		result = append(result, uintMismatch(ProblemColorsCountMismatch, pic.ColorsCount, info.ColorsCount))
+	}
+
+	if cfg.MaxDimension > 0 && (info.Width > cfg.MaxDimension || info.Height > cfg.MaxDimension) {
+		result = append(result, PictureProblem{
+			Kind:     ProblemOversize,
+			Declared: fmt.Sprintf("%dx%d", cfg.MaxDimension, cfg.MaxDimension),
+			Actual:   fmt.Sprintf("%dx%d", info.Width, info.Height),
+		})
+	}
+
+	if pic.PicType == flac.PicTypeFileIcon &&
+		(info.MimeType != "image/png" || info.Width != 32 || info.Height != 32) {
+		result = append(result, PictureProblem{
+			Kind:   ProblemFileIconInvalid,
+			Actual: fmt.Sprintf("%dx%d %s", info.Width, info.Height, info.MimeType),
+		})
+	}
+
+	return result
+}
+
+// Validates all pictures in the FLAC file. With fix enabled, declared properties are
+// rewritten to match the actual image, and invalid file icons are demoted to other pictures.
+// Oversize pictures can't be fixed without re-encoding them, so they're only reported
+func ValidateFlac(path string, cfg ValidateCfg, fix bool) ([][]PictureProblem, error) {
+	result := [][]PictureProblem{}
+	changed := false
+
+	edit := func(blocks []flac.RawBlock) ([]flac.RawBlock, error) {
+		for i, block := range blocks {
+			if block.Type != flac.MetadataTypePicture {
+				continue
+			}
+			decoded, err := block.Decode()
+			if err != nil {
+				return blocks, err
+			}
+			pic := decoded.(flac.Picture)
+
+			problems := ValidatePicture(pic, cfg)
+			result = append(result, problems)
+			if !fix {
+				continue
+			}
+
+			fixed, ok := pictureFix(pic, problems)
+			if ok {
+				blocks[i] = fixed.Marshal()
+				changed = true
+			}
+		}
+		return blocks, nil
+	}
+
+	if !fix {
+		err := flacMetadataRead(path, edit)
+		return result, err
+	}
+
+	err := flac.RewriteMetadata(path, func(blocks []flac.RawBlock) ([]flac.RawBlock, error) {
+		blocks, err := edit(blocks)
+		if err == nil && !changed {
+			return blocks, errNothingToFix
+		}
+		return blocks, err
+	})
+	if err == errNothingToFix {
+		err = nil
+	}
+	return result, err
+}
+
+// Aborts the rewrite, so files without problems are left untouched
+var errNothingToFix = fmt.Errorf("nothing to fix")
+
+func pictureFix(pic flac.Picture, problems []PictureProblem) (flac.Picture, bool) {
+	fixable := false
+	for _, problem := range problems {
+		if problem.Kind != ProblemOversize && problem.Kind != ProblemUndecodable {
+			fixable = true
+		}
+	}
+	if !fixable {
+		return pic, false
+	}
+
+	info, err := ImageInfoRead(pic.Data)
+	if err != nil {
+		return pic, false
+	}
+
+	pic.MimeType = info.MimeType
+	pic.Width = info.Width
+	pic.Height = info.Height
+	pic.ColorDepth = info.ColorDepth
+	pic.ColorsCount = info.ColorsCount
+	if pic.PicType == flac.PicTypeFileIcon &&
+		(info.MimeType != "image/png" || info.Width != 32 || info.Height != 32) {
+		pic.PicType = flac.PicTypeOther
+	}
+
+	return pic, true
+}
+
+func flacMetadataRead(path string, visit func([]flac.RawBlock) ([]flac.RawBlock, error)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	blocks, err := flac.ReadRawMetadata(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	_, err = visit(blocks)
+	return err
+}
+
+// image/jpg is a common misspelling, which is not worth reporting
+func mimeTypeEq(declared string, actual string) bool {
+	declared = strings.ToLower(strings.TrimSpace(declared))
+	if declared == "image/jpg" {
+		declared = "image/jpeg"
+	}
+	return declared == actual
+}
+
+func uintMismatch(kind PictureProblemKind, declared uint32, actual uint32) PictureProblem {
+	return PictureProblem{
+		Kind:     kind,
+		Declared: fmt.Sprint(declared),
+		Actual:   fmt.Sprint(actual),
+	}
+}
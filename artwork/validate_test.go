@@ -0,0 +1,65 @@
+package artwork
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file/flac"
+)
+
+func TestValidateFlacFix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.flac")
+	png := pngEncode(t, 20, 20)
+	contents := flacEncode(t, flac.Picture{
+		PicType:    flac.PicTypeCoverFront,
+		MimeType:   "image/jpeg",
+		Desc:       "front",
+		Width:      10,
+		Height:     20,
+		ColorDepth: 32,
+		Data:       png,
+	})
+	assert.Nil(t, os.WriteFile(path, contents, 0o644))
+
+	// Without fix, problems are only reported
+	problems, err := ValidateFlac(path, DefaultValidateCfg(), false)
+	assert.Nil(t, err)
+	assert.Len(t, problems, 1)
+	assert.Len(t, problems[0], 2)
+	written, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, contents, written)
+
+	problems, err = ValidateFlac(path, DefaultValidateCfg(), true)
+	assert.Nil(t, err)
+	assert.Len(t, problems[0], 2)
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	stream, err := flac.ReadStream(f, flac.ReadCfg{ReadMetadata: true, ReadFrames: false})
+	f.Close()
+	assert.Nil(t, err)
+	assert.Len(t, stream.Metadata, 2)
+	assert.Equal(t, flac.Picture{
+		PicType:    flac.PicTypeCoverFront,
+		MimeType:   "image/png",
+		Desc:       "front",
+		Width:      20,
+		Height:     20,
+		ColorDepth: 32,
+		Data:       png,
+	}, stream.Metadata[1])
+
+	// Fixed files are left as they are
+	fixed, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	problems, err = ValidateFlac(path, DefaultValidateCfg(), true)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]PictureProblem{{}}, problems)
+	written, err = os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(fixed, written))
+}
@@ -0,0 +1,224 @@
+package cas
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wetfloo/voidh/repo"
+)
+
+// How files get into the store, and out of it into the directory tree
+type LinkMode int
+
+const (
+	// Files share the inode with their blob, which is made read-only, and so are they.
+	// Needs the store on the same filesystem as the files
+	LinkHard LinkMode = iota
+	// Files share the data with their blob until either is written to. Needs a filesystem
+	// that supports it, like Btrfs or XFS, and is only available on Linux
+	LinkReflink
+)
+
+// Returned for [LinkReflink] where reflinks can't be made
+var ReflinkUnsupportedErr = fmt.Errorf("reflinks are not supported here")
+
+// Returned by [Store.Materialize] for blobs that aren't in the store
+var BlobNotFoundErr = fmt.Errorf("blob is not in the store")
+
+type Cfg struct {
+	Mode LinkMode
+	// How long unreferenced blobs are kept around, so that the ones
+	// ingested right before their files are indexed aren't collected
+	GcGrace time.Duration
+}
+
+func DefaultCfg() Cfg {
+	return Cfg{
+		Mode:    LinkHard,
+		GcGrace: time.Hour,
+	}
+}
+
+// Immutable storage of files, deduplicated by the hash of their contents. Blobs
+// are keyed by content hashes only: files with the same audio and different
+// tags are different files, and one can't be linked in place of the other
+type Store struct {
+	dir string
+	cfg Cfg
+}
+
+type GcStats struct {
+	Blobs   int
+	Removed int
+	// Bytes freed by removing blobs. Space is only freed on disk once no file links to the blob
+	Freed int64
+}
+
+// Prefix of the names files get while they are being linked, both in the store and in the library
+const TempPrefix = ".tmp-"
+
+// Directory of the store, relative to the library root
+const storeDir = ".voidh/blobs"
+
+// Opens the store of the library at the root, making it if it's not there yet
+func New(root string, cfg Cfg) (Store, error) {
+	dir := filepath.Join(root, filepath.FromSlash(storeDir))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return Store{}, err
+	}
+	return Store{dir: dir, cfg: cfg}, nil
+}
+
+// Path of the blob with the given hash. Blobs are spread across
+// subdirectories by the first byte of their hash, to keep directories small
+func (store Store) Path(hash []byte) string {
+	hexHash := hex.EncodeToString(hash)
+	return filepath.Join(store.dir, hexHash[:2], hexHash)
+}
+
+func (store Store) Has(hash []byte) bool {
+	_, err := os.Stat(store.Path(hash))
+	return err == nil
+}
+
+// Puts the file with the given hash into the store. New contents are linked into
+// the store as they are, files with contents that are already there are replaced
+// with the link to the existing blob. The hash is trusted, it's the caller that
+// has just computed it
+func (store Store) Ingest(path string, hash []byte) error {
+	blob := store.Path(hash)
+	blobInfo, err := os.Stat(blob)
+	switch {
+	case err == nil:
+		// Files already linked to their blob are left as they are
+		if info, err := os.Stat(path); err == nil && os.SameFile(info, blobInfo) {
+			return nil
+		}
+		if store.cfg.Mode == LinkReflink {
+			// Reflinked files share the data already, if they were ingested before
+			return nil
+		}
+		return store.Materialize(hash, path)
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+		return err
+	}
+
+	// Link under a temporary name first, so that a crash never leaves a partial blob under a valid hash
+	tmp := filepath.Join(filepath.Dir(blob), TempPrefix+filepath.Base(blob))
+	os.Remove(tmp)
+	if err := store.link(path, tmp); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0o444); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, blob)
+}
+
+// Puts the blob with the given hash at the path, replacing whatever is there
+func (store Store) Materialize(hash []byte, path string) error {
+	blob := store.Path(hash)
+	if _, err := os.Stat(blob); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%x: %w", hash, BlobNotFoundErr)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// The file is swapped in one go, it's never missing or partial at the path
+	tmp := filepath.Join(filepath.Dir(path), TempPrefix+filepath.Base(path))
+	os.Remove(tmp)
+	if err := store.link(blob, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Number of files in the library with the contents of the blob
+func (store Store) Refs(lib repo.Store, hash []byte) (int, error) {
+	return lib.Count(repo.Eq(repo.Hash{}, hash))
+}
+
+// Removes blobs no file of the library refers to, once they have been in the store for longer than the grace period
+func (store Store) Gc(lib repo.Store) (GcStats, error) {
+	var result GcStats
+
+	err := filepath.WalkDir(store.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		hash, err := hex.DecodeString(entry.Name())
+		if err != nil {
+			// Leftovers of interrupted ingests, or something that was never ours
+			return nil
+		}
+		result.Blobs += 1
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if time.Since(ingestedAt(info)) < store.cfg.GcGrace {
+			return nil
+		}
+
+		refs, err := store.Refs(lib, hash)
+		if err != nil || refs > 0 {
+			return err
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		result.Removed += 1
+		result.Freed += info.Size()
+		return nil
+	})
+
+	return result, err
+}
+
+func (store Store) link(src string, dest string) error {
+	switch store.cfg.Mode {
+	case LinkReflink:
+		return reflink(src, dest)
+	default:
+		return os.Link(src, dest)
+	}
+}
+
+// Copies the file as a reflink, failing if the filesystem can't do that, instead of copying the data
+func reflink(src string, dest string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	destFile, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := fileClone(destFile, srcFile); err != nil {
+		destFile.Close()
+		os.Remove(dest)
+		return err
+	}
+	return destFile.Close()
+}
@@ -0,0 +1,117 @@
+package cas
+
+import (
+	"crypto/sha1"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/repo"
+)
+
+func testWrite(t *testing.T, path string, data string) []byte {
+	assert.Nil(t, os.WriteFile(path, []byte(data), 0o644))
+	hash := sha1.Sum([]byte(data))
+	return hash[:]
+}
+
+func testSameFile(t *testing.T, a string, b string) bool {
+	aInfo, err := os.Stat(a)
+	assert.Nil(t, err)
+	bInfo, err := os.Stat(b)
+	assert.Nil(t, err)
+	return os.SameFile(aInfo, bInfo)
+}
+
+func TestIngest(t *testing.T) {
+	root := t.TempDir()
+	store, err := New(root, DefaultCfg())
+	assert.Nil(t, err)
+
+	first := filepath.Join(root, "first.flac")
+	copied := filepath.Join(root, "copy.flac")
+	hash := testWrite(t, first, "audio")
+	testWrite(t, copied, "audio")
+
+	assert.Nil(t, store.Ingest(first, hash))
+	assert.True(t, testSameFile(t, first, store.Path(hash)))
+
+	// Blobs are immutable, and so are the files linked to them
+	info, err := os.Stat(first)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o444), info.Mode().Perm())
+
+	assert.Nil(t, store.Ingest(copied, hash))
+	assert.True(t, testSameFile(t, copied, store.Path(hash)))
+	assert.Nil(t, store.Ingest(copied, hash))
+
+	restored := filepath.Join(root, "restored", "song.flac")
+	assert.Nil(t, store.Materialize(hash, restored))
+	assert.True(t, testSameFile(t, restored, first))
+
+	err = store.Materialize([]byte{1}, restored)
+	assert.True(t, errors.Is(err, BlobNotFoundErr))
+}
+
+func TestIngestReflink(t *testing.T) {
+	root := t.TempDir()
+	store, err := New(root, Cfg{Mode: LinkReflink})
+	assert.Nil(t, err)
+
+	path := filepath.Join(root, "song.flac")
+	hash := testWrite(t, path, "audio")
+	err = store.Ingest(path, hash)
+	if errors.Is(err, ReflinkUnsupportedErr) {
+		t.Skip("the filesystem of the temporary directory has no reflinks")
+	}
+	assert.Nil(t, err)
+	assert.False(t, testSameFile(t, path, store.Path(hash)))
+	assert.True(t, store.Has(hash))
+}
+
+func TestGc(t *testing.T) {
+	root := t.TempDir()
+	store, err := New(root, Cfg{Mode: LinkHard, GcGrace: 0})
+	assert.Nil(t, err)
+	lib := repo.NewMemory()
+
+	kept := filepath.Join(root, "kept.flac")
+	keptHash := testWrite(t, kept, "kept")
+	gone := filepath.Join(root, "gone.flac")
+	goneHash := testWrite(t, gone, "gone")
+	assert.Nil(t, store.Ingest(kept, keptHash))
+	assert.Nil(t, store.Ingest(gone, goneHash))
+	assert.Nil(t, lib.Insert(file.FsFile{Name: kept, Hash: keptHash}))
+
+	refs, err := store.Refs(lib, keptHash)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, refs)
+
+	stats, err := store.Gc(lib)
+	assert.Nil(t, err)
+	assert.Equal(t, GcStats{Blobs: 2, Removed: 1, Freed: 4}, stats)
+	assert.True(t, store.Has(keptHash))
+	assert.False(t, store.Has(goneHash))
+}
+
+func TestGcGrace(t *testing.T) {
+	root := t.TempDir()
+	store, err := New(root, Cfg{Mode: LinkHard, GcGrace: time.Hour})
+	assert.Nil(t, err)
+
+	// Ingested just now, from a file that hasn't been modified for years
+	old := filepath.Join(root, "old.flac")
+	hash := testWrite(t, old, "old")
+	modTime := time.Now().AddDate(-5, 0, 0)
+	assert.Nil(t, os.Chtimes(old, modTime, modTime))
+	assert.Nil(t, store.Ingest(old, hash))
+
+	stats, err := store.Gc(repo.NewMemory())
+	assert.Nil(t, err)
+	assert.Equal(t, GcStats{Blobs: 1}, stats)
+	assert.True(t, store.Has(hash))
+}
@@ -0,0 +1,18 @@
+package cas
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// When the blob got into the store, as far as it can be told. Hard linked blobs share their modification
+// time with the files they came from, which can be years old. Linking and making them read-only on
+// ingest change their inode, which is recorded in the change time
+func ingestedAt(info fs.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.ModTime()
+	}
+	return time.Unix(stat.Ctim.Unix())
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package cas
+
+import (
+	"io/fs"
+	"time"
+)
+
+// There's no change time to go by here, so hard linked blobs are only protected
+// by the grace period if the files they came from were modified within it
+func ingestedAt(info fs.FileInfo) time.Time {
+	return info.ModTime()
+}
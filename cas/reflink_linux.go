@@ -0,0 +1,18 @@
+package cas
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func fileClone(dest *os.File, src *os.File) error {
+	err := unix.IoctlFileClone(int(dest.Fd()), int(src.Fd()))
+	// Filesystems without reflinks, or files on different filesystems
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EINVAL) {
+		return fmt.Errorf("%w: %w", ReflinkUnsupportedErr, err)
+	}
+	return err
+}
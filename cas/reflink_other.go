@@ -0,0 +1,12 @@
+//go:build !linux
+
+package cas
+
+import (
+	"os"
+)
+
+// Always fails with [ReflinkUnsupportedErr], there's no portable way to make reflinks
+func fileClone(_ *os.File, _ *os.File) error {
+	return ReflinkUnsupportedErr
+}
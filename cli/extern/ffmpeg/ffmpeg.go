@@ -1,11 +1,17 @@
 package ffmpeg
 
 import (
+	"bufio"
 	"context"
 	"encoding/hex"
 	"fmt"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/file/flac"
 )
 
 type FfmpegHashAlgo string
@@ -65,3 +71,146 @@ func AudioDataHash(ctx context.Context, path string, algo FfmpegHashAlgo) ([]byt
 
 	return hex.DecodeString(kv[1])
 }
+
+// Extracts the virtual track into its own FLAC file, sample-accurately, using ffmpeg.
+// Might return [*util.ExitError] if the command starts successfully, but fails to complete
+func ExtractVirtualTrack(ctx context.Context, track file.VirtualTrack, outPath string) error {
+	if track.SampleRate == 0 {
+		return fmt.Errorf("virtual track %d has no sample rate", track.TrackNum)
+	}
+	if track.EndSample <= track.StartSample {
+		return fmt.Errorf("virtual track %d has an empty sample range", track.TrackNum)
+	}
+
+	// Seeking on input to a whole second is exact, since it's a multiple of the sample rate.
+	// The rest is trimmed by the filter, which counts decoded samples, so the cut is sample-accurate
+	seekSeconds := track.StartSample / uint64(track.SampleRate)
+	trimStart := track.StartSample - seekSeconds*uint64(track.SampleRate)
+	trimEnd := trimStart + track.SamplesCount()
+
+	args := []string{
+		"-ss",
+		strconv.FormatUint(seekSeconds, 10),
+		"-i",
+		track.Source,
+		"-af",
+		fmt.Sprintf("atrim=start_sample=%d:end_sample=%d,asetpts=PTS-STARTPTS", trimStart, trimEnd),
+		"-map_metadata",
+		"-1",
+		"-codec:a",
+		"flac",
+		"-metadata",
+		fmt.Sprintf("TRACKNUMBER=%d", track.TrackNum),
+	}
+	if track.Title != "" {
+		args = append(args, "-metadata", "TITLE="+track.Title)
+	}
+	if track.Performer != "" {
+		args = append(args, "-metadata", "ARTIST="+track.Performer)
+	}
+	if track.Isrc != "" {
+		args = append(args, "-metadata", "ISRC="+track.Isrc)
+	}
+	args = append(args, "-loglevel", "warning", "-y", outPath)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	return cmd.Run()
+}
+
+// Reconstructs the original WAV or AIFF file out of the FLAC file, encoded with
+// `flac --keep-foreign-metadata`. Audio is decoded with ffmpeg, the rest comes from foreign chunks.
+// Might return [*util.ExitError] if the command starts successfully, but fails to complete
+func ExportForeign(ctx context.Context, flacPath string, outPath string) error {
+	f, err := os.Open(flacPath)
+	if err != nil {
+		return err
+	}
+	stream, err := flac.ReadStream(f, flac.ReadCfg{ReadMetadata: true, ReadFrames: false})
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	chunks, err := flac.ForeignChunks(stream)
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return fmt.Errorf("%s has no foreign metadata", flacPath)
+	}
+
+	var streamInfo *flac.StreamInfo
+	for _, block := range stream.Metadata {
+		if v, ok := block.(flac.StreamInfo); ok {
+			streamInfo = &v
+		}
+	}
+	if streamInfo == nil {
+		return fmt.Errorf("%s has no stream info", flacPath)
+	}
+
+	sampleFmt, err := foreignSampleFmt(chunks[0].AppId, streamInfo.BitsPerSample+1)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	cmd := exec.CommandContext(
+		ctx,
+		"ffmpeg",
+		"-i",
+		flacPath,
+		"-f",
+		sampleFmt,
+		"-codec:a",
+		"pcm_"+sampleFmt,
+		"-loglevel",
+		"warning",
+		"-",
+	)
+	audio, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	output := bufio.NewWriter(out)
+	reconstructErr := flac.ReconstructForeign(chunks, audio, output)
+	if reconstructErr != nil {
+		// Let ffmpeg know nobody is going to read the rest
+		audio.Close()
+	}
+	waitErr := cmd.Wait()
+
+	if reconstructErr != nil {
+		return reconstructErr
+	}
+	if waitErr != nil {
+		return waitErr
+	}
+	return output.Flush()
+}
+
+// Raw sample format, matching the one of the original file. WAV stores 8 bit samples unsigned
+func foreignSampleFmt(appId uint32, bitsPerSample uint8) (string, error) {
+	switch {
+	case appId == flac.AppIdRiff && bitsPerSample == 8:
+		return "u8", nil
+	case appId == flac.AppIdAiff && bitsPerSample == 8:
+		return "s8", nil
+	case bitsPerSample != 16 && bitsPerSample != 24 && bitsPerSample != 32:
+		return "", fmt.Errorf("unsupported bits per sample: %d", bitsPerSample)
+	case appId == flac.AppIdRiff:
+		return fmt.Sprintf("s%dle", bitsPerSample), nil
+	case appId == flac.AppIdAiff:
+		return fmt.Sprintf("s%dbe", bitsPerSample), nil
+	}
+	return "", fmt.Errorf("unsupported foreign metadata application id %x", appId)
+}
@@ -0,0 +1,233 @@
+package dupes
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/repo"
+)
+
+// What makes files of a group duplicates of each other
+type Kind string
+
+const (
+	// Files are the same byte for byte
+	KindContent Kind = "content"
+	// Audio is the same, tags are not
+	KindAudio Kind = "audio"
+	// Artist and title are the same and durations are close, the audio might be encoded differently
+	KindTags Kind = "tags"
+)
+
+type Group struct {
+	Kind Kind `json:"kind"`
+	// The file to keep, as recommended by the rules
+	Keeper string `json:"keeper"`
+	// All files of the group, the keeper included, best first
+	Files []string `json:"files"`
+}
+
+// Tells which of the two files is better to keep: positive for a, negative for b, zero if it can't tell
+type Rule func(a file.AudioFile, b file.AudioFile) int
+
+type Cfg struct {
+	// How much durations of files with the same artist and title can differ for them to be duplicates
+	DurationTolerance time.Duration
+	// Applied in order, until one of them can tell files apart. Ties are broken by file name
+	Rules []Rule
+}
+
+func DefaultCfg() Cfg {
+	return Cfg{
+		DurationTolerance: 2 * time.Second,
+		Rules:             []Rule{PreferLossless, PreferBitDepth, PreferTagsComplete},
+	}
+}
+
+// Rules by the names they are configured with
+var RulesByName = map[string]Rule{
+	"lossless": PreferLossless,
+	"bitdepth": PreferBitDepth,
+	"tags":     PreferTagsComplete,
+	"bitrate":  PreferBitrate,
+}
+
+var losslessCodecs = []string{"flac", "alac", "wav", "ape", "wavpack", "tta"}
+
+func PreferLossless(a file.AudioFile, b file.AudioFile) int {
+	return boolCompare(
+		slices.Contains(losslessCodecs, a.Properties.Codec),
+		slices.Contains(losslessCodecs, b.Properties.Codec),
+	)
+}
+
+// Prefers more bits per sample, then higher sample rate
+func PreferBitDepth(a file.AudioFile, b file.AudioFile) int {
+	if c := int(a.Properties.BitsPerSample) - int(b.Properties.BitsPerSample); c != 0 {
+		return c
+	}
+	return int(a.Properties.SampleRate) - int(b.Properties.SampleRate)
+}
+
+// Tags that make a track complete, as far as a library is concerned
+var completeTags = []string{
+	file.TagTitle,
+	file.TagArtist,
+	file.TagAlbum,
+	file.TagAlbumArtist,
+	file.TagTrackNumber,
+	file.TagDiscNumber,
+	file.TagDate,
+	file.TagGenre,
+	file.TagMbAlbumId,
+	file.TagMbTrackId,
+}
+
+// Prefers files with more of [completeTags] set
+func PreferTagsComplete(a file.AudioFile, b file.AudioFile) int {
+	return tagsComplete(a) - tagsComplete(b)
+}
+
+func PreferBitrate(a file.AudioFile, b file.AudioFile) int {
+	return int(a.Properties.Bitrate) - int(b.Properties.Bitrate)
+}
+
+// Groups duplicate files of the library. Files are reported once per set of
+// duplicates, so the files of the same contents aren't reported as tag duplicates too
+func Find(store repo.Store, cfg Cfg) ([]Group, error) {
+	files, err := store.FindAudio(repo.Query{Order: []repo.Order{{Key: repo.Filename{}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Group{}
+	report := func(kind Kind, group []file.AudioFile) {
+		if len(group) < 2 || reported(result, group) {
+			return
+		}
+		result = append(result, groupMake(kind, group, cfg.Rules))
+	}
+
+	for _, group := range groupBy(files, func(af file.AudioFile) string { return string(af.FsFile.Hash) }) {
+		report(KindContent, group)
+	}
+	for _, group := range groupBy(files, func(af file.AudioFile) string { return string(af.AudioStreamHash) }) {
+		report(KindAudio, group)
+	}
+	for _, group := range groupBy(files, tagsKey) {
+		for _, cluster := range durationClusters(group, cfg.DurationTolerance) {
+			report(KindTags, cluster)
+		}
+	}
+
+	return result, nil
+}
+
+// Groups files by the key, skipping the ones with an empty key. Groups are in order of their first file
+func groupBy(files []file.AudioFile, key func(af file.AudioFile) string) [][]file.AudioFile {
+	indices := map[string]int{}
+	result := [][]file.AudioFile{}
+	for _, af := range files {
+		k := key(af)
+		if k == "" {
+			continue
+		}
+		i, ok := indices[k]
+		if !ok {
+			i = len(result)
+			indices[k] = i
+			result = append(result, nil)
+		}
+		result[i] = append(result[i], af)
+	}
+	return result
+}
+
+// Artist and title, compared case insensitively and regardless of spacing. Empty if either is missing
+func tagsKey(af file.AudioFile) string {
+	normalized := func(key string) string {
+		value, _ := af.TagValue(key)
+		return strings.Join(strings.Fields(strings.ToLower(value)), " ")
+	}
+
+	artist, title := normalized(file.TagArtist), normalized(file.TagTitle)
+	if artist == "" || title == "" {
+		return ""
+	}
+	return artist + "\x00" + title
+}
+
+// Splits files into runs where each one is within the tolerance of the previous one
+func durationClusters(files []file.AudioFile, tolerance time.Duration) [][]file.AudioFile {
+	sorted := slices.Clone(files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Properties.DurationMs() < sorted[j].Properties.DurationMs()
+	})
+
+	result := [][]file.AudioFile{}
+	for i, af := range sorted {
+		if i == 0 || time.Duration(af.Properties.DurationMs()-sorted[i-1].Properties.DurationMs())*time.Millisecond > tolerance {
+			result = append(result, nil)
+		}
+		result[len(result)-1] = append(result[len(result)-1], af)
+	}
+	return result
+}
+
+// Checks if all of the files are already in one of the groups
+func reported(groups []Group, files []file.AudioFile) bool {
+	for _, g := range groups {
+		all := true
+		for _, af := range files {
+			if !slices.Contains(g.Files, af.FsFile.Name) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+func groupMake(kind Kind, files []file.AudioFile, rules []Rule) Group {
+	sorted := slices.Clone(files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		for _, rule := range rules {
+			if c := rule(sorted[i], sorted[j]); c != 0 {
+				return c > 0
+			}
+		}
+		return sorted[i].FsFile.Name < sorted[j].FsFile.Name
+	})
+
+	result := Group{Kind: kind, Keeper: sorted[0].FsFile.Name, Files: []string{}}
+	for _, af := range sorted {
+		result.Files = append(result.Files, af.FsFile.Name)
+	}
+	return result
+}
+
+func tagsComplete(af file.AudioFile) int {
+	result := 0
+	for _, key := range completeTags {
+		if value, ok := af.TagValue(key); ok && strings.TrimSpace(value) != "" {
+			result += 1
+		}
+	}
+	return result
+}
+
+func boolCompare(a bool, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,53 @@
+package dupes
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/repo"
+)
+
+func testTrack(name string, hash string, codec string, durationSec uint64, tags ...file.Tag) file.AudioFile {
+	return file.AudioFile{
+		FsFile: file.FsFile{Name: name, Hash: []byte(hash)},
+		Tags: append([]file.Tag{
+			{Key: file.TagArtist, Value: "Artist"},
+			{Key: file.TagTitle, Value: "Title"},
+		}, tags...),
+		Properties: file.AudioProperties{Codec: codec, SampleRate: 1000, SamplesTotal: durationSec * 1000},
+	}
+}
+
+func TestFind(t *testing.T) {
+	store := repo.NewMemory()
+
+	assert.Nil(t, store.Insert(file.FsFile{Name: "/music/a/cover.jpg", Hash: []byte("cover")}))
+	assert.Nil(t, store.Insert(file.FsFile{Name: "/music/b/cover.jpg", Hash: []byte("cover")}))
+
+	retagged := testTrack("/music/b/song.flac", "retagged", "flac", 180, file.Tag{Key: file.TagAlbum, Value: "Album"})
+	retagged.AudioStreamHash = []byte("audio")
+	original := testTrack("/music/a/song.flac", "original", "flac", 180)
+	original.AudioStreamHash = []byte("audio")
+	assert.Nil(t, store.UpsertAudioFile(original))
+	assert.Nil(t, store.UpsertAudioFile(retagged))
+
+	assert.Nil(t, store.UpsertAudioFile(testTrack("/music/c/song.mp3", "lossy", "mp3", 181)))
+	assert.Nil(t, store.UpsertAudioFile(testTrack("/music/c/live.mp3", "live", "mp3", 300)))
+
+	groups, err := Find(store, DefaultCfg())
+	assert.Nil(t, err)
+	assert.Equal(t, []Group{
+		{Kind: KindContent, Keeper: "/music/a/cover.jpg", Files: []string{"/music/a/cover.jpg", "/music/b/cover.jpg"}},
+		{Kind: KindAudio, Keeper: "/music/b/song.flac", Files: []string{"/music/b/song.flac", "/music/a/song.flac"}},
+		{
+			Kind:   KindTags,
+			Keeper: "/music/b/song.flac",
+			Files:  []string{"/music/b/song.flac", "/music/a/song.flac", "/music/c/song.mp3"},
+		},
+	}, groups)
+
+	groups, err = Find(store, Cfg{DurationTolerance: 0, Rules: []Rule{PreferBitrate}})
+	assert.Nil(t, err)
+	assert.Len(t, groups, 2)
+}
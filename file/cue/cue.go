@@ -0,0 +1,231 @@
+package cue
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CD frames (sectors) per second, used by cue sheet MSF timestamps
+const FramesPerSecond = 75
+
+type Sheet struct {
+	Catalog   string
+	Title     string
+	Performer string
+	Files     []File
+}
+
+type File struct {
+	Name   string
+	Format string
+	Tracks []Track
+}
+
+type Track struct {
+	Num       uint8
+	DataType  string
+	Title     string
+	Performer string
+	Isrc      string
+	Indices   []Index
+}
+
+type Index struct {
+	Num uint8
+	// Offset from the beginning of the file, in CD frames
+	Frames uint64
+}
+
+// Whether this track contains audio, as opposed to data (CD-ROM modes)
+func (track Track) IsAudio() bool {
+	return track.DataType == "AUDIO"
+}
+
+// Offset of the given index point from the beginning of the file, in CD frames
+func (track Track) IndexFrames(num uint8) (uint64, bool) {
+	for _, index := range track.Indices {
+		if index.Num == num {
+			return index.Frames, true
+		}
+	}
+	return 0, false
+}
+
+type SyntaxErr struct {
+	Line int
+	Msg  string
+}
+
+func (err SyntaxErr) Error() string {
+	return fmt.Sprintf("cue sheet syntax error at line %d: %s", err.Line, err.Msg)
+}
+
+// Parses a textual cue sheet. Unknown commands, like REM, are skipped
+func Parse(r io.Reader) (Sheet, error) {
+	var result Sheet
+	var currentFile *File
+	var currentTrack *Track
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum += 1
+		line := scanner.Text()
+		if lineNum == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		fields := splitFields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "CATALOG":
+			if len(fields) < 2 {
+				return result, SyntaxErr{Line: lineNum, Msg: "CATALOG requires a value"}
+			}
+			result.Catalog = fields[1]
+
+		case "TITLE":
+			if len(fields) < 2 {
+				return result, SyntaxErr{Line: lineNum, Msg: "TITLE requires a value"}
+			}
+			if currentTrack != nil {
+				currentTrack.Title = fields[1]
+			} else {
+				result.Title = fields[1]
+			}
+
+		case "PERFORMER":
+			if len(fields) < 2 {
+				return result, SyntaxErr{Line: lineNum, Msg: "PERFORMER requires a value"}
+			}
+			if currentTrack != nil {
+				currentTrack.Performer = fields[1]
+			} else {
+				result.Performer = fields[1]
+			}
+
+		case "FILE":
+			if len(fields) < 2 {
+				return result, SyntaxErr{Line: lineNum, Msg: "FILE requires a name"}
+			}
+			f := File{Name: fields[1]}
+			if len(fields) > 2 {
+				f.Format = strings.ToUpper(fields[2])
+			}
+			result.Files = append(result.Files, f)
+			currentFile = &result.Files[len(result.Files)-1]
+			currentTrack = nil
+
+		case "TRACK":
+			if currentFile == nil {
+				return result, SyntaxErr{Line: lineNum, Msg: "TRACK before any FILE"}
+			}
+			if len(fields) < 3 {
+				return result, SyntaxErr{Line: lineNum, Msg: "TRACK requires a number and a data type"}
+			}
+			num, err := strconv.ParseUint(fields[1], 10, 8)
+			if err != nil {
+				return result, SyntaxErr{Line: lineNum, Msg: fmt.Sprintf("invalid track number %q", fields[1])}
+			}
+			currentFile.Tracks = append(currentFile.Tracks, Track{
+				Num:      uint8(num),
+				DataType: strings.ToUpper(fields[2]),
+			})
+			currentTrack = &currentFile.Tracks[len(currentFile.Tracks)-1]
+
+		case "ISRC":
+			if currentTrack == nil {
+				return result, SyntaxErr{Line: lineNum, Msg: "ISRC outside of TRACK"}
+			}
+			if len(fields) < 2 {
+				return result, SyntaxErr{Line: lineNum, Msg: "ISRC requires a value"}
+			}
+			currentTrack.Isrc = fields[1]
+
+		case "INDEX":
+			if currentTrack == nil {
+				return result, SyntaxErr{Line: lineNum, Msg: "INDEX outside of TRACK"}
+			}
+			if len(fields) < 3 {
+				return result, SyntaxErr{Line: lineNum, Msg: "INDEX requires a number and a timestamp"}
+			}
+			num, err := strconv.ParseUint(fields[1], 10, 8)
+			if err != nil {
+				return result, SyntaxErr{Line: lineNum, Msg: fmt.Sprintf("invalid index number %q", fields[1])}
+			}
+			frames, err := ParseMsf(fields[2])
+			if err != nil {
+				return result, SyntaxErr{Line: lineNum, Msg: err.Error()}
+			}
+			currentTrack.Indices = append(currentTrack.Indices, Index{
+				Num:    uint8(num),
+				Frames: frames,
+			})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Parses mm:ss:ff timestamp into the amount of CD frames
+func ParseMsf(s string) (uint64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid timestamp %q, expected mm:ss:ff", s)
+	}
+
+	var values [3]uint64
+	for i, part := range parts {
+		v, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q, expected mm:ss:ff", s)
+		}
+		values[i] = v
+	}
+
+	if values[1] >= 60 || values[2] >= FramesPerSecond {
+		return 0, fmt.Errorf("timestamp %q is out of range", s)
+	}
+
+	return (values[0]*60+values[1])*FramesPerSecond + values[2], nil
+}
+
+// Splits the line into whitespace-separated fields, treating double-quoted strings as one field
+func splitFields(line string) []string {
+	result := []string{}
+
+	var field strings.Builder
+	inQuotes := false
+	hasField := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasField = true
+		case !inQuotes && (r == ' ' || r == '\t' || r == '\r'):
+			if hasField {
+				result = append(result, field.String())
+				field.Reset()
+				hasField = false
+			}
+		default:
+			field.WriteRune(r)
+			hasField = true
+		}
+	}
+	if hasField {
+		result = append(result, field.String())
+	}
+
+	return result
+}
@@ -0,0 +1,125 @@
+package cue
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file/flac"
+)
+
+const testSheet = `REM GENRE Rock
+PERFORMER "Some Band"
+TITLE "Some Album"
+FILE "Some Band - Some Album.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "First"
+    ISRC USXXX0000001
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Second"
+    PERFORMER "Guest"
+    INDEX 00 03:59:70
+    INDEX 01 04:00:00
+`
+
+func TestParse(t *testing.T) {
+	sheet, err := Parse(strings.NewReader(testSheet))
+	assert.Nil(t, err)
+
+	assert.Equal(t, "Some Band", sheet.Performer)
+	assert.Equal(t, "Some Album", sheet.Title)
+	assert.Len(t, sheet.Files, 1)
+	assert.Equal(t, "Some Band - Some Album.flac", sheet.Files[0].Name)
+	assert.Equal(t, "WAVE", sheet.Files[0].Format)
+
+	tracks := sheet.Files[0].Tracks
+	assert.Len(t, tracks, 2)
+	assert.Equal(t, "First", tracks[0].Title)
+	assert.Equal(t, "USXXX0000001", tracks[0].Isrc)
+	assert.Equal(t, "Guest", tracks[1].Performer)
+
+	frames, ok := tracks[1].IndexFrames(1)
+	assert.True(t, ok)
+	assert.EqualValues(t, 4*60*FramesPerSecond, frames)
+}
+
+func TestParseErr(t *testing.T) {
+	_, err := Parse(strings.NewReader("TRACK 01 AUDIO\n"))
+	assert.Equal(t, SyntaxErr{Line: 1, Msg: "TRACK before any FILE"}, err)
+
+	_, err = Parse(strings.NewReader("FILE \"a.flac\" WAVE\nTRACK 01 AUDIO\nINDEX 01 00:60:00\n"))
+	assert.IsType(t, SyntaxErr{}, err)
+}
+
+func TestFromSheet(t *testing.T) {
+	sheet, err := Parse(strings.NewReader(testSheet))
+	assert.Nil(t, err)
+
+	streamInfo := flac.StreamInfo{SampleRate: 44100, SamplesTotal: 44100 * 600}
+	tracks, err := FromSheet(sheet, "/music/renamed.flac", streamInfo)
+	assert.Nil(t, err)
+	assert.Len(t, tracks, 2)
+
+	assert.EqualValues(t, 0, tracks[0].StartSample)
+	assert.EqualValues(t, 44100*240, tracks[0].EndSample)
+	assert.Equal(t, "Some Band", tracks[0].Performer)
+
+	assert.EqualValues(t, 44100*240, tracks[1].StartSample)
+	assert.EqualValues(t, 44100*600, tracks[1].EndSample)
+	assert.Equal(t, "Guest", tracks[1].Performer)
+	assert.Equal(t, "/music/renamed.flac", tracks[1].Source)
+}
+
+func TestFromFlac(t *testing.T) {
+	streamInfo := flac.StreamInfo{SampleRate: 44100, SamplesTotal: 44100 * 600}
+	audio := func(num uint8, offset uint64) flac.CuesheetTrack {
+		return flac.CuesheetTrack{
+			Offset:   offset,
+			TrackNum: num,
+			IsAudio:  true,
+			Indices:  []flac.CuesheetTrackIndex{{IndexPointNum: 1}},
+		}
+	}
+
+	cases := []struct {
+		name     string
+		cuesheet flac.Cuesheet
+		nums     []uint8
+		ends     []uint64
+	}{
+		{
+			name: "cd",
+			cuesheet: flac.Cuesheet{IsCompactDisc: true, CuesheetTracks: []flac.CuesheetTrack{
+				audio(1, 0), audio(2, 44100*240), {TrackNum: flac.CuesheetLeadOutCd, Offset: 44100 * 500},
+			}},
+			nums: []uint8{1, 2},
+			ends: []uint64{44100 * 240, 44100 * 500},
+		},
+		{
+			// Track 170 is an ordinary one there, the lead-out is 255
+			name: "not cd",
+			cuesheet: flac.Cuesheet{IsCompactDisc: false, CuesheetTracks: []flac.CuesheetTrack{
+				audio(1, 0), audio(170, 44100*240), {TrackNum: flac.CuesheetLeadOut, Offset: 44100 * 500},
+			}},
+			nums: []uint8{1, 170},
+			ends: []uint64{44100 * 240, 44100 * 500},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stream := flac.Stream{Metadata: []any{streamInfo, c.cuesheet}}
+			tracks, found, err := FromFlac(stream, "/music/album.flac")
+			assert.Nil(t, err)
+			assert.True(t, found)
+			nums := []uint8{}
+			ends := []uint64{}
+			for _, track := range tracks {
+				nums = append(nums, track.TrackNum)
+				ends = append(ends, track.EndSample)
+			}
+			assert.Equal(t, c.nums, nums)
+			assert.Equal(t, c.ends, ends)
+		})
+	}
+}
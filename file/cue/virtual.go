@@ -0,0 +1,187 @@
+package cue
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/file/flac"
+)
+
+// Vorbis comment some rippers use to embed the whole textual cue sheet into the file
+const VorbisCommentCuesheet = "CUESHEET"
+
+var NoStreamInfoErr = fmt.Errorf("no stream info found, can't calculate sample offsets")
+
+// Builds virtual tracks for the given FLAC stream out of its own metadata.
+// Offsets come from the CUESHEET block, titles and performers from the embedded
+// textual cue sheet, if any. Returns false if the stream has no cue sheet at all
+func FromFlac(stream flac.Stream, source string) ([]file.VirtualTrack, bool, error) {
+	var streamInfo *flac.StreamInfo
+	var cuesheet *flac.Cuesheet
+	var sheet *Sheet
+
+	for _, block := range stream.Metadata {
+		switch v := block.(type) {
+		case flac.StreamInfo:
+			streamInfo = &v
+		case flac.Cuesheet:
+			cuesheet = &v
+		case flac.VorbisComment:
+			for _, comment := range v.Data {
+				if !strings.EqualFold(comment.Name, VorbisCommentCuesheet) {
+					continue
+				}
+				parsed, err := Parse(strings.NewReader(comment.Value))
+				if err != nil {
+					return nil, false, err
+				}
+				sheet = &parsed
+			}
+		}
+	}
+
+	if cuesheet == nil && sheet == nil {
+		return nil, false, nil
+	}
+	if streamInfo == nil {
+		return nil, true, NoStreamInfoErr
+	}
+
+	if cuesheet == nil {
+		result, err := FromSheet(*sheet, source, *streamInfo)
+		return result, true, err
+	}
+
+	result := []file.VirtualTrack{}
+	var last *file.VirtualTrack
+	for _, track := range cuesheet.CuesheetTracks {
+		start, ok := track.IndexOffset(1)
+		if !ok {
+			start = track.Offset
+		}
+
+		if last != nil {
+			last.EndSample = start
+		}
+		if track.IsLeadOut(cuesheet.IsCompactDisc) {
+			last = nil
+			break
+		}
+		if !track.IsAudio {
+			last = nil
+			continue
+		}
+
+		result = append(result, file.VirtualTrack{
+			Source:      source,
+			TrackNum:    track.TrackNum,
+			Isrc:        track.Isrc,
+			StartSample: start,
+			EndSample:   streamInfo.SamplesTotal,
+			SampleRate:  streamInfo.SampleRate,
+		})
+		last = &result[len(result)-1]
+	}
+
+	if sheet != nil {
+		overlaySheet(result, *sheet, source)
+	}
+
+	return result, true, nil
+}
+
+// Builds virtual tracks for the given source file out of a standalone cue sheet,
+// usually a sidecar .cue file next to it
+func FromSheet(sheet Sheet, source string, streamInfo flac.StreamInfo) ([]file.VirtualTrack, error) {
+	if streamInfo.SampleRate == 0 {
+		return nil, NoStreamInfoErr
+	}
+
+	f, ok := sheet.fileFor(source)
+	if !ok {
+		return nil, fmt.Errorf("cue sheet doesn't reference %s", filepath.Base(source))
+	}
+
+	result := []file.VirtualTrack{}
+	var last *file.VirtualTrack
+	for _, track := range f.Tracks {
+		frames, ok := track.IndexFrames(1)
+		if !ok {
+			return nil, fmt.Errorf("track %d has no INDEX 01", track.Num)
+		}
+		start := frames * uint64(streamInfo.SampleRate) / FramesPerSecond
+
+		if last != nil {
+			last.EndSample = start
+		}
+		if !track.IsAudio() {
+			last = nil
+			continue
+		}
+
+		performer := track.Performer
+		if performer == "" {
+			performer = sheet.Performer
+		}
+
+		result = append(result, file.VirtualTrack{
+			Source:      source,
+			TrackNum:    track.Num,
+			Title:       track.Title,
+			Performer:   performer,
+			Isrc:        track.Isrc,
+			StartSample: start,
+			EndSample:   streamInfo.SamplesTotal,
+			SampleRate:  streamInfo.SampleRate,
+		})
+		last = &result[len(result)-1]
+	}
+
+	return result, nil
+}
+
+// Fills in the textual fields of the virtual tracks from a cue sheet, matching them by track number
+func overlaySheet(tracks []file.VirtualTrack, sheet Sheet, source string) {
+	f, ok := sheet.fileFor(source)
+	if !ok {
+		return
+	}
+
+	for i := range tracks {
+		for _, track := range f.Tracks {
+			if track.Num != tracks[i].TrackNum {
+				continue
+			}
+			tracks[i].Title = track.Title
+			tracks[i].Performer = track.Performer
+			if tracks[i].Performer == "" {
+				tracks[i].Performer = sheet.Performer
+			}
+			if tracks[i].Isrc == "" {
+				tracks[i].Isrc = track.Isrc
+			}
+		}
+	}
+}
+
+// Finds the FILE entry describing the given source. Sheets with a single FILE entry
+// always match, since rippers often leave a stale name in there after renaming the file
+func (sheet Sheet) fileFor(source string) (File, bool) {
+	if len(sheet.Files) == 1 {
+		return sheet.Files[0], true
+	}
+
+	base := filepath.Base(source)
+	for _, f := range sheet.Files {
+		// Cue sheets made on Windows use backslashes regardless of the platform we run on
+		name := path.Base(strings.ReplaceAll(f.Name, "\\", "/"))
+		if strings.EqualFold(name, base) {
+			return f, true
+		}
+	}
+
+	return File{}, false
+}
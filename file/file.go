@@ -1,11 +1,103 @@
 package file
 
+import "time"
+
 type FsFile struct {
 	Hash []byte
 	Name string
+	// Size in bytes, as of the moment the file was hashed
+	Size    int64
+	ModTime time.Time
 }
 
 type AudioFile struct {
-	fsFile          FsFile
-	audioStreamHash []byte
+	FsFile FsFile
+	// Hash of the audio alone, not affected by tags. Empty if it's unknown
+	AudioStreamHash []byte
+	Tags            []Tag
+	Properties      AudioProperties
+}
+
+// Tag, with its key normalized to the Vorbis comment naming, like ARTIST or TRACKNUMBER.
+// Keys can repeat, e.g. for multiple artists
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Technical properties of the audio stream. Zero values are for properties that are unknown
+type AudioProperties struct {
+	Codec         string
+	SampleRate    uint32
+	Channels      uint8
+	BitsPerSample uint8
+	SamplesTotal  uint64
+	// Average bitrate, in bits per second
+	Bitrate uint32
+}
+
+// Values of all tags with the given key, in order
+func (af AudioFile) TagValues(key string) []string {
+	result := []string{}
+	for _, tag := range af.Tags {
+		if tag.Key == key {
+			result = append(result, tag.Value)
+		}
+	}
+	return result
+}
+
+// The first value of the tag with the given key
+func (af AudioFile) TagValue(key string) (string, bool) {
+	for _, tag := range af.Tags {
+		if tag.Key == key {
+			return tag.Value, true
+		}
+	}
+	return "", false
+}
+
+// Duration in milliseconds, if it can be calculated
+func (props AudioProperties) DurationMs() uint64 {
+	if props.SampleRate == 0 {
+		return 0
+	}
+	return props.SamplesTotal * 1000 / uint64(props.SampleRate)
+}
+
+// A track that is not backed by its own file, but by a sample range
+// of a bigger one, e.g. a single-file album rip with a cue sheet
+type VirtualTrack struct {
+	// Path of the physical file containing the track
+	Source    string
+	TrackNum  uint8
+	Title     string
+	Performer string
+	Isrc      string
+	// First sample of the track, inclusive
+	StartSample uint64
+	// Last sample of the track, exclusive
+	EndSample  uint64
+	SampleRate uint32
+}
+
+func (track VirtualTrack) SamplesCount() uint64 {
+	return track.EndSample - track.StartSample
+}
+
+// Picture stored in the artwork cache, identified by the hash of its contents
+type Picture struct {
+	Hash     []byte
+	MimeType string
+	Width    uint32
+	Height   uint32
+	Size     uint64
+}
+
+// Picture as attached to a track or an album
+type PictureLink struct {
+	Picture
+	// Same numbering as in ID3v2 APIC frames and FLAC PICTURE blocks
+	PicType uint32
+	Desc    string
 }
@@ -0,0 +1,59 @@
+package flac
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Decodes APPLICATION block data of a specific application
+type AppDecoder func(data []byte) (any, error)
+
+var appDecoders = map[uint32]AppDecoder{
+	AppIdRiff: decodeForeignRiff,
+	AppIdAiff: decodeForeignAiff,
+}
+var appDecodersLock sync.RWMutex
+
+// Makes an application id out of its 4 character representation, like "riff"
+func AppIdFromString(s string) (uint32, error) {
+	if len(s) != 4 {
+		return 0, fmt.Errorf("application id must be exactly 4 bytes long, got %q", s)
+	}
+	return binary.BigEndian.Uint32([]byte(s)), nil
+}
+
+func appIdMust(s string) uint32 {
+	result, err := AppIdFromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// Registers a decoder for APPLICATION blocks with the given id, replacing the existing one, if any
+func RegisterAppDecoder(appId uint32, decoder AppDecoder) {
+	appDecodersLock.Lock()
+	defer appDecodersLock.Unlock()
+	appDecoders[appId] = decoder
+}
+
+// 4 character representation of the application id, like "riff"
+func (app Application) AppIdString() string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], app.AppId)
+	return string(b[:])
+}
+
+// Interprets the application data with a registered decoder. Returns false if there's none for this id
+func (app Application) Decode() (any, bool, error) {
+	appDecodersLock.RLock()
+	decoder, ok := appDecoders[app.AppId]
+	appDecodersLock.RUnlock()
+
+	if !ok {
+		return nil, false, nil
+	}
+	result, err := decoder(app.AppData)
+	return result, true, err
+}
@@ -0,0 +1,76 @@
+package flac_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file/flac"
+)
+
+func riffChunk(id string, size uint32, data []byte) []byte {
+	result := []byte(id)
+	result = binary.LittleEndian.AppendUint32(result, size)
+	return append(result, data...)
+}
+
+func TestAppIdString(t *testing.T) {
+	app := flac.Application{AppId: flac.AppIdRiff}
+	assert.Equal(t, "riff", app.AppIdString())
+
+	_, err := flac.AppIdFromString("toolong")
+	assert.NotNil(t, err)
+}
+
+func TestRegisterAppDecoder(t *testing.T) {
+	appId, err := flac.AppIdFromString("test")
+	assert.Nil(t, err)
+
+	app := flac.Application{AppId: appId, AppData: []byte{1, 2, 3}}
+	_, found, _ := app.Decode()
+	assert.False(t, found)
+
+	flac.RegisterAppDecoder(appId, func(data []byte) (any, error) {
+		return len(data), nil
+	})
+	decoded, found, err := app.Decode()
+	assert.True(t, found)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, decoded)
+}
+
+func TestReconstructForeignWav(t *testing.T) {
+	audio := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	fmtData := make([]byte, 16)
+	stream := flac.Stream{Metadata: []any{
+		flac.StreamInfo{},
+		flac.Application{AppId: flac.AppIdRiff, AppData: riffChunk("RIFF", 44-8, []byte("WAVE"))},
+		flac.Application{AppId: flac.AppIdRiff, AppData: riffChunk("fmt ", 16, fmtData)},
+		flac.Application{AppId: flac.AppIdRiff, AppData: riffChunk("data", uint32(len(audio)), nil)},
+	}}
+
+	chunks, err := flac.ForeignChunks(stream)
+	assert.Nil(t, err)
+	assert.Len(t, chunks, 3)
+
+	var output bytes.Buffer
+	err = flac.ReconstructForeign(chunks, bytes.NewReader(audio), &output)
+	assert.Nil(t, err)
+
+	expected := riffChunk("RIFF", 44-8, []byte("WAVE"))
+	expected = append(expected, riffChunk("fmt ", 16, fmtData)...)
+	expected = append(expected, riffChunk("data", uint32(len(audio)), audio)...)
+	assert.Equal(t, expected, output.Bytes())
+}
+
+func TestReconstructForeignShortAudio(t *testing.T) {
+	chunks := []flac.ForeignChunk{
+		{AppId: flac.AppIdRiff, Id: "RIFF", Raw: riffChunk("RIFF", 0, []byte("WAVE"))},
+		{AppId: flac.AppIdRiff, Id: "data", Size: 4, Raw: riffChunk("data", 4, nil)},
+	}
+
+	var output bytes.Buffer
+	err := flac.ReconstructForeign(chunks, bytes.NewReader([]byte{1}), &output)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,48 @@
+package flac_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file/flac"
+)
+
+func TestRawMetadataRoundTrip(t *testing.T) {
+	streamInfo := flac.RawBlock{
+		Type: flac.MetadataBlockTypeStreamInfo,
+		Data: make([]byte, 34),
+	}
+	pic := flac.Picture{
+		PicType:  flac.PicTypeCoverFront,
+		MimeType: "image/png",
+		Desc:     "front",
+		Width:    1,
+		Height:   2,
+		Data:     []byte{0xDE, 0xAD},
+	}
+
+	var buf bytes.Buffer
+	err := flac.WriteRawMetadata(&buf, []flac.RawBlock{streamInfo, pic.Marshal()})
+	assert.Nil(t, err)
+
+	blocks, err := flac.ReadRawMetadata(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	assert.Len(t, blocks, 2)
+
+	stream, err := flac.ReadStream(bytes.NewReader(buf.Bytes()), flac.ReadCfg{
+		ReadMetadata: true,
+		ReadFrames:   false,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, stream.Metadata, 2)
+	if len(stream.Metadata) == 2 {
+		assert.Equal(t, pic, stream.Metadata[1])
+	}
+}
+
+func TestWriteRawMetadataStreamInfoFirst(t *testing.T) {
+	var buf bytes.Buffer
+	err := flac.WriteRawMetadata(&buf, []flac.RawBlock{{Type: flac.MetadataBlockTypePadding}})
+	assert.NotNil(t, err)
+}
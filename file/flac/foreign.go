@@ -0,0 +1,144 @@
+package flac
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Application ids, used by `flac --keep-foreign-metadata` to store chunks of the original file
+var (
+	AppIdRiff = appIdMust("riff")
+	AppIdAiff = appIdMust("aiff")
+)
+
+// Chunk of the original WAV or AIFF file, stored as is in an APPLICATION block.
+// Audio data chunk is stored with its header only, since the audio is in the FLAC frames
+type ForeignChunk struct {
+	AppId uint32
+	Id    string
+	// Size as declared in the chunk header, not including the header itself
+	Size uint32
+	// Chunk as stored, including its header
+	Raw []byte
+}
+
+// Whether this is the chunk containing the audio itself, 'data' for WAV and 'SSND' for AIFF
+func (chunk ForeignChunk) IsAudioData() bool {
+	return chunk.Id == "data" || chunk.Id == "SSND"
+}
+
+// Whether this is the outermost chunk, wrapping all the others
+func (chunk ForeignChunk) IsContainer() bool {
+	return chunk.Id == "RIFF" || chunk.Id == "RF64" || chunk.Id == "FORM"
+}
+
+// Amount of audio bytes following the header of the audio data chunk
+func (chunk ForeignChunk) AudioLen() (uint64, error) {
+	switch chunk.Id {
+	case "data":
+		return uint64(chunk.Size), nil
+	case "SSND":
+		// Offset and block size fields come before the samples
+		if len(chunk.Raw) < 16 || chunk.Size < 8 {
+			return 0, fmt.Errorf("SSND chunk is too short")
+		}
+		offset := binary.BigEndian.Uint32(chunk.Raw[8:12])
+		if uint64(chunk.Size) < 8+uint64(offset) {
+			return 0, fmt.Errorf("SSND offset %d doesn't fit into the chunk", offset)
+		}
+		// Samples start after the offset, which is already stored as a part of the chunk
+		return uint64(chunk.Size) - 8 - uint64(offset), nil
+	}
+	return 0, fmt.Errorf("%s is not an audio data chunk", chunk.Id)
+}
+
+func decodeForeignRiff(data []byte) (any, error) {
+	return decodeForeignChunk(AppIdRiff, data, binary.LittleEndian)
+}
+
+func decodeForeignAiff(data []byte) (any, error) {
+	return decodeForeignChunk(AppIdAiff, data, binary.BigEndian)
+}
+
+func decodeForeignChunk(appId uint32, data []byte, order binary.ByteOrder) (any, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("foreign chunk is too short: %d bytes", len(data))
+	}
+
+	chunk := ForeignChunk{
+		AppId: appId,
+		Id:    string(data[:4]),
+		Size:  order.Uint32(data[4:8]),
+		Raw:   data,
+	}
+	if chunk.IsContainer() && len(data) < 12 {
+		return nil, fmt.Errorf("foreign %s header is too short: %d bytes", chunk.Id, len(data))
+	}
+
+	return chunk, nil
+}
+
+// Collects all foreign chunks stored in the stream metadata, in order
+func ForeignChunks(stream Stream) ([]ForeignChunk, error) {
+	result := []ForeignChunk{}
+
+	for _, block := range stream.Metadata {
+		app, ok := block.(Application)
+		if !ok || (app.AppId != AppIdRiff && app.AppId != AppIdAiff) {
+			continue
+		}
+		decoded, found, err := app.Decode()
+		if err != nil {
+			return result, err
+		}
+		if chunk, ok := decoded.(ForeignChunk); found && ok {
+			result = append(result, chunk)
+		}
+	}
+
+	return result, nil
+}
+
+// Writes the original file back out of its foreign chunks, inserting decoded audio
+// after the audio data chunk header. Audio must be in the original sample format,
+// little endian for WAV and big endian for AIFF, exactly as it was stored in the original file
+func ReconstructForeign(chunks []ForeignChunk, audio io.Reader, output io.Writer) error {
+	if len(chunks) == 0 || !chunks[0].IsContainer() {
+		return fmt.Errorf("foreign chunks must start with the container header")
+	}
+
+	audioWritten := false
+	for _, chunk := range chunks {
+		if _, err := output.Write(chunk.Raw); err != nil {
+			return err
+		}
+		if !chunk.IsAudioData() {
+			continue
+		}
+
+		if audioWritten {
+			return fmt.Errorf("more than one audio data chunk")
+		}
+		audioWritten = true
+
+		audioLen, err := chunk.AudioLen()
+		if err != nil {
+			return err
+		}
+		if _, err := io.CopyN(output, audio, int64(audioLen)); err != nil {
+			return fmt.Errorf("can't copy %d bytes of audio: %w", audioLen, err)
+		}
+		// Chunks are aligned to 2 bytes, pad byte is not a part of the stored chunk
+		if chunk.AppId == AppIdRiff && chunk.Size%2 != 0 {
+			if _, err := output.Write([]byte{0}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !audioWritten {
+		return fmt.Errorf("no audio data chunk among foreign chunks")
+	}
+	return nil
+}
@@ -76,8 +76,9 @@ type StreamInfo struct {
 }
 
 type Application struct {
-	AppId   uint32
-	AppData []byte // TODO
+	AppId uint32
+	// Raw data, interpret it with [Application.Decode]
+	AppData []byte
 }
 
 // The number of seek points is implied by the metadata header 'length' field, i.e. equal to length / 18.
@@ -107,18 +108,43 @@ type Cuesheet struct {
 	CuesheetTracks  []CuesheetTrack
 }
 
+// Lead-out track number for CD-DA cuesheets. Non-CD cuesheets use 255 instead
+const CuesheetLeadOutCd = 170
+const CuesheetLeadOut = 255
+
 type CuesheetTrack struct {
-	offset      uint64
-	trackNum    uint8
-	isrc        [12]byte
-	isAudio     bool
-	preEmphasis bool
-	indicies    []cuesheetTrackIndex
+	// Offset in samples, relative to the beginning of the FLAC audio stream
+	Offset      uint64
+	TrackNum    uint8
+	Isrc        string
+	IsAudio     bool
+	PreEmphasis bool
+	Indices     []CuesheetTrackIndex
+}
+
+type CuesheetTrackIndex struct {
+	// Offset in samples, relative to the track offset
+	Offset        uint64
+	IndexPointNum uint8
+}
+
+// Whether this track is the lead-out one of a cuesheet, CD-DA or not, which only marks the end
+// of the last track. Track 170 is an ordinary one on cuesheets that aren't CD-DA
+func (track CuesheetTrack) IsLeadOut(isCompactDisc bool) bool {
+	if isCompactDisc {
+		return track.TrackNum == CuesheetLeadOutCd
+	}
+	return track.TrackNum == CuesheetLeadOut
 }
 
-type cuesheetTrackIndex struct {
-	offset        uint64
-	indexPointNum uint8
+// Offset of the given index point in samples, relative to the beginning of the FLAC audio stream
+func (track CuesheetTrack) IndexOffset(indexPointNum uint8) (uint64, bool) {
+	for _, index := range track.Indices {
+		if index.IndexPointNum == indexPointNum {
+			return track.Offset + index.Offset, true
+		}
+	}
+	return 0, false
 }
 
 type Picture struct {
@@ -406,7 +432,8 @@ func readCuesheet(input *bufio.Reader) (util.ReadResult[Cuesheet], error) {
 		result.AddReadBytes(1)
 		mediaCatalogNum.WriteByte(b)
 	}
-	result.Value.MediaCatalogNum = mediaCatalogNum.String()
+	// Catalog number is NUL-padded to the full length
+	result.Value.MediaCatalogNum = strings.TrimRight(mediaCatalogNum.String(), "\x00")
 
 	leadInSamples, err := util.ReadUint64(input)
 	if err != nil {
@@ -456,7 +483,7 @@ func readCuesheet(input *bufio.Reader) (util.ReadResult[Cuesheet], error) {
 func readCuesheetTrack(input *bufio.Reader) (util.ReadResult[CuesheetTrack], error) {
 	result := util.ReadResult[CuesheetTrack]{
 		Value: CuesheetTrack{
-			indicies: []cuesheetTrackIndex{},
+			Indices: []CuesheetTrackIndex{},
 		},
 	}
 
@@ -465,14 +492,14 @@ func readCuesheetTrack(input *bufio.Reader) (util.ReadResult[CuesheetTrack], err
 		return result, err
 	}
 	result.AddReadBytes(8)
-	result.Value.offset = offset
+	result.Value.Offset = offset
 
 	trackNum, err := util.ReadUint8(input)
 	if err != nil {
 		return result, err
 	}
 	result.AddReadBytes(1)
-	result.Value.trackNum = trackNum
+	result.Value.TrackNum = trackNum
 
 	var isrc [12]byte
 	for i := range isrc {
@@ -483,15 +510,16 @@ func readCuesheetTrack(input *bufio.Reader) (util.ReadResult[CuesheetTrack], err
 		result.AddReadBytes(1)
 		isrc[i] = b
 	}
-	result.Value.isrc = isrc
+	result.Value.Isrc = strings.TrimRight(string(isrc[:]), "\x00")
 
 	b, err := input.ReadByte()
 	if err != nil {
 		return result, err
 	}
 	result.AddReadBytes(1)
-	result.Value.isAudio = util.FindBit(b, 7)
-	result.Value.preEmphasis = util.FindBit(b, 6)
+	// Bit 7 is set for non-audio tracks
+	result.Value.IsAudio = !util.FindBit(b, 7)
+	result.Value.PreEmphasis = util.FindBit(b, 6)
 
 	if _, err := input.Discard(13); err != nil {
 		return result, err
@@ -510,29 +538,29 @@ func readCuesheetTrack(input *bufio.Reader) (util.ReadResult[CuesheetTrack], err
 			return result, err
 		}
 		result.AddReadBytes(index.ReadBytes())
-		result.Value.indicies = append(result.Value.indicies, index.Value)
+		result.Value.Indices = append(result.Value.Indices, index.Value)
 	}
 
 	return result, nil
 }
 
 // Reads total of 12 bytes, if successful
-func readCuesheetTrackIndex(input *bufio.Reader) (util.ReadResult[cuesheetTrackIndex], error) {
-	var result util.ReadResult[cuesheetTrackIndex]
+func readCuesheetTrackIndex(input *bufio.Reader) (util.ReadResult[CuesheetTrackIndex], error) {
+	var result util.ReadResult[CuesheetTrackIndex]
 
 	offset, err := util.ReadUint64(input)
 	if err != nil {
 		return result, err
 	}
 	result.AddReadBytes(8)
-	result.Value.offset = offset
+	result.Value.Offset = offset
 
 	indexPointNum, err := util.ReadUint8(input)
 	if err != nil {
 		return result, err
 	}
 	result.AddReadBytes(1)
-	result.Value.indexPointNum = indexPointNum
+	result.Value.IndexPointNum = indexPointNum
 
 	// TODO: assert all zeroes
 	if _, err := input.Discard(3); err != nil {
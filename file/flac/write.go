@@ -0,0 +1,185 @@
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/util"
+)
+
+// Largest length of a metadata block, which has to fit into 24 bits
+const metadataBlockMaxLen = 1<<24 - 1
+
+// Metadata block, as it's stored in the file, without interpreting its contents
+type RawBlock struct {
+	Type MetadataBlockType
+	Data []byte
+}
+
+// Interprets the raw block. Padding and unknown blocks are returned as nil
+func (block RawBlock) Decode() (any, error) {
+	var header [4]byte
+	header[0] = byte(block.Type) | 0b1000_0000
+	if len(block.Data) > metadataBlockMaxLen {
+		return nil, fmt.Errorf("metadata block is too long: %d bytes", len(block.Data))
+	}
+	header[1] = byte(len(block.Data) >> 16)
+	header[2] = byte(len(block.Data) >> 8)
+	header[3] = byte(len(block.Data))
+
+	input := bufio.NewReader(io.MultiReader(bytes.NewReader(header[:]), bytes.NewReader(block.Data)))
+	result, _, err := readMetadataBlock(input)
+	return result, err
+}
+
+// Reads all metadata blocks, leaving the input positioned right at the first audio frame
+func ReadRawMetadata(input io.Reader) ([]RawBlock, error) {
+	result := []RawBlock{}
+
+	var fileHeader [4]byte
+	if _, err := io.ReadFull(input, fileHeader[:]); err != nil {
+		return result, err
+	}
+	if fileHeader != refFlacHeader {
+		return result, file.InvalidTag{
+			Offset:   0,
+			Expected: refFlacHeader[:],
+			Actual:   fileHeader[:],
+		}
+	}
+
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(input, header[:]); err != nil {
+			return result, err
+		}
+		isLast := util.FindBit(header[0], 7)
+		blockLen := uint32(header[1])<<16 | uint32(header[2])<<8 | uint32(header[3])
+
+		block := RawBlock{
+			Type: MetadataBlockType(header[0] & 0b0111_1111),
+			Data: make([]byte, blockLen),
+		}
+		if _, err := io.ReadFull(input, block.Data); err != nil {
+			return result, err
+		}
+		result = append(result, block)
+
+		if isLast {
+			return result, nil
+		}
+	}
+}
+
+// Writes the FLAC signature followed by all of the metadata blocks, marking the last one as such
+func WriteRawMetadata(output io.Writer, blocks []RawBlock) error {
+	if len(blocks) == 0 || blocks[0].Type != MetadataBlockTypeStreamInfo {
+		return fmt.Errorf("the first metadata block must be stream info")
+	}
+
+	if _, err := output.Write(refFlacHeader[:]); err != nil {
+		return err
+	}
+
+	for i, block := range blocks {
+		if len(block.Data) > metadataBlockMaxLen {
+			return fmt.Errorf("metadata block %d is too long: %d bytes", i, len(block.Data))
+		}
+
+		var header [4]byte
+		header[0] = byte(block.Type) & 0b0111_1111
+		if i == len(blocks)-1 {
+			header[0] |= 0b1000_0000
+		}
+		header[1] = byte(len(block.Data) >> 16)
+		header[2] = byte(len(block.Data) >> 8)
+		header[3] = byte(len(block.Data))
+
+		if _, err := output.Write(header[:]); err != nil {
+			return err
+		}
+		if _, err := output.Write(block.Data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rewrites metadata blocks of the file in place. The whole file is written anew next
+// to the original one and then renamed over it, so the original is never left half-written
+func RewriteMetadata(path string, edit func([]RawBlock) ([]RawBlock, error)) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	input := bufio.NewReader(src)
+	blocks, err := ReadRawMetadata(input)
+	if err != nil {
+		return err
+	}
+	blocks, err = edit(blocks)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*.flac")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	output := bufio.NewWriter(tmp)
+	if err := WriteRawMetadata(output, blocks); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(output, input); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := output.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if info, err := src.Stat(); err == nil {
+		os.Chmod(tmp.Name(), info.Mode())
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Serializes the picture into PICTURE metadata block contents
+func (pic Picture) Marshal() RawBlock {
+	var data bytes.Buffer
+
+	writeUint32 := func(v uint32) {
+		binary.Write(&data, binary.BigEndian, v)
+	}
+
+	writeUint32(uint32(pic.PicType))
+	writeUint32(uint32(len(pic.MimeType)))
+	data.WriteString(pic.MimeType)
+	writeUint32(uint32(len(pic.Desc)))
+	data.WriteString(pic.Desc)
+	writeUint32(pic.Width)
+	writeUint32(pic.Height)
+	writeUint32(pic.ColorDepth)
+	writeUint32(pic.ColorsCount)
+	writeUint32(uint32(len(pic.Data)))
+	data.Write(pic.Data)
+
+	return RawBlock{Type: MetadataTypePicture, Data: data.Bytes()}
+}
@@ -8,7 +8,6 @@ import (
 )
 
 const minorVerUpperBound = 0xFF - 1
-const tagSizeUpperBound = 0x80 - 1
 
 var majorByteSeq = [...]byte{0x49, 0x44, 0x33}
 
@@ -91,62 +90,98 @@ func newHeader(input io.ByteReader) (header, error) {
 	if err != nil {
 		return result, err
 	}
-	result.tagSize = tagSize
-
-	if result.tagSize > tagSizeUpperBound {
-		// TODO: update error type here?
-		return result, fmt.Errorf("invalid tag size, expected max of %x, but got %x", tagSizeUpperBound, result.tagSize)
-	}
-
-	if result.flags.extendedHeaderPresent() {
-		if err := result.attachExtendedHeader(input); err != nil {
-			return result, err
-		}
+	result.tagSize, err = syncsafeDecode(tagSize)
+	if err != nil {
+		return result, err
 	}
 
 	return result, nil
 }
 
+// Decodes a 28-bit integer, stored in 4 bytes with their most significant bits unset
+func syncsafeDecode(v uint32) (uint32, error) {
+	if v&0x80_80_80_80 != 0 {
+		// TODO: update error type here?
+		return 0, fmt.Errorf("invalid syncsafe integer %x, most significant bit is set in one of the bytes", v)
+	}
+	return (v & 0x7F) | (v&0x7F_00)>>1 | (v&0x7F_00_00)>>2 | (v&0x7F_00_00_00)>>3, nil
+}
+
+// Reads the extended header, which directly follows the main one, consuming all of it
 func (header *header) attachExtendedHeader(input io.ByteReader) error {
 	var result extendedHeader
 
-	selfSize, err := util.ReadUint32(input)
+	rawSize, err := util.ReadUint32(input)
 	if err != nil {
 		return err
 	}
-	if selfSize < 6 {
-		return fmt.Errorf("invalid size of an extended header, must be at least 6 bytes, but is %d bytes instead", selfSize)
-	}
-	result.selfSize = selfSize
+	consumed := uint32(4)
+
+	if header.minorVer == 3 {
+		// v2.3 size is a plain integer that doesn't include itself. What follows are
+		// flags, padding size and an optional CRC, none of which are interesting for us
+		result.selfSize = rawSize + 4
+	} else {
+		selfSize, err := syncsafeDecode(rawSize)
+		if err != nil {
+			return err
+		}
+		result.selfSize = selfSize
+		if result.selfSize < 6 {
+			return fmt.Errorf("invalid size of an extended header, must be at least 6 bytes, but is %d bytes instead", selfSize)
+		}
 
-	b, err := input.ReadByte()
-	if err != nil {
-		return err
-	}
-	extFlags := []byte{}
-	flagBytesCount := uint8(b)
-	for i := uint8(0); i < flagBytesCount; i++ {
-		b, err = input.ReadByte()
+		flagBytesCount, err := input.ReadByte()
 		if err != nil {
 			return err
 		}
-		extFlags = append(extFlags, b)
-	}
+		if flagBytesCount != 1 {
+			return fmt.Errorf("invalid amount of extended header flag bytes, expected 1, but got %d", flagBytesCount)
+		}
+		extFlags, err := input.ReadByte()
+		if err != nil {
+			return err
+		}
+		consumed += 2
 
-	for i, b := range extFlags {
-		switch {
-		case util.FindBit(b, 4):
-			i += 1
-			result.flags = append(result.flags, restrictionsFlag{data: extFlags[i]})
-		case util.FindBit(b, 5):
-			var flag crcFlag
-			for j := 0; j < len(flag.data); j++ {
-				i += 1
-				flag.data[j] = extFlags[i]
+		// Each set flag is followed by its data length and data, in order of the flags
+		for _, bit := range [...]int8{6, 5, 4} {
+			if !util.FindBit(extFlags, bit) {
+				continue
 			}
-			result.flags = append(result.flags, flag)
-		case util.FindBit(b, 6):
-			result.flags = append(result.flags, updateFlag{})
+
+			dataLen, err := input.ReadByte()
+			if err != nil {
+				return err
+			}
+			data := make([]byte, dataLen)
+			for i := range data {
+				if data[i], err = input.ReadByte(); err != nil {
+					return err
+				}
+			}
+			consumed += 1 + uint32(dataLen)
+
+			switch bit {
+			case 6:
+				result.flags = append(result.flags, updateFlag{})
+			case 5:
+				var flag crcFlag
+				copy(flag.data[:], data)
+				result.flags = append(result.flags, flag)
+			case 4:
+				var flag restrictionsFlag
+				if len(data) > 0 {
+					flag.data = data[0]
+				}
+				result.flags = append(result.flags, flag)
+			}
+		}
+	}
+
+	for ; consumed < result.selfSize; consumed += 1 {
+		if _, err := input.ReadByte(); err != nil {
+			return err
 		}
 	}
 
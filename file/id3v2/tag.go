@@ -0,0 +1,330 @@
+package id3v2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/wetfloo/voidh/util"
+)
+
+const (
+	textEncodingLatin1 byte = iota
+	textEncodingUtf16
+	textEncodingUtf16Be
+	textEncodingUtf8
+)
+
+type Tag struct {
+	header header
+	Frames []Frame
+}
+
+type Frame struct {
+	// Frame identifier. Three characters long for v2.2 tags, four for others
+	Id   string
+	Data []byte
+}
+
+// Attached picture, APIC frame for v2.3 and v2.4 tags, PIC frame for v2.2 ones
+type Picture struct {
+	MimeType string
+	// Same numbering as in FLAC PICTURE blocks
+	PicType uint8
+	Desc    string
+	Data    []byte
+}
+
+// Reads the whole tag, which must be at the very beginning of the input
+func ReadTag(r io.Reader) (Tag, error) {
+	input := bufio.NewReader(r)
+
+	var result Tag
+	h, err := newHeader(input)
+	if err != nil {
+		return result, err
+	}
+	result.header = h
+
+	body := make([]byte, h.tagSize)
+	if _, err := io.ReadFull(input, body); err != nil {
+		return result, err
+	}
+	// Starting from v2.4, unsynchronisation is done per frame
+	if h.flags.unsync() && h.minorVer < 4 {
+		body = unsyncRemove(body)
+	}
+
+	bodyReader := bytes.NewReader(body)
+	if h.flags.extendedHeaderPresent() && h.minorVer >= 3 {
+		if err := result.header.attachExtendedHeader(bodyReader); err != nil {
+			return result, err
+		}
+	}
+
+	for bodyReader.Len() > 0 {
+		frame, ok, err := readFrame(bodyReader, h.minorVer)
+		if err != nil {
+			return result, err
+		}
+		if !ok {
+			// Reached padding
+			break
+		}
+		if frame != nil {
+			result.Frames = append(result.Frames, *frame)
+		}
+	}
+
+	return result, nil
+}
+
+// Reads a single frame. Returns false if there are no more frames left. Frames that
+// can't be interpreted, like encrypted or compressed ones, are skipped and returned as nil
+func readFrame(input *bytes.Reader, minorVer uint8) (*Frame, bool, error) {
+	idLen := 4
+	if minorVer == 2 {
+		idLen = 3
+	}
+	if input.Len() < idLen {
+		return nil, false, nil
+	}
+
+	id := make([]byte, idLen)
+	if _, err := io.ReadFull(input, id); err != nil {
+		return nil, false, err
+	}
+	if id[0] == 0 {
+		return nil, false, nil
+	}
+
+	var size uint32
+	var formatFlags byte
+	switch minorVer {
+	case 2:
+		v, err := util.ReadUint24(input)
+		if err != nil {
+			return nil, false, err
+		}
+		size = v
+	default:
+		v, err := util.ReadUint32(input)
+		if err != nil {
+			return nil, false, err
+		}
+		size = v
+		if minorVer >= 4 {
+			if size, err = syncsafeDecode(v); err != nil {
+				return nil, false, err
+			}
+		}
+
+		// Status flags are of no interest to us
+		if _, err := input.ReadByte(); err != nil {
+			return nil, false, err
+		}
+		if formatFlags, err = input.ReadByte(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if uint64(size) > uint64(input.Len()) {
+		return nil, false, fmt.Errorf("frame %s claims %d bytes, but only %d are left in the tag", id, size, input.Len())
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(input, data); err != nil {
+		return nil, false, err
+	}
+
+	switch {
+	case minorVer == 3:
+		compressed := util.FindBit(formatFlags, 7)
+		encrypted := util.FindBit(formatFlags, 6)
+		if compressed || encrypted {
+			return nil, true, nil
+		}
+		// Grouping identity byte
+		if util.FindBit(formatFlags, 5) && len(data) > 0 {
+			data = data[1:]
+		}
+	case minorVer >= 4:
+		compressed := util.FindBit(formatFlags, 3)
+		encrypted := util.FindBit(formatFlags, 2)
+		if compressed || encrypted {
+			return nil, true, nil
+		}
+		if util.FindBit(formatFlags, 6) && len(data) > 0 {
+			data = data[1:]
+		}
+		if util.FindBit(formatFlags, 1) {
+			data = unsyncRemove(data)
+		}
+		// Data length indicator
+		if util.FindBit(formatFlags, 0) && len(data) >= 4 {
+			data = data[4:]
+		}
+	}
+
+	return &Frame{Id: string(id), Data: data}, true, nil
+}
+
+// All the pictures attached to this tag
+func (tag Tag) Pictures() ([]Picture, error) {
+	result := []Picture{}
+
+	for _, frame := range tag.Frames {
+		switch frame.Id {
+		case "APIC":
+			pic, err := apicRead(frame.Data)
+			if err != nil {
+				return result, err
+			}
+			result = append(result, pic)
+		case "PIC":
+			pic, err := picRead(frame.Data)
+			if err != nil {
+				return result, err
+			}
+			result = append(result, pic)
+		}
+	}
+
+	return result, nil
+}
+
+func apicRead(data []byte) (Picture, error) {
+	var result Picture
+	if len(data) < 1 {
+		return result, fmt.Errorf("APIC frame is empty")
+	}
+	encoding := data[0]
+	data = data[1:]
+
+	mimeEnd := bytes.IndexByte(data, 0)
+	if mimeEnd < 0 {
+		return result, fmt.Errorf("APIC frame mime type is not terminated")
+	}
+	result.MimeType = string(data[:mimeEnd])
+	data = data[mimeEnd+1:]
+	// Some taggers used to write just the format, like for v2.2 tags
+	if !strings.Contains(result.MimeType, "/") && result.MimeType != "" && result.MimeType != "-->" {
+		result.MimeType = "image/" + strings.ToLower(result.MimeType)
+	}
+
+	return picRest(result, encoding, data)
+}
+
+func picRead(data []byte) (Picture, error) {
+	var result Picture
+	if len(data) < 4 {
+		return result, fmt.Errorf("PIC frame is too short")
+	}
+	encoding := data[0]
+	switch strings.ToUpper(string(data[1:4])) {
+	case "JPG":
+		result.MimeType = "image/jpeg"
+	case "PNG":
+		result.MimeType = "image/png"
+	default:
+		result.MimeType = "image/" + strings.ToLower(string(data[1:4]))
+	}
+
+	return picRest(result, encoding, data[4:])
+}
+
+// Reads picture type, description and data, which are the same for APIC and PIC frames
+func picRest(pic Picture, encoding byte, data []byte) (Picture, error) {
+	if len(data) < 1 {
+		return pic, fmt.Errorf("picture frame has no picture type")
+	}
+	pic.PicType = data[0]
+	data = data[1:]
+
+	desc, rest, err := textTerminatedSplit(encoding, data)
+	if err != nil {
+		return pic, err
+	}
+	pic.Desc = desc
+	pic.Data = rest
+
+	return pic, nil
+}
+
+// Splits off a terminated string in the given encoding from the start of data
+func textTerminatedSplit(encoding byte, data []byte) (string, []byte, error) {
+	switch encoding {
+	case textEncodingUtf16, textEncodingUtf16Be:
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				s, err := textDecode(encoding, data[:i])
+				return s, data[i+2:], err
+			}
+		}
+	default:
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			s, err := textDecode(encoding, data[:i])
+			return s, data[i+1:], err
+		}
+	}
+
+	return "", nil, fmt.Errorf("string is not terminated")
+}
+
+func textDecode(encoding byte, data []byte) (string, error) {
+	switch encoding {
+	case textEncodingLatin1:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	case textEncodingUtf16, textEncodingUtf16Be:
+		if len(data)%2 != 0 {
+			return "", fmt.Errorf("odd length of UTF-16 string")
+		}
+		var order binary.ByteOrder = binary.BigEndian
+		if encoding == textEncodingUtf16 && len(data) >= 2 {
+			switch {
+			case data[0] == 0xFF && data[1] == 0xFE:
+				order = binary.LittleEndian
+				data = data[2:]
+			case data[0] == 0xFE && data[1] == 0xFF:
+				data = data[2:]
+			}
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = order.Uint16(data[i*2:])
+		}
+		return string(utf16.Decode(units)), nil
+	case textEncodingUtf8:
+		return string(data), nil
+	}
+
+	return "", fmt.Errorf("unknown text encoding %d", encoding)
+}
+
+// Reverts unsynchronisation, which inserts a zero byte after every 0xFF
+func unsyncRemove(data []byte) []byte {
+	result := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i += 1 {
+		result = append(result, data[i])
+		if data[i] == 0xFF && i+1 < len(data) && data[i+1] == 0x00 {
+			i += 1
+		}
+	}
+	return result
+}
+
+// Size of the whole tag in the file, including its header and footer, if any
+func (tag Tag) Size() uint32 {
+	result := 10 + tag.header.tagSize
+	if tag.header.flags.footerPresent() {
+		result += 10
+	}
+	return result
+}
@@ -0,0 +1,52 @@
+package id3v2
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncsafeDecode(t *testing.T) {
+	v, err := syncsafeDecode(0x00_00_02_01)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 257, v)
+
+	_, err = syncsafeDecode(0x00_00_00_80)
+	assert.NotNil(t, err)
+}
+
+func TestReadTagApic(t *testing.T) {
+	image := []byte{0xFF, 0xD8, 0xFF, 0xE0}
+
+	frameData := []byte{textEncodingLatin1}
+	frameData = append(frameData, []byte("image/jpeg\x00")...)
+	frameData = append(frameData, 3) // cover front
+	frameData = append(frameData, []byte("Cover\x00")...)
+	frameData = append(frameData, image...)
+
+	frame := []byte("APIC")
+	frame = append(frame, 0, 0, 0, byte(len(frameData)), 0, 0)
+	frame = append(frame, frameData...)
+
+	// Some padding after the only frame
+	body := append(frame, make([]byte, 16)...)
+	tag := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(body))}
+	tag = append(tag, body...)
+
+	result, err := ReadTag(bytes.NewReader(tag))
+	assert.Nil(t, err)
+	assert.Len(t, result.Frames, 1)
+
+	pics, err := result.Pictures()
+	assert.Nil(t, err)
+	assert.Len(t, pics, 1)
+	assert.Equal(t, "image/jpeg", pics[0].MimeType)
+	assert.EqualValues(t, 3, pics[0].PicType)
+	assert.Equal(t, "Cover", pics[0].Desc)
+	assert.Equal(t, image, pics[0].Data)
+}
+
+func TestUnsyncRemove(t *testing.T) {
+	assert.Equal(t, []byte{0xFF, 0xE0, 0xFF}, unsyncRemove([]byte{0xFF, 0x00, 0xE0, 0xFF}))
+}
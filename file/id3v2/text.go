@@ -0,0 +1,141 @@
+package id3v2
+
+import (
+	"strings"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+// Text frames, mapped to normalized tag keys. v2.2 tags use shorter frame ids
+var textFrameKeys = map[string]string{
+	"TIT2": file.TagTitle,
+	"TT2":  file.TagTitle,
+	"TPE1": file.TagArtist,
+	"TP1":  file.TagArtist,
+	"TALB": file.TagAlbum,
+	"TAL":  file.TagAlbum,
+	"TPE2": file.TagAlbumArtist,
+	"TP2":  file.TagAlbumArtist,
+	"TRCK": file.TagTrackNumber,
+	"TRK":  file.TagTrackNumber,
+	"TPOS": file.TagDiscNumber,
+	"TPA":  file.TagDiscNumber,
+	"TDRC": file.TagDate,
+	"TYER": file.TagDate,
+	"TYE":  file.TagDate,
+	"TCON": file.TagGenre,
+	"TCO":  file.TagGenre,
+	"TCOM": file.TagComposer,
+	"TCM":  file.TagComposer,
+	"TPE3": file.TagConductor,
+	"TP3":  file.TagConductor,
+	"TSRC": file.TagIsrc,
+	"TRC":  file.TagIsrc,
+}
+
+// Text information of the tag, with keys normalized. Frames that aren't text ones are skipped
+func (tag Tag) Tags() ([]file.Tag, error) {
+	result := []file.Tag{}
+
+	for _, frame := range tag.Frames {
+		switch {
+		case frame.Id == "TXXX" || frame.Id == "TXX":
+			if len(frame.Data) < 1 {
+				continue
+			}
+			desc, rest, err := textTerminatedSplit(frame.Data[0], frame.Data[1:])
+			if err != nil {
+				return result, err
+			}
+			values, err := textValues(frame.Data[0], rest)
+			if err != nil {
+				return result, err
+			}
+			for _, value := range values {
+				result = append(result, file.Tag{Key: strings.ToUpper(desc), Value: value})
+			}
+
+		case frame.Id == "COMM" || frame.Id == "COM":
+			// Encoding, 3 bytes of language, short description, then the comment itself
+			if len(frame.Data) < 4 {
+				continue
+			}
+			_, rest, err := textTerminatedSplit(frame.Data[0], frame.Data[4:])
+			if err != nil {
+				return result, err
+			}
+			text, err := textDecode(frame.Data[0], textTrimTerminator(frame.Data[0], rest))
+			if err != nil {
+				return result, err
+			}
+			result = append(result, file.Tag{Key: file.TagComment, Value: text})
+
+		default:
+			key, ok := textFrameKeys[frame.Id]
+			if !ok || len(frame.Data) < 1 {
+				continue
+			}
+			values, err := textValues(frame.Data[0], frame.Data[1:])
+			if err != nil {
+				return result, err
+			}
+			for _, value := range values {
+				result = append(result, numberedTags(key, value)...)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Decodes the text of a frame. Starting from v2.4, a frame can hold several NUL-separated values
+func textValues(encoding byte, data []byte) ([]string, error) {
+	text, err := textDecode(encoding, textTrimTerminator(encoding, data))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, value := range strings.Split(text, "\x00") {
+		// Every value has its own byte order mark in UTF-16 frames
+		value = strings.TrimPrefix(value, "\ufeff")
+		if value != "" {
+			result = append(result, value)
+		}
+	}
+	return result, nil
+}
+
+func textTrimTerminator(encoding byte, data []byte) []byte {
+	switch encoding {
+	case textEncodingUtf16, textEncodingUtf16Be:
+		for len(data) >= 2 && data[len(data)-2] == 0 && data[len(data)-1] == 0 {
+			data = data[:len(data)-2]
+		}
+	default:
+		for len(data) >= 1 && data[len(data)-1] == 0 {
+			data = data[:len(data)-1]
+		}
+	}
+	return data
+}
+
+// Splits values like "3/12" of track and disc numbers into the number and the total
+func numberedTags(key string, value string) []file.Tag {
+	var totalKey string
+	switch key {
+	case file.TagTrackNumber:
+		totalKey = file.TagTrackTotal
+	case file.TagDiscNumber:
+		totalKey = file.TagDiscTotal
+	default:
+		return []file.Tag{{Key: key, Value: value}}
+	}
+
+	num, total, found := strings.Cut(value, "/")
+	result := []file.Tag{{Key: key, Value: strings.TrimSpace(num)}}
+	if found && strings.TrimSpace(total) != "" {
+		result = append(result, file.Tag{Key: totalKey, Value: strings.TrimSpace(total)})
+	}
+	return result
+}
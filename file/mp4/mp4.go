@@ -0,0 +1,205 @@
+package mp4
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Type indicators of iTunes metadata 'data' atoms
+const (
+	DataTypeUtf8 uint32 = 1
+	DataTypeJpeg uint32 = 13
+	DataTypePng  uint32 = 14
+	DataTypeBmp  uint32 = 27
+)
+
+// Path of the iTunes metadata item list inside of the file
+var ilstPath = [...]string{"moov", "udta", "meta", "ilst"}
+
+type Cover struct {
+	MimeType string
+	Data     []byte
+}
+
+type atomHeader struct {
+	name string
+	// Offset of atom contents, right after the header
+	offset int64
+	// Size of atom contents, excluding the header
+	size int64
+}
+
+// Reads all cover pictures from 'covr' item of the iTunes metadata, if there is any
+func ReadCovers(r io.ReadSeeker) ([]Cover, error) {
+	result := []Cover{}
+
+	ilst, found, err := atomPathFind(r, ilstPath[:])
+	if err != nil || !found {
+		return result, err
+	}
+
+	covr, found, err := atomFind(r, ilst, "covr")
+	if err != nil || !found {
+		return result, err
+	}
+
+	children, err := atomsList(r, covr)
+	if err != nil {
+		return result, err
+	}
+	for _, child := range children {
+		if child.name != "data" || child.size < 8 {
+			continue
+		}
+
+		buf, err := atomRead(r, child)
+		if err != nil {
+			return result, err
+		}
+
+		// 1 byte of version, 3 bytes of type indicator, 4 bytes of locale
+		cover := Cover{Data: buf[8:]}
+		switch binary.BigEndian.Uint32(buf[:4]) & 0x00_FF_FF_FF {
+		case DataTypeJpeg:
+			cover.MimeType = "image/jpeg"
+		case DataTypePng:
+			cover.MimeType = "image/png"
+		case DataTypeBmp:
+			cover.MimeType = "image/bmp"
+		}
+		result = append(result, cover)
+	}
+
+	return result, nil
+}
+
+// Hashes the contents of the 'mdat' atoms, which hold the audio. Tags are kept in 'moov', retagging the
+// file leaves the hash as it is, even when the atoms are moved around. Nil if there's no 'mdat' atom
+func ReadAudioHash(r io.ReadSeeker) ([]byte, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	atoms, err := atomsList(r, atomHeader{offset: 0, size: end})
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := md5.New()
+	found := false
+	for _, atom := range atoms {
+		if atom.name != "mdat" {
+			continue
+		}
+		if _, err := r.Seek(atom.offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(hasher, r, atom.size); err != nil {
+			return nil, err
+		}
+		found = true
+	}
+	if !found {
+		return nil, nil
+	}
+	return hasher.Sum(nil), nil
+}
+
+// Finds the atom by the names of all of its ancestors, starting from the top level
+func atomPathFind(r io.ReadSeeker, path []string) (atomHeader, bool, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return atomHeader{}, false, err
+	}
+
+	parent := atomHeader{offset: 0, size: end}
+	for _, name := range path {
+		child, found, err := atomFind(r, parent, name)
+		if err != nil || !found {
+			return child, false, err
+		}
+		// 'meta' is a full atom, with version and flags preceding its children
+		if name == "meta" {
+			child.offset += 4
+			child.size -= 4
+		}
+		parent = child
+	}
+
+	return parent, true, nil
+}
+
+// Reads the contents of the atom, excluding its header
+func atomRead(r io.ReadSeeker, atom atomHeader) ([]byte, error) {
+	buf := make([]byte, atom.size)
+	if _, err := r.Seek(atom.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func atomFind(r io.ReadSeeker, parent atomHeader, name string) (atomHeader, bool, error) {
+	children, err := atomsList(r, parent)
+	if err != nil {
+		return atomHeader{}, false, err
+	}
+
+	for _, child := range children {
+		if child.name == name {
+			return child, true, nil
+		}
+	}
+
+	return atomHeader{}, false, nil
+}
+
+// Lists direct children of the parent atom
+func atomsList(r io.ReadSeeker, parent atomHeader) ([]atomHeader, error) {
+	result := []atomHeader{}
+
+	pos := parent.offset
+	end := parent.offset + parent.size
+	for pos+8 <= end {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return result, err
+		}
+
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return result, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+
+		switch size {
+		case 0:
+			// Atom extends to the end of its parent
+			size = end - pos
+		case 1:
+			var largeSize [8]byte
+			if _, err := io.ReadFull(r, largeSize[:]); err != nil {
+				return result, err
+			}
+			size = int64(binary.BigEndian.Uint64(largeSize[:]))
+			headerSize = 16
+		}
+
+		if size < headerSize || pos+size > end {
+			return result, fmt.Errorf("invalid size %d of atom %q at offset %d", size, header[4:], pos)
+		}
+
+		result = append(result, atomHeader{
+			name:   string(header[4:]),
+			offset: pos + headerSize,
+			size:   size - headerSize,
+		})
+		pos += size
+	}
+
+	return result, nil
+}
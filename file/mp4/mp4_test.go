@@ -0,0 +1,35 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func atomEncode(name string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	result := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	result = append(result, name...)
+	return append(result, body...)
+}
+
+func TestReadAudioHash(t *testing.T) {
+	hash := func(atoms ...[]byte) []byte {
+		result, err := ReadAudioHash(bytes.NewReader(bytes.Join(atoms, nil)))
+		assert.Nil(t, err)
+		return result
+	}
+	ftyp := atomEncode("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	audio := atomEncode("mdat", []byte("audio"))
+
+	original := hash(ftyp, atomEncode("moov", []byte("tags")), audio)
+	assert.NotEmpty(t, original)
+	// Retagged, with the audio moved after the tags, or before them
+	assert.Equal(t, original, hash(ftyp, atomEncode("moov", []byte("other tags")), audio))
+	assert.Equal(t, original, hash(ftyp, audio, atomEncode("moov", []byte("tags"))))
+
+	assert.NotEqual(t, original, hash(ftyp, atomEncode("moov", []byte("tags")), atomEncode("mdat", []byte("other"))))
+	assert.Nil(t, hash(ftyp, atomEncode("moov", []byte("tags"))))
+}
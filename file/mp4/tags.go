@@ -0,0 +1,212 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+// iTunes metadata items, mapped to normalized tag keys
+var itemKeys = map[string]string{
+	"\xa9nam": file.TagTitle,
+	"\xa9ART": file.TagArtist,
+	"\xa9alb": file.TagAlbum,
+	"aART":    file.TagAlbumArtist,
+	"\xa9day": file.TagDate,
+	"\xa9gen": file.TagGenre,
+	"\xa9wrt": file.TagComposer,
+	"\xa9cmt": file.TagComment,
+}
+
+// Freeform '----' items, mapped to normalized tag keys. Unknown ones are upper-cased
+var freeformKeys = map[string]string{
+	"MusicBrainz Album Id": file.TagMbAlbumId,
+	"MusicBrainz Track Id": file.TagMbTrackId,
+	"ISRC":                 file.TagIsrc,
+	"CONDUCTOR":            file.TagConductor,
+}
+
+// Sample entry types, mapped to codec names
+var codecNames = map[string]string{
+	"mp4a": "aac",
+	"alac": "alac",
+	"fLaC": "flac",
+	"Opus": "opus",
+	"ac-3": "ac3",
+}
+
+// Reads text items of the iTunes metadata, with their keys normalized
+func ReadTags(r io.ReadSeeker) ([]file.Tag, error) {
+	result := []file.Tag{}
+
+	ilst, found, err := atomPathFind(r, ilstPath[:])
+	if err != nil || !found {
+		return result, err
+	}
+
+	items, err := atomsList(r, ilst)
+	if err != nil {
+		return result, err
+	}
+	for _, item := range items {
+		children, err := atomsList(r, item)
+		if err != nil {
+			return result, err
+		}
+
+		key := itemKeys[item.name]
+		for _, child := range children {
+			data, err := atomRead(r, child)
+			if err != nil {
+				return result, err
+			}
+
+			switch {
+			case child.name == "name" && item.name == "----" && len(data) > 4:
+				// Version and flags precede the name
+				name := string(data[4:])
+				key = freeformKeys[name]
+				if key == "" {
+					key = strings.ToUpper(name)
+				}
+
+			case child.name != "data" || len(data) < 8:
+				continue
+
+			case item.name == "trkn" || item.name == "disk":
+				// Reserved, then number and total, 2 bytes each
+				if len(data) < 8+6 {
+					continue
+				}
+				numKey, totalKey := file.TagTrackNumber, file.TagTrackTotal
+				if item.name == "disk" {
+					numKey, totalKey = file.TagDiscNumber, file.TagDiscTotal
+				}
+				num := binary.BigEndian.Uint16(data[8+2:])
+				total := binary.BigEndian.Uint16(data[8+4:])
+				if num > 0 {
+					result = append(result, file.Tag{Key: numKey, Value: strconv.Itoa(int(num))})
+				}
+				if total > 0 {
+					result = append(result, file.Tag{Key: totalKey, Value: strconv.Itoa(int(total))})
+				}
+
+			case key != "" && binary.BigEndian.Uint32(data[:4])&0x00_FF_FF_FF == DataTypeUtf8:
+				result = append(result, file.Tag{Key: key, Value: string(data[8:])})
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Reads technical properties of the first audio track
+func ReadProperties(r io.ReadSeeker) (file.AudioProperties, error) {
+	var result file.AudioProperties
+
+	moov, found, err := atomPathFind(r, []string{"moov"})
+	if err != nil || !found {
+		return result, err
+	}
+	traks, err := atomsList(r, moov)
+	if err != nil {
+		return result, err
+	}
+
+	for _, trak := range traks {
+		if trak.name != "trak" {
+			continue
+		}
+		mdia, found, err := atomFind(r, trak, "mdia")
+		if err != nil {
+			return result, err
+		}
+		if !found {
+			continue
+		}
+
+		hdlr, found, err := atomFind(r, mdia, "hdlr")
+		if err != nil {
+			return result, err
+		}
+		if !found {
+			continue
+		}
+		// Version and flags, pre-defined, then handler type
+		hdlrData, err := atomRead(r, hdlr)
+		if err != nil {
+			return result, err
+		}
+		if len(hdlrData) < 12 || string(hdlrData[8:12]) != "soun" {
+			continue
+		}
+
+		var timescale, duration uint64
+		mdhd, found, err := atomFind(r, mdia, "mdhd")
+		if err != nil {
+			return result, err
+		}
+		if found {
+			data, err := atomRead(r, mdhd)
+			if err != nil {
+				return result, err
+			}
+			switch {
+			case len(data) >= 20 && data[0] == 0:
+				timescale = uint64(binary.BigEndian.Uint32(data[12:]))
+				duration = uint64(binary.BigEndian.Uint32(data[16:]))
+			case len(data) >= 32 && data[0] == 1:
+				timescale = uint64(binary.BigEndian.Uint32(data[20:]))
+				duration = binary.BigEndian.Uint64(data[24:])
+			}
+		}
+
+		minf, found, err := atomFind(r, mdia, "minf")
+		if err != nil || !found {
+			return result, err
+		}
+		stbl, found, err := atomFind(r, minf, "stbl")
+		if err != nil || !found {
+			return result, err
+		}
+		stsd, found, err := atomFind(r, stbl, "stsd")
+		if err != nil || !found {
+			return result, err
+		}
+		data, err := atomRead(r, stsd)
+		if err != nil {
+			return result, err
+		}
+
+		// Version, flags and entries count, then the first sample entry
+		const entryOffset = 8
+		if len(data) < entryOffset+36 {
+			return result, nil
+		}
+		entry := data[entryOffset:]
+		entryType := string(entry[4:8])
+		result.Codec = codecNames[entryType]
+		if result.Codec == "" {
+			result.Codec = entryType
+		}
+		result.Channels = uint8(binary.BigEndian.Uint16(entry[24:]))
+		result.BitsPerSample = uint8(binary.BigEndian.Uint16(entry[26:]))
+		// 16.16 fixed point
+		result.SampleRate = binary.BigEndian.Uint32(entry[32:]) >> 16
+
+		if timescale > 0 && result.SampleRate > 0 {
+			result.SamplesTotal = duration * uint64(result.SampleRate) / timescale
+		}
+		// Lossy codecs don't really have a bit depth, the field is always 16 for them
+		if result.Codec == "aac" || result.Codec == "opus" {
+			result.BitsPerSample = 0
+		}
+
+		return result, nil
+	}
+
+	return result, nil
+}
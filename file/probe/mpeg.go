@@ -0,0 +1,224 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+// How far to look for the first frame, some files have garbage between the tag and the audio
+const mpegSyncSearchLimit = 64 * 1024
+
+// Bitrates in kbit/s, by version (1 or 2/2.5), layer and bitrate index
+var mpegBitrates = [2][3][16]uint32{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+}
+
+// Size of the ID3v1 tag at the very end of the file
+const id3v1Size = 128
+
+// Size of the header and of the footer of APEv2 tags
+const apeFooterSize = 32
+
+// Sample rates for MPEG 1, sample rates of other versions are fractions of them
+var mpegSampleRates = [3]uint32{44100, 48000, 32000}
+
+type mpegHeader struct {
+	// 1 for MPEG 1, 2 for MPEG 2, 25 for MPEG 2.5
+	version    int
+	layer      int
+	bitrate    uint32
+	sampleRate uint32
+	channels   uint8
+}
+
+func mpegHeaderParse(raw uint32) (mpegHeader, bool) {
+	var result mpegHeader
+	if raw&0xFF_E0_00_00 != 0xFF_E0_00_00 {
+		return result, false
+	}
+
+	switch (raw >> 19) & 0b11 {
+	case 0b00:
+		result.version = 25
+	case 0b10:
+		result.version = 2
+	case 0b11:
+		result.version = 1
+	default:
+		return result, false
+	}
+
+	layerBits := (raw >> 17) & 0b11
+	if layerBits == 0 {
+		return result, false
+	}
+	result.layer = int(4 - layerBits)
+
+	bitrateIndex := (raw >> 12) & 0b1111
+	sampleRateIndex := (raw >> 10) & 0b11
+	if bitrateIndex == 0b1111 || sampleRateIndex == 0b11 {
+		return result, false
+	}
+
+	versionIndex := 0
+	if result.version != 1 {
+		versionIndex = 1
+	}
+	result.bitrate = mpegBitrates[versionIndex][result.layer-1][bitrateIndex] * 1000
+
+	result.sampleRate = mpegSampleRates[sampleRateIndex]
+	switch result.version {
+	case 2:
+		result.sampleRate /= 2
+	case 25:
+		result.sampleRate /= 4
+	}
+
+	result.channels = 2
+	if (raw>>6)&0b11 == 0b11 {
+		result.channels = 1
+	}
+
+	return result, true
+}
+
+func (header mpegHeader) samplesPerFrame() uint64 {
+	switch {
+	case header.layer == 1:
+		return 384
+	case header.layer == 3 && header.version != 1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// Offset of the Xing/Info header from the frame start, depends on version and channels
+func (header mpegHeader) xingOffset() int {
+	switch {
+	case header.version == 1 && header.channels == 2:
+		return 4 + 32
+	case header.version == 1, header.channels == 2:
+		return 4 + 17
+	default:
+		return 4 + 9
+	}
+}
+
+// Reads properties out of the first MPEG audio frame. Total samples count comes
+// from the Xing header for VBR files, and is estimated from the bitrate otherwise
+func mpegPropertiesRead(input *bufio.Reader, audioLen int64) (file.AudioProperties, error) {
+	var result file.AudioProperties
+
+	var raw uint32
+	var header mpegHeader
+	found := false
+	for i := 0; i < mpegSyncSearchLimit; i += 1 {
+		b, err := input.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		raw = raw<<8 | uint32(b)
+		if i < 3 {
+			continue
+		}
+		if header, found = mpegHeaderParse(raw); found {
+			break
+		}
+	}
+	if !found {
+		return result, fmt.Errorf("no MPEG audio frame found")
+	}
+
+	result.Codec = fmt.Sprintf("mp%d", header.layer)
+	result.SampleRate = header.sampleRate
+	result.Channels = header.channels
+	result.Bitrate = header.bitrate
+
+	// The rest of the frame, up to and including a possible Xing header
+	frame := make([]byte, header.xingOffset()-4+16)
+	if _, err := io.ReadFull(input, frame); err == nil {
+		xing := frame[header.xingOffset()-4:]
+		tag := string(xing[:4])
+		flags := binary.BigEndian.Uint32(xing[4:8])
+		// Frames count is present
+		if (tag == "Xing" || tag == "Info") && flags&0b1 != 0 {
+			framesCount := uint64(binary.BigEndian.Uint32(xing[8:12]))
+			result.SamplesTotal = framesCount * header.samplesPerFrame()
+			if result.DurationMs() > 0 {
+				result.Bitrate = uint32(uint64(audioLen) * 8 * 1000 / result.DurationMs())
+			}
+			return result, nil
+		}
+	}
+
+	if header.bitrate > 0 {
+		result.SamplesTotal = uint64(audioLen) * 8 * uint64(header.sampleRate) / uint64(header.bitrate)
+	}
+
+	return result, nil
+}
+
+// Where the audio frames end, before the tags that may follow them: APEv2, then ID3v1
+func mpegAudioEnd(r io.ReaderAt, size int64) (int64, error) {
+	result := size
+
+	if result >= id3v1Size {
+		magic := make([]byte, 3)
+		if _, err := r.ReadAt(magic, result-id3v1Size); err != nil {
+			return result, err
+		}
+		if string(magic) == "TAG" {
+			result -= id3v1Size
+		}
+	}
+
+	if result >= apeFooterSize {
+		footer := make([]byte, apeFooterSize)
+		if _, err := r.ReadAt(footer, result-apeFooterSize); err != nil {
+			return result, err
+		}
+		if bytes.HasPrefix(footer, []byte("APETAGEX")) {
+			// The size takes in the items and the footer, the header is there if the top bit of the flags is set
+			tagSize := int64(binary.LittleEndian.Uint32(footer[12:16]))
+			if binary.LittleEndian.Uint32(footer[20:24])&(1<<31) != 0 {
+				tagSize += apeFooterSize
+			}
+			if tagSize <= result {
+				result -= tagSize
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Hashes the audio frames between the tags, which are left as they are when the file is retagged
+func mpegAudioHash(r io.ReaderAt, start int64, end int64) ([]byte, error) {
+	if end <= start {
+		return nil, nil
+	}
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(r, start, end-start)); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
@@ -0,0 +1,142 @@
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/file/flac"
+	"github.com/wetfloo/voidh/file/id3v2"
+	"github.com/wetfloo/voidh/file/mp4"
+)
+
+var UnsupportedFormatErr = fmt.Errorf("unsupported audio format")
+
+// Reads tags and technical properties of the audio file. Returns [UnsupportedFormatErr]
+// for files that are not audio, or are in a format we can't read yet
+func Read(fsFile file.FsFile) (file.AudioFile, error) {
+	result := file.AudioFile{FsFile: fsFile, Tags: []file.Tag{}}
+
+	f, err := os.Open(fsFile.Name)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(fsFile.Name)) {
+	case ".flac":
+		err = flacRead(f, &result)
+	case ".mp3":
+		err = mp3Read(f, &result)
+	case ".m4a", ".m4b", ".mp4", ".alac":
+		err = mp4Read(f, &result)
+	default:
+		return result, UnsupportedFormatErr
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if result.Properties.Bitrate == 0 {
+		if info, err := f.Stat(); err == nil && result.Properties.DurationMs() > 0 {
+			result.Properties.Bitrate = uint32(uint64(info.Size()) * 8 * 1000 / result.Properties.DurationMs())
+		}
+	}
+
+	return result, nil
+}
+
+func flacRead(r io.Reader, af *file.AudioFile) error {
+	stream, err := flac.ReadStream(r, flac.ReadCfg{ReadMetadata: true, ReadFrames: false})
+	if err != nil {
+		return err
+	}
+
+	for _, block := range stream.Metadata {
+		switch v := block.(type) {
+		case flac.StreamInfo:
+			af.Properties = file.AudioProperties{
+				Codec:         "flac",
+				SampleRate:    v.SampleRate,
+				Channels:      v.Channels + 1,
+				BitsPerSample: v.BitsPerSample + 1,
+				SamplesTotal:  v.SamplesTotal,
+			}
+			// Encoders are allowed to leave it unset
+			if v.AudioUnencHash != [16]byte{} {
+				af.AudioStreamHash = v.AudioUnencHash[:]
+			}
+		case flac.VorbisComment:
+			for _, comment := range v.Data {
+				af.Tags = append(af.Tags, file.Tag{
+					Key:   strings.ToUpper(comment.Name),
+					Value: comment.Value,
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+func mp3Read(f *os.File, af *file.AudioFile) error {
+	input := bufio.NewReader(f)
+
+	audioOffset := int64(0)
+	magic, err := input.Peek(3)
+	if err == nil && bytes.Equal(magic, []byte("ID3")) {
+		tag, err := id3v2.ReadTag(input)
+		if err != nil {
+			return err
+		}
+		tags, err := tag.Tags()
+		if err != nil {
+			return err
+		}
+		af.Tags = append(af.Tags, tags...)
+		audioOffset = int64(tag.Size())
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(audioOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	props, err := mpegPropertiesRead(bufio.NewReader(f), info.Size()-audioOffset)
+	if err != nil {
+		return err
+	}
+	af.Properties = props
+
+	audioEnd, err := mpegAudioEnd(f, info.Size())
+	if err != nil {
+		return err
+	}
+	af.AudioStreamHash, err = mpegAudioHash(f, audioOffset, audioEnd)
+	return err
+}
+
+func mp4Read(f *os.File, af *file.AudioFile) error {
+	tags, err := mp4.ReadTags(f)
+	if err != nil {
+		return err
+	}
+	af.Tags = append(af.Tags, tags...)
+
+	props, err := mp4.ReadProperties(f)
+	if err != nil {
+		return err
+	}
+	af.Properties = props
+
+	af.AudioStreamHash, err = mp4.ReadAudioHash(f)
+	return err
+}
@@ -0,0 +1,57 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+)
+
+func id3Encode(title string) []byte {
+	frameData := append([]byte{0}, title...)
+	frame := []byte("TIT2")
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(frameData)))
+	frame = append(frame, 0, 0)
+	frame = append(frame, frameData...)
+
+	// Sizes of tags are syncsafe, 7 bits in each byte
+	size := len(frame)
+	result := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	return append(result, frame...)
+}
+
+// MPEG 1 layer 3 frames of 128 kbit/s at 44.1 kHz, filled with the byte
+func mpegFramesEncode(fill byte, count int) []byte {
+	frame := bytes.Repeat([]byte{fill}, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x00})
+	return bytes.Repeat(frame, count)
+}
+
+func TestMp3AudioStreamHash(t *testing.T) {
+	dir := t.TempDir()
+	read := func(name string, parts ...[]byte) file.AudioFile {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(path, bytes.Join(parts, nil), 0o644))
+		af, err := Read(file.FsFile{Name: path})
+		assert.Nil(t, err)
+		return af
+	}
+	id3v1 := append([]byte("TAG"), make([]byte, 125)...)
+	ape := append([]byte("APETAGEX"), make([]byte, 24)...)
+	binary.LittleEndian.PutUint32(ape[12:16], 32)
+
+	original := read("original.mp3", id3Encode("One"), mpegFramesEncode(1, 4))
+	assert.NotEmpty(t, original.AudioStreamHash)
+
+	// Retagged, the tags at both ends are left out
+	retagged := read("retagged.mp3", id3Encode("Another one"), mpegFramesEncode(1, 4), ape, id3v1)
+	assert.Equal(t, []file.Tag{{Key: file.TagTitle, Value: "Another one"}}, retagged.Tags)
+	assert.Equal(t, original.AudioStreamHash, retagged.AudioStreamHash)
+
+	other := read("other.mp3", id3Encode("One"), mpegFramesEncode(2, 4))
+	assert.NotEqual(t, original.AudioStreamHash, other.AudioStreamHash)
+}
@@ -0,0 +1,21 @@
+package file
+
+// Normalized tag keys, following Vorbis comment naming
+const (
+	TagTitle       = "TITLE"
+	TagArtist      = "ARTIST"
+	TagAlbum       = "ALBUM"
+	TagAlbumArtist = "ALBUMARTIST"
+	TagTrackNumber = "TRACKNUMBER"
+	TagTrackTotal  = "TRACKTOTAL"
+	TagDiscNumber  = "DISCNUMBER"
+	TagDiscTotal   = "DISCTOTAL"
+	TagDate        = "DATE"
+	TagGenre       = "GENRE"
+	TagComposer    = "COMPOSER"
+	TagConductor   = "CONDUCTOR"
+	TagIsrc        = "ISRC"
+	TagComment     = "COMMENT"
+	TagMbAlbumId   = "MUSICBRAINZ_ALBUMID"
+	TagMbTrackId   = "MUSICBRAINZ_TRACKID"
+)
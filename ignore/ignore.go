@@ -0,0 +1,121 @@
+package ignore
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rule of a .gitignore-like file
+type Rule struct {
+	re *regexp.Regexp
+	// Takes files matched by the rules before back in
+	negate  bool
+	dirOnly bool
+}
+
+// Rules in the order they were written, the last one matching a path decides
+type Rules []Rule
+
+// Reads the rules, one per line. Empty lines and comments are skipped, so are
+// malformed rules, which are reported to the caller
+func Parse(lines []string) (Rules, []error) {
+	result := Rules{}
+	errs := []error{}
+	for _, line := range lines {
+		rule, ok, err := ruleParse(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ignore rule %q: %w", line, err))
+		} else if ok {
+			result = append(result, rule)
+		}
+	}
+	return result, errs
+}
+
+func ruleParse(line string) (Rule, bool, error) {
+	var result Rule
+
+	line = strings.TrimRight(strings.TrimSuffix(line, "\r"), " ")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return result, false, nil
+	}
+	if strings.HasPrefix(line, "!") {
+		result.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		result.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return result, false, nil
+	}
+
+	// Rules with a slash are relative to the directory of the file they are in, others match names at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(line[i:], "/**") && i+3 == len(line):
+			expr.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(line[i:], "**"):
+			expr.WriteString(".*")
+			i += 1
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				return result, false, fmt.Errorf("unterminated character class")
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			i += 1
+			expr.WriteString(regexp.QuoteMeta(string(line[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return result, false, err
+	}
+	result.re = re
+	return result, true, nil
+}
+
+// Whether the path, relative to the directory of the rules and with forward slashes, is
+// ignored, given whether it was by the rules that come before, which these take precedence over
+func (rules Rules) Match(rel string, dir bool, ignored bool) bool {
+	for _, rule := range rules {
+		if rule.dirOnly && !dir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,49 @@
+package ignore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	rules, errs := Parse([]string{
+		"# comment",
+		"",
+		"*.log",
+		"!keep.log",
+		"scans/",
+		"/top.flac",
+		"deep/**/*.jpg",
+		"disc?/[!a-c]*.cue",
+		`\#hash.flac`,
+		"[unterminated",
+	})
+	assert.Len(t, errs, 1)
+
+	cases := []struct {
+		rel     string
+		dir     bool
+		ignored bool
+	}{
+		{"rip.log", false, true},
+		{"album/rip.log", false, true},
+		{"album/keep.log", false, false},
+		{"album/scans", true, true},
+		// Directory rules don't match files
+		{"album/scans", false, false},
+		{"top.flac", false, true},
+		{"album/top.flac", false, false},
+		{"deep/cover.jpg", false, true},
+		{"deep/a/b/cover.jpg", false, true},
+		{"other/deep/cover.jpg", false, false},
+		{"disc1/d.cue", false, true},
+		{"disc1/a.cue", false, false},
+		{"disc10/d.cue", false, false},
+		{"#hash.flac", false, true},
+		{"song.flac", false, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.ignored, rules.Match(c.rel, c.dir, false), c.rel)
+	}
+}
@@ -1,35 +1,670 @@
+// Build with -tags sqlite_fts5 to search SQLite libraries, everything else works without it:
+//
+//	go build -tags sqlite_fts5
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/wetfloo/voidh/archive"
+	"github.com/wetfloo/voidh/artwork"
+	"github.com/wetfloo/voidh/cas"
+	"github.com/wetfloo/voidh/cli/extern/ffmpeg"
+	"github.com/wetfloo/voidh/dupes"
+	"github.com/wetfloo/voidh/file"
 	"github.com/wetfloo/voidh/repo"
 	"github.com/wetfloo/voidh/watch"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const usage = `usage:
+  voidh watch [-cas hardlink|reflink] [-workers 4] [-read-limit 50] [-ignore-file rules] [-all-files] [dir...]
+                       index the roots of the library, adding the given directories to them,
+                       and keep watching them, optionally ingesting files into the
+                       content-addressable store of each root. Files are read by as many
+                       workers as given, at most as many MiB/s as given, if given. Only
+                       audio files and the ones that come with them are indexed, unless
+                       -all-files is given, leaving out the ones matched by the rules of
+                       the file, if given, and of .voidhignore files, same as .gitignore
+  voidh root add [-read-only] [-ignore *.log,scans/*] [-hash sha1|sha256] [-no-tags] <dir>
+                       add the directory to the roots of the library, or change its settings
+  voidh root rm <dir>  remove the root from the library, along with all of its files
+  voidh root ls        print the roots of the library with their settings
+  voidh quarantine [release <path>]
+                       print the files the watcher has given up on, or have it try
+                       the file again, or all files inside of the directory
+  voidh dump           print everything in the library, for diagnostics
+  voidh log [-artist name] [-title title] [path]
+                       print the history of the file, or of the files of the tracks by the
+                       artist and with the title, or the latest changes of all files
+  voidh search <query> print tracks matching the query, best matches first. Words can be
+                       scoped to a field, like artist:beatles, with title, artist, album or tag
+  voidh extract <file> <track> [out]
+                       cut the track of the cuesheet of the file into a FLAC file of its own,
+                       named after the number and the title of the track by default. Needs ffmpeg
+  voidh foreign <file> <out>
+                       restore the WAV or AIFF file the FLAC file was encoded from with
+                       flac --keep-foreign-metadata. Needs ffmpeg
+  voidh validate [-fix] [-max-size 1048576] [-max-dimension 3000] <file...>
+                       check pictures embedded into FLAC files against the images they carry,
+                       and with -fix, make the declared properties match the images
+  voidh dupes [-rules lossless,bitdepth,tags] [-tolerance 2s]
+                       print groups of duplicate files as JSON. The file to keep of each is picked
+                       by the rules, in order, out of lossless, bitdepth, tags and bitrate
+  voidh export [file]  write the whole library as NDJSON, to stdout by default
+  voidh import [file]  restore the library from an export, read from stdin by default
+  voidh backup <file>  copy the database to a new file, even while it's being watched
+  voidh check          look for damage of the database, and for files that are gone or changed
+  voidh gc <dir>       remove blobs no file refers to from the content-addressable store of the directory`
+
 func main() {
 	slog.SetLogLoggerLevel(slog.LevelDebug.Level())
 
-	dir := os.Args[1]
-	slog.Info("Starting to watch directory", "dir", dir)
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "watch":
+		err = watchRun(os.Args[2:])
+	case "root":
+		err = rootRun(os.Args[2:])
+	case "quarantine":
+		if !(len(os.Args) == 2 || len(os.Args) == 4 && os.Args[2] == "release") {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		path := ""
+		if len(os.Args) == 4 {
+			path = os.Args[3]
+		}
+		err = quarantineRun(path)
+	case "dump":
+		err = dumpRun()
+	case "log":
+		err = logRun(os.Args[2:])
+	case "search":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		err = searchRun(strings.Join(os.Args[2:], " "))
+	case "extract":
+		if len(os.Args) != 4 && len(os.Args) != 5 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		out := ""
+		if len(os.Args) == 5 {
+			out = os.Args[4]
+		}
+		err = extractRun(os.Args[2], os.Args[3], out)
+	case "foreign":
+		if len(os.Args) != 4 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		err = ffmpeg.ExportForeign(context.Background(), os.Args[2], os.Args[3])
+	case "validate":
+		err = validateRun(os.Args[2:])
+	case "dupes":
+		err = dupesRun(os.Args[2:])
+	case "export", "import":
+		if len(os.Args) > 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		path := ""
+		if len(os.Args) == 3 {
+			path = os.Args[2]
+		}
+		if os.Args[1] == "export" {
+			err = exportRun(path)
+		} else {
+			err = importRun(path)
+		}
+	case "backup":
+		if len(os.Args) != 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		err = backupRun(os.Args[2])
+	case "check":
+		err = checkRun()
+	case "gc":
+		if len(os.Args) != 3 {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+		err = gcRun(os.Args[2])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
 
-	repo, err := repo.Init(repo.Config{
-		DatabasePath:    "voidh.db",
-		DebugSelections: true,
-		RemoveIfExists:  true,
-	})
 	if err != nil {
-		panic(err)
+		slog.Error("Failed", "err", err)
+		os.Exit(1)
 	}
+}
 
-	watch, err := watch.New(repo, dir)
+// Link modes of the content-addressable store, by their names in flags
+var casModes = map[string]cas.LinkMode{
+	"hardlink": cas.LinkHard,
+	"reflink":  cas.LinkReflink,
+}
+
+// How often the watcher catches up with roots changed by voidh root, while it's running
+const rootsSyncEvery = 10 * time.Second
+
+func watchRun(args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	casMode := flags.String("cas", "", "ingest files into the content-addressable store, with hardlink or reflink")
+	poolCfg := watch.DefaultPoolCfg()
+	flags.IntVar(&poolCfg.Workers, "workers", poolCfg.Workers, "how many files are read at once")
+	readLimit := flags.Int64("read-limit", 0, "MiB/s files are read at most, 0 for no limit")
+	ignoreFile := flags.String("ignore-file", "", "file of rules of files to leave out of all roots, same as .gitignore")
+	allFiles := flags.Bool("all-files", false, "index files of all kinds, not only audio files and the ones that come with them")
+	flags.Parse(args)
+	poolCfg.ReadRate = *readLimit * 1024 * 1024
+
+	store, err := storeOpen()
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer store.Close()
 
-	go watch.Start()
-	defer watch.Stop()
+	// Directories that are roots already keep their settings
+	roots, err := store.Roots()
+	if err != nil {
+		return err
+	}
+	for _, dir := range flags.Args() {
+		path, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(roots, func(root repo.Root) bool { return root.Path == path }) {
+			continue
+		}
+		if err := store.AddRoot(repo.DefaultRoot(path)); err != nil {
+			return err
+		}
+		roots = append(roots, repo.DefaultRoot(path))
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("nothing to watch, give a directory, or add one with voidh root add")
+	}
+
+	artworkCache, err := artwork.NewCache("artwork", artwork.DefaultThumbCfg())
+	if err != nil {
+		return err
+	}
+
+	watcher, err := watch.New(store, artworkCache)
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+	watcher.PoolUse(poolCfg)
+	ignoreCfg := watch.DefaultIgnoreCfg()
+	if *allFiles {
+		ignoreCfg.Extensions = nil
+	}
+	if *ignoreFile != "" {
+		rules, err := os.ReadFile(*ignoreFile)
+		if err != nil {
+			return err
+		}
+		ignoreCfg.Patterns = append(ignoreCfg.Patterns, strings.Split(string(rules), "\n")...)
+	}
+	watcher.IgnoreUse(ignoreCfg)
+	if *casMode != "" {
+		mode, ok := casModes[*casMode]
+		if !ok {
+			return fmt.Errorf("unknown link mode %q", *casMode)
+		}
+		cfg := cas.DefaultCfg()
+		cfg.Mode = mode
+		if err := watcher.CasUse(cfg); err != nil {
+			return err
+		}
+	}
+
+	for _, root := range roots {
+		slog.Info("Starting to watch root", "root", root.Path)
+	}
+	stats, err := watcher.Scan(func(stats watch.ScanStats) {
+		slog.Info("Scanning roots", "seen", stats.Seen, "hashed", stats.Hashed)
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("Scanned roots", "added", stats.Added, "changed", stats.Changed, "moved", stats.Moved, "removed", stats.Removed)
+
+	go func() {
+		for err := range watcher.Errors() {
+			slog.Warn("error while watching", "err", err)
+		}
+	}()
+	go watcher.Start()
 
 	// Do not allow the program to quit until user request
-	<-make(chan struct{})
+	for range time.Tick(rootsSyncEvery) {
+		if err := watcher.RootsSync(); err != nil {
+			slog.Warn("can't sync roots", "err", err)
+		}
+	}
+	return nil
+}
+
+func rootRun(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("root "+args[0], flag.ExitOnError)
+	readOnly := flags.Bool("read-only", false, "never write to files of the root, e.g. never link them to the content-addressable store")
+	ignore := flags.String("ignore", "", "rules of files to leave out, same as .gitignore, separated by commas")
+	hashAlgo := flags.String("hash", string(repo.HashSha1), "hash algorithm for files of the root, sha1 or sha256")
+	noTags := flags.Bool("no-tags", false, "index files by their contents only, without reading tags")
+	flags.Parse(args[1:])
+	if !(args[0] == "ls" && flags.NArg() == 0) && !((args[0] == "add" || args[0] == "rm") && flags.NArg() == 1) {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "ls":
+		roots, err := store.Roots()
+		if err != nil {
+			return err
+		}
+		for _, root := range roots {
+			fmt.Printf("%s\tread_only=%t\thash=%s\tparse_tags=%t\tignore=%s\n",
+				root.Path, root.ReadOnly, root.Hash, root.ParseTags, strings.Join(root.Ignore, ","))
+		}
+		return nil
+
+	case "add":
+		path, err := filepath.Abs(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		root := repo.DefaultRoot(path)
+		root.ReadOnly = *readOnly
+		root.Hash = repo.HashAlgo(*hashAlgo)
+		root.ParseTags = !*noTags
+		for _, pattern := range strings.Split(*ignore, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				root.Ignore = append(root.Ignore, pattern)
+			}
+		}
+		return store.AddRoot(root)
+
+	default:
+		path, err := filepath.Abs(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		return store.RemoveRoot(path)
+	}
+}
+
+// Prints the files the watcher has given up on, or releases the one at the path, if given
+func quarantineRun(path string) error {
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if path != "" {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		return store.Release(path)
+	}
+
+	list, err := store.Quarantined()
+	if err != nil {
+		return err
+	}
+	for _, q := range list {
+		fmt.Printf("%s\t%s\tattempts=%d\t%s\n", q.Name, q.At.Format("2006-01-02 15:04:05"), q.Attempts, q.Reason)
+	}
+	return nil
+}
+
+// Prints every file in the library along with its tags, ordered by name
+func dumpRun() error {
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for f, err := range store.List(repo.Query{Order: []repo.Order{{Key: repo.Filename{}}}}) {
+		if err != nil {
+			return err
+		}
+
+		af, err := store.GetByPath(f.Name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%x\t%d\t%s\n", f.Name, f.Hash, f.Size, f.ModTime.Format("2006-01-02 15:04:05"))
+		for _, tag := range af.Tags {
+			fmt.Printf("\t%s=%s\n", tag.Key, tag.Value)
+		}
+	}
+	return nil
+}
+
+// Number of changes printed by the log command without a path
+const logRecentLimit = 100
+
+func logRun(args []string) error {
+	flags := flag.NewFlagSet("log", flag.ExitOnError)
+	artist := flags.String("artist", "", "artist of the tracks to print the history of")
+	title := flags.String("title", "", "title of the tracks to print the history of")
+	flags.Parse(args)
+
+	conds := []repo.Cond{}
+	if *artist != "" {
+		conds = append(conds, repo.Eq(repo.Tag{Name: file.TagArtist}, *artist))
+	}
+	if *title != "" {
+		conds = append(conds, repo.Eq(repo.Title{}, *title))
+	}
+	if flags.NArg() > 1 || (flags.NArg() == 1 && len(conds) > 0) {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var events []repo.Event
+	switch {
+	case len(conds) > 0:
+		events, err = store.TrackHistory(repo.And(conds...))
+	case flags.NArg() == 1:
+		events, err = store.History(flags.Arg(0))
+	default:
+		events, err = store.RecentEvents(logRecentLimit)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, e := range events {
+		fmt.Printf("%s\t%s\t%s", e.At.Format("2006-01-02 15:04:05"), e.Kind, e.Source)
+		switch e.Kind {
+		case repo.EventCreate:
+			fmt.Printf("\t%s (%x)\n", e.NewName, e.NewHash)
+		case repo.EventDelete:
+			fmt.Printf("\t%s (%x)\n", e.OldName, e.OldHash)
+		default:
+			fmt.Printf("\t%s (%x) -> %s (%x)\n", e.OldName, e.OldHash, e.NewName, e.NewHash)
+		}
+	}
+	return nil
+}
+
+func searchRun(query string) error {
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	hits, err := store.Search(query)
+	if err != nil {
+		return err
+	}
+	for _, hit := range hits {
+		fmt.Printf("%s\t%s\n", hit.Name, hit.Title)
+	}
+	return nil
+}
+
+func extractRun(path string, trackNum string, out string) error {
+	num, err := strconv.ParseUint(trackNum, 10, 8)
+	if err != nil {
+		return fmt.Errorf("invalid track number %q", trackNum)
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	tracks, err := store.VirtualTracks(path)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(tracks, func(track file.VirtualTrack) bool { return track.TrackNum == uint8(num) })
+	if i < 0 {
+		return fmt.Errorf("%s has no track %d", path, num)
+	}
+	track := tracks[i]
+
+	if out == "" {
+		out = fmt.Sprintf("%02d", track.TrackNum)
+		if track.Title != "" {
+			// Titles can have anything in them, but not every character is allowed in names
+			out += " " + strings.Map(func(r rune) rune {
+				if strings.ContainsRune(`/\:*?"<>|`, r) {
+					return '_'
+				}
+				return r
+			}, track.Title)
+		}
+		out += ".flac"
+	}
+	return ffmpeg.ExtractVirtualTrack(context.Background(), track, out)
+}
+
+// Exits with 1 if there are any problems left, so that it can be run by cron and such
+func validateRun(args []string) error {
+	cfg := artwork.DefaultValidateCfg()
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "rewrite declared properties of pictures to match the images")
+	flags.Uint64Var(&cfg.MaxSize, "max-size", cfg.MaxSize, "bytes pictures can have at most, 0 for no limit")
+	maxDimension := flags.Uint("max-dimension", uint(cfg.MaxDimension), "pixels pictures can be wide or high at most, 0 for no limit")
+	flags.Parse(args)
+	cfg.MaxDimension = uint32(*maxDimension)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	left := 0
+	for _, path := range flags.Args() {
+		pics, err := artwork.ValidateFlac(path, cfg, *fix)
+		if err != nil {
+			return err
+		}
+		for i, problems := range pics {
+			for _, problem := range problems {
+				// Oversize and undecodable pictures are only reported, even with -fix
+				fixed := *fix && problem.Kind != artwork.ProblemOversize && problem.Kind != artwork.ProblemUndecodable
+				if !fixed {
+					left += 1
+				}
+				fmt.Printf("%s\tpicture %d\tfixed=%t\t%s\n", path, i+1, fixed, problem)
+			}
+		}
+	}
+	if left > 0 {
+		return fmt.Errorf("found %d problems", left)
+	}
+	return nil
+}
+
+func dupesRun(args []string) error {
+	cfg := dupes.DefaultCfg()
+	flags := flag.NewFlagSet("dupes", flag.ExitOnError)
+	rules := flags.String("rules", "lossless,bitdepth,tags", "rules to pick the file to keep with, in order")
+	flags.DurationVar(&cfg.DurationTolerance, "tolerance", cfg.DurationTolerance, "how much durations of the same songs can differ")
+	flags.Parse(args)
+
+	cfg.Rules = nil
+	for _, name := range strings.Split(*rules, ",") {
+		rule, ok := dupes.RulesByName[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("unknown rule %q", name)
+		}
+		cfg.Rules = append(cfg.Rules, rule)
+	}
+
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	groups, err := dupes.Find(store, cfg)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(groups)
+}
+
+func exportRun(path string) error {
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if path == "" {
+		return archive.Export(store, os.Stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := archive.Export(store, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func importRun(path string) error {
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	input := os.Stdin
+	if path != "" {
+		if input, err = os.Open(path); err != nil {
+			return err
+		}
+		defer input.Close()
+	}
+
+	stats, err := archive.Import(store, input)
+	if err != nil {
+		return err
+	}
+	slog.Info("Imported", "files", stats.Files, "moved", stats.Moved, "albums", stats.Albums)
+	return nil
+}
+
+func backupRun(path string) error {
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.Backup(path)
+}
+
+// Exits with 1 if there are any problems, so that it can be run by cron and such
+func checkRun() error {
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := store.Check()
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range report.Problems {
+		fmt.Printf("%s\t%s\t%s\n", problem.Kind, problem.Name, problem.Msg)
+	}
+	slog.Info("Checked", "files", report.FilesChecked, "hashed", report.FilesHashed, "problems", len(report.Problems))
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("found %d problems", len(report.Problems))
+	}
+	return nil
+}
+
+func gcRun(dir string) error {
+	store, err := storeOpen()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	blobs, err := cas.New(dir, cas.DefaultCfg())
+	if err != nil {
+		return err
+	}
+	stats, err := blobs.Gc(store)
+	if err != nil {
+		return err
+	}
+	slog.Info("Collected garbage", "blobs", stats.Blobs, "removed", stats.Removed, "freed", stats.Freed)
+	return nil
+}
+
+// Libraries shared by many instances live on a PostgreSQL server, given by VOIDH_POSTGRES_DSN
+func storeOpen() (*repo.Repo, error) {
+	if dsn := os.Getenv("VOIDH_POSTGRES_DSN"); dsn != "" {
+		result, err := repo.InitPostgres(repo.PostgresConfig{Dsn: dsn})
+		return &result, err
+	}
+
+	result, err := repo.Init(repo.Config{DatabasePath: "voidh.db"})
+	return &result, err
 }
@@ -0,0 +1,192 @@
+package repo
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+// Returned by [Repo.Backup] for PostgreSQL, which has pg_dump for that
+var BackupUnsupportedErr = fmt.Errorf("backups are not supported for this database, use its own tools")
+
+// How many files [Repo.Check] hashes to see if they are still the same
+const checkSampleSize = 100
+
+type CheckKind string
+
+const (
+	// The database file itself is damaged
+	CheckIntegrity  CheckKind = "integrity"
+	CheckForeignKey CheckKind = "foreign_key"
+	// Indexed file is no longer there
+	CheckMissing CheckKind = "missing"
+	// Indexed file is there, but its contents are not what they were when it was indexed
+	CheckHashMismatch CheckKind = "hash_mismatch"
+)
+
+type CheckProblem struct {
+	Kind CheckKind
+	// File the problem is about, if it's about one
+	Name string
+	Msg  string
+}
+
+type CheckReport struct {
+	Problems []CheckProblem
+	// Files looked up on disk, all of them
+	FilesChecked int
+	// Files hashed, a random sample of the ones that are there
+	FilesHashed int
+}
+
+// Copies the database to a new file at the path, while it's still being used
+func (repo *Repo) Backup(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("can't back up to %s, it already exists", path)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return repo.dialect.backup(repo.db, path)
+}
+
+// Checks the database for damage, and the library for files that have gone or changed
+// since they were indexed. Problems found are reported, not fixed
+func (repo *Repo) Check() (CheckReport, error) {
+	result := CheckReport{Problems: []CheckProblem{}}
+
+	problems, err := repo.dialect.integrityCheck(repo.db)
+	if err != nil {
+		return result, err
+	}
+	result.Problems = append(result.Problems, problems...)
+
+	roots, err := repo.Roots()
+	if err != nil {
+		return result, err
+	}
+
+	// Reservoir sampling, so that every file has the same chance to be hashed
+	sample := []file.FsFile{}
+	for f, err := range repo.List(Query{}) {
+		if err != nil {
+			return result, err
+		}
+
+		result.FilesChecked += 1
+		if _, err := os.Stat(f.Name); errors.Is(err, fs.ErrNotExist) {
+			result.Problems = append(result.Problems, CheckProblem{Kind: CheckMissing, Name: f.Name, Msg: "file is gone"})
+			continue
+		} else if err != nil {
+			return result, err
+		}
+
+		if len(sample) < checkSampleSize {
+			sample = append(sample, f)
+		} else if i := rand.IntN(result.FilesChecked); i < checkSampleSize {
+			sample[i] = f
+		}
+	}
+
+	for _, f := range sample {
+		algo := HashSha1
+		if root, ok := rootOf(roots, f.Name); ok {
+			algo = root.Hash
+		}
+		hash, err := fileHash(f.Name, algo)
+		if err != nil {
+			return result, err
+		}
+		result.FilesHashed += 1
+		if !bytes.Equal(hash, f.Hash) {
+			result.Problems = append(result.Problems, CheckProblem{
+				Kind: CheckHashMismatch,
+				Name: f.Name,
+				Msg:  fmt.Sprintf("hash is %x, indexed as %x", hash, f.Hash),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// Same hash as the one files of the root with the algorithm are indexed with
+func fileHash(path string, algo HashAlgo) ([]byte, error) {
+	hasher, err := algo.Hasher()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(hasher, f); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
+func (_ sqliteDialect) backup(db *sql.DB, path string) error {
+	return sqliteBackup(db, path)
+}
+
+func (_ sqliteDialect) integrityCheck(db *sql.DB) ([]CheckProblem, error) {
+	result := []CheckProblem{}
+
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return result, err
+		}
+		if msg != "ok" {
+			result = append(result, CheckProblem{Kind: CheckIntegrity, Msg: msg})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	fkRows, err := db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return result, err
+	}
+	defer fkRows.Close()
+	for fkRows.Next() {
+		var table, parent string
+		var rowId sql.NullInt64
+		var fkId int64
+		if err := fkRows.Scan(&table, &rowId, &parent, &fkId); err != nil {
+			return result, err
+		}
+		result = append(result, CheckProblem{
+			Kind: CheckForeignKey,
+			Msg:  fmt.Sprintf("row %d of %s refers to a missing row of %s", rowId.Int64, table, parent),
+		})
+	}
+
+	return result, fkRows.Err()
+}
+
+func (_ postgresDialect) backup(_ *sql.DB, _ string) error {
+	return BackupUnsupportedErr
+}
+
+// The server enforces foreign keys at all times and checks its own pages, there's nothing to add
+func (_ postgresDialect) integrityCheck(_ *sql.DB) ([]CheckProblem, error) {
+	return []CheckProblem{}, nil
+}
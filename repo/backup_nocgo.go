@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SQLite itself isn't there without cgo, neither is its backup API
+func sqliteBackup(_ *sql.DB, _ string) error {
+	return fmt.Errorf("%w: built without cgo", BackupUnsupportedErr)
+}
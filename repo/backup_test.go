@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+)
+
+func TestBackup(t *testing.T) {
+	repo := testRepoInit(t)
+	assert.Nil(t, repo.UpsertAudioFile(testAudioFile("/music/1.flac", "One", "1")))
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	assert.Nil(t, repo.Backup(path))
+	assert.NotNil(t, repo.Backup(path))
+
+	backup, err := Init(Config{DatabasePath: path})
+	assert.Nil(t, err)
+	defer backup.Close()
+	assert.Equal(t, []string{"/music/1.flac"}, testFindNames(t, &backup, Query{Where: Eq(Title{}, "One")}))
+}
+
+func TestCheck(t *testing.T) {
+	repo := testRepoInit(t)
+	dir := t.TempDir()
+
+	same := filepath.Join(dir, "same.flac")
+	changed := filepath.Join(dir, "changed.flac")
+	assert.Nil(t, os.WriteFile(same, []byte("same"), 0o644))
+	assert.Nil(t, os.WriteFile(changed, []byte("before"), 0o644))
+	for _, name := range []string{same, changed} {
+		hash, err := fileHash(name, HashSha1)
+		assert.Nil(t, err)
+		assert.Nil(t, repo.Insert(file.FsFile{Name: name, Hash: hash}))
+	}
+	assert.Nil(t, repo.Insert(file.FsFile{Name: filepath.Join(dir, "gone.flac"), Hash: []byte{1}}))
+	assert.Nil(t, os.WriteFile(changed, []byte("after"), 0o644))
+
+	report, err := repo.Check()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, report.FilesChecked)
+	assert.Equal(t, 2, report.FilesHashed)
+	assert.Len(t, report.Problems, 2)
+	assert.Equal(t, CheckMissing, report.Problems[0].Kind)
+	assert.Equal(t, CheckHashMismatch, report.Problems[1].Kind)
+	assert.Equal(t, changed, report.Problems[1].Name)
+}
@@ -0,0 +1,15 @@
+//go:build cgo
+
+package repo
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Waited for as long as the busy timeout, and the lock is still there
+func sqliteBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
@@ -0,0 +1,23 @@
+//go:build cgo
+
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusy(t *testing.T) {
+	busy := sqlite3.Error{Code: sqlite3.ErrBusy}
+	assert.True(t, errors.Is(busyWrap(sqliteDialect{}, fmt.Errorf("commit: %w", busy)), BusyErr))
+	assert.False(t, errors.Is(busyWrap(sqliteDialect{}, sqlite3.Error{Code: sqlite3.ErrConstraint}), BusyErr))
+	assert.Nil(t, busyWrap(sqliteDialect{}, nil))
+
+	assert.True(t, errors.Is(busyWrap(postgresDialect{}, &pq.Error{Code: "40P01"}), BusyErr))
+	assert.False(t, errors.Is(busyWrap(postgresDialect{}, &pq.Error{Code: "23505"}), BusyErr))
+}
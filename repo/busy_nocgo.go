@@ -0,0 +1,8 @@
+//go:build !cgo
+
+package repo
+
+// SQLite itself isn't there without cgo, so it's never busy either
+func sqliteBusy(_ error) bool {
+	return false
+}
@@ -2,76 +2,54 @@ package repo
 
 import (
 	"database/sql"
+	"time"
 
-	_ "embed"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/wetfloo/voidh/file"
 )
 
-//go:embed sql/fs_file/init.sql
-var initQuery string
-
-//go:embed sql/fs_file/drop_existing.sql
-var dropExistingQuery string
-
-type Criteria struct {
-	Key   Key
-	Value any
-}
-
-type Key interface {
-	dbKey() string
-}
-
-type Filename struct{}
-
-func (_ Filename) dbKey() string {
-	return "fs_name"
-}
-
-type Hash struct{}
-
-func (_ Hash) dbKey() string {
-	return "sha1"
-}
-
-// TODO: deleteIfExists will only exist during prototyping and should never be used in prod
-func dbInit(databasePath string, deleteIfExists bool) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", databasePath)
+func dbInit(databasePath string) (*sql.DB, error) {
+	// Virtual tracks and pictures rely on cascading deletes of the files they belong to.
+	// WAL lets readers go on while a batch is being written, and immediate transactions
+	// take the write lock upfront, instead of failing halfway when another writer has it
+	db, err := sql.Open(
+		"sqlite3",
+		databasePath+"?_foreign_keys=on&_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000&_txlock=immediate",
+	)
 	if err != nil {
 		return db, err
 	}
 
-	if deleteIfExists {
-		if _, err := db.Exec(dropExistingQuery); err != nil {
-			return db, err
-		}
-	}
-
-	if _, err := db.Exec(initQuery); err != nil {
-		return db, err
-	}
-
-	return db, nil
+	return db, dbPrepare(db, sqliteDialect{})
 }
 
-func dbInteract(db *sql.DB, query string, args ...any) (sql.Result, error) {
-	tx, err := db.Begin()
+// Brings the schema of a freshly opened database up to date
+func dbPrepare(db *sql.DB, dialect dialect) error {
+	migrations, err := migrationsLoad(dialect)
 	if err != nil {
-		panic(err)
+		return err
 	}
-
-	stmt, err := db.Prepare(query)
-	if err != nil {
-		return nil, err
+	if err := migrate(db, dialect, migrations); err != nil {
+		return err
 	}
-	defer stmt.Close()
+	return dialect.searchInit(db)
+}
 
-	result, err := stmt.Exec(args...)
-	if err != nil {
-		return result, err
-	}
+// Inserts the file or updates the one with the same name, returning its id. Time
+// of addition is kept from the first insert
+const fsFileUpsertQuery = `INSERT INTO fs_file(root_id, fs_name, sha1, size, mtime, added_at) VALUES(?, ?, ?, ?, ?, ?)
+	ON CONFLICT(root_id, fs_name) DO UPDATE SET sha1 = excluded.sha1, size = excluded.size, mtime = excluded.mtime
+	RETURNING id`
 
-	err = tx.Commit()
+// Arguments of [fsFileUpsertQuery], for the file stored under the root with the given name relative to it
+func fsFileUpsertArgs(rootId int64, relName string, file file.FsFile) []any {
+	return []any{rootId, relName, file.Hash, file.Size, timeArg(file.ModTime), time.Now().UnixMilli()}
+}
 
-	return result, err
+// Unknown times are stored as NULLs
+func timeArg(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UnixMilli()
 }
@@ -0,0 +1,130 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Differences between the databases [Repo] can run on. Queries are written
+// with ? placeholders and in the SQL that both databases understand, anything
+// else goes here
+type dialect interface {
+	// Rewrites ? placeholders into the ones the database understands
+	rebind(query string) string
+	// Directory of migrations, relative to the embedded sql directory
+	migrationsDir() string
+	schemaVersion(db *sql.DB) (int, error)
+	// Keeps other clients from applying migrations until the transaction ends, and
+	// returns the version of the schema as of then, which they may have changed
+	migrationLock(tx *sql.Tx) (int, error)
+	schemaVersionSet(tx *sql.Tx, version int) error
+	// Writes the case insensitive LIKE comparison of expr to the pattern
+	likeWrite(w *queryWriter, expr string, pattern any)
+	globWrite(w *queryWriter, expr string, pattern any)
+	// LIMIT value that means no limit at all
+	noLimit() string
+
+	searchInit(db *sql.DB) error
+	searchIndex(tx *sqlTx, trackId int64, albumId *int64) error
+	search(db *sql.DB, terms []searchTerm) ([]SearchHit, error)
+
+	// Copies the database to a new file at the path
+	backup(db *sql.DB, path string) error
+	// Finds damage of the database itself
+	integrityCheck(db *sql.DB) ([]CheckProblem, error)
+	// Whether the error is only because someone else holds a lock, and it's worth trying again
+	busy(err error) bool
+}
+
+// Wraps errors of the database being busy into [BusyErr], leaving the rest as they are
+func busyWrap(d dialect, err error) error {
+	if err != nil && d.busy(err) {
+		return fmt.Errorf("%w: %w", BusyErr, err)
+	}
+	return err
+}
+
+type sqliteDialect struct{}
+
+func (_ sqliteDialect) rebind(query string) string {
+	return query
+}
+
+func (_ sqliteDialect) migrationsDir() string {
+	return "sqlite"
+}
+
+// Version of the schema, as tracked by SQLite itself
+func (_ sqliteDialect) schemaVersion(db *sql.DB) (int, error) {
+	var result int
+	err := db.QueryRow("PRAGMA user_version").Scan(&result)
+	return result, err
+}
+
+// Transactions take the write lock as they begin, there's nothing more to lock
+func (_ sqliteDialect) migrationLock(tx *sql.Tx) (int, error) {
+	var result int
+	err := tx.QueryRow("PRAGMA user_version").Scan(&result)
+	return result, err
+}
+
+func (_ sqliteDialect) schemaVersionSet(tx *sql.Tx, version int) error {
+	// user_version is stored in the database header, which is a part of the transaction as well
+	_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version))
+	return err
+}
+
+func (_ sqliteDialect) likeWrite(w *queryWriter, expr string, pattern any) {
+	w.write(expr + " LIKE ")
+	w.arg(pattern)
+	w.write(` ESCAPE '\'`)
+}
+
+func (_ sqliteDialect) globWrite(w *queryWriter, expr string, pattern any) {
+	w.write(expr + " GLOB ")
+	w.arg(pattern)
+}
+
+func (_ sqliteDialect) noLimit() string {
+	return "-1"
+}
+
+func (_ sqliteDialect) busy(err error) bool {
+	return sqliteBusy(err)
+}
+
+func (_ sqliteDialect) searchInit(db *sql.DB) error {
+	return searchInit(db)
+}
+
+func (_ sqliteDialect) searchIndex(tx *sqlTx, trackId int64, albumId *int64) error {
+	return searchIndex(tx, trackId, albumId)
+}
+
+func (_ sqliteDialect) search(db *sql.DB, terms []searchTerm) ([]SearchHit, error) {
+	return search(db, terms)
+}
+
+// Rewrites ? placeholders into numbered ones, like $1, skipping string literals and quoted identifiers
+func rebindNumbered(query string) string {
+	var result strings.Builder
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n += 1
+			result.WriteString(fmt.Sprintf("$%d", n))
+			continue
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
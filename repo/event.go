@@ -0,0 +1,231 @@
+package repo
+
+import (
+	"bytes"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+type EventKind string
+
+const (
+	EventCreate EventKind = "create"
+	// Contents of the file changed, its hash is different
+	EventModify EventKind = "modify"
+	EventRename EventKind = "rename"
+	EventDelete EventKind = "delete"
+)
+
+// What made the change
+type EventSource string
+
+const (
+	SourceWatcher EventSource = "watcher"
+	SourceScan    EventSource = "scan"
+	SourceImport  EventSource = "import"
+	// Default one, for changes made through the API directly
+	SourceUser EventSource = "user"
+)
+
+// Change made to the library, recorded by every write that changes files. Old
+// name and hash are empty for created files, new ones are empty for deleted files
+type Event struct {
+	At      time.Time
+	Kind    EventKind
+	Source  EventSource
+	OldName string
+	NewName string
+	OldHash []byte
+	NewHash []byte
+}
+
+// Events of the file that has, or last had, the given name, oldest first. Renames are
+// followed both ways, so the history goes on under other names of the file. Other files
+// that had the name before the file was created, or after it was deleted, are left out
+func (repo *Repo) History(path string) ([]Event, error) {
+	return historyFollow(path, repo.events)
+}
+
+// Events of the files of the tracks matching the condition, like the ones by an artist, oldest first
+func (repo *Repo) TrackHistory(where Cond) ([]Event, error) {
+	files, err := repo.Find(Query{Where: where})
+	if err != nil {
+		return nil, err
+	}
+	return historyMerge(files, repo.History)
+}
+
+// Latest events of all files, newest first
+func (repo *Repo) RecentEvents(limit int) ([]Event, error) {
+	rows, err := repo.query(`SELECT at, kind, source, old_name, new_name, old_sha1, new_sha1
+		FROM event ORDER BY at DESC, id DESC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return eventsScan(rows)
+}
+
+// Events involving any of the names, oldest first
+func (repo *Repo) events(names []string) ([]Event, error) {
+	placeholders := strings.Repeat(", ?", len(names))[2:]
+	args := []any{}
+	for range 2 {
+		for _, name := range names {
+			args = append(args, name)
+		}
+	}
+
+	rows, err := repo.query(`SELECT at, kind, source, old_name, new_name, old_sha1, new_sha1
+		FROM event
+		WHERE old_name IN (`+placeholders+`) OR new_name IN (`+placeholders+`)
+		ORDER BY at, id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return eventsScan(rows)
+}
+
+func eventsScan(rows *sql.Rows) ([]Event, error) {
+	defer rows.Close()
+
+	result := []Event{}
+	for rows.Next() {
+		var e Event
+		var at int64
+		var oldName, newName sql.NullString
+		if err := rows.Scan(&at, &e.Kind, &e.Source, &oldName, &newName, &e.OldHash, &e.NewHash); err != nil {
+			return nil, err
+		}
+		e.At = time.UnixMilli(at)
+		e.OldName = oldName.String
+		e.NewName = newName.String
+		result = append(result, e)
+	}
+
+	return result, rows.Err()
+}
+
+// Picks up the names the file had from renames, until there are no new ones
+func historyFollow(path string, events func(names []string) ([]Event, error)) ([]Event, error) {
+	names := []string{path}
+	for {
+		all, err := events(names)
+		if err != nil {
+			return nil, err
+		}
+		result := historyOf(path, all)
+
+		found := len(names)
+		for _, e := range result {
+			for _, name := range []string{e.OldName, e.NewName} {
+				if e.Kind == EventRename && !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+		}
+		if len(names) == found {
+			return result, nil
+		}
+	}
+}
+
+// Events of the file that has, or last had, the name, out of events in order. Files
+// are told apart by following each of them from its creation to its deletion
+func historyOf(path string, events []Event) []Event {
+	// Files by their names, as of the event at hand, and the file of each event
+	live := map[string]int{}
+	files := make([]int, len(events))
+	count := 0
+	latest := -1
+	for i, e := range events {
+		f, ok := live[e.OldName]
+		// Files indexed before there were events have no creation of their own
+		if e.Kind == EventCreate || !ok {
+			count += 1
+			f = count
+		}
+		delete(live, e.OldName)
+		if e.Kind != EventDelete {
+			live[e.NewName] = f
+		}
+		files[i] = f
+		if e.OldName == path || e.NewName == path {
+			latest = f
+		}
+	}
+
+	result := []Event{}
+	for i, e := range events {
+		if files[i] == latest {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Histories of all of the files, merged, oldest first
+func historyMerge(files []file.FsFile, history func(path string) ([]Event, error)) ([]Event, error) {
+	result := []Event{}
+	for _, f := range files {
+		events, err := history(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, events...)
+	}
+	slices.SortStableFunc(result, func(a Event, b Event) int {
+		return a.At.Compare(b.At)
+	})
+	return result, nil
+}
+
+// Tells what happened to a file that was at the old name, nil if nothing did
+func eventOf(oldName string, oldHash []byte, newName string, newHash []byte) *Event {
+	switch {
+	case oldName == "" && newName == "":
+		return nil
+	case oldName == "":
+		return &Event{Kind: EventCreate, NewName: newName, NewHash: newHash}
+	case newName == "":
+		return &Event{Kind: EventDelete, OldName: oldName, OldHash: oldHash}
+	case oldName != newName:
+		return &Event{Kind: EventRename, OldName: oldName, NewName: newName, OldHash: oldHash, NewHash: newHash}
+	case !bytes.Equal(oldHash, newHash):
+		return &Event{Kind: EventModify, OldName: oldName, NewName: newName, OldHash: oldHash, NewHash: newHash}
+	}
+	return nil
+}
+
+// Records what happened to a file in the transaction, if anything
+func (tx *sqlTx) eventLog(oldName string, oldHash []byte, newName string, newHash []byte) error {
+	e := eventOf(oldName, oldHash, newName, newHash)
+	if e == nil {
+		return nil
+	}
+
+	nullable := func(s string) any {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+	_, err := tx.Exec(
+		`INSERT INTO event(at, kind, source, old_name, new_name, old_sha1, new_sha1) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().UnixMilli(),
+		e.Kind,
+		tx.source,
+		nullable(e.OldName),
+		nullable(e.NewName),
+		e.OldHash,
+		e.NewHash,
+	)
+	return err
+}
@@ -0,0 +1,101 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+)
+
+func TestHistory(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/music/a.flac", Hash: []byte{1}}))
+			// Same contents, nothing to record
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/music/a.flac", Hash: []byte{1}}))
+			assert.Nil(t, store.BatchFrom(SourceWatcher, func(tx Tx) error {
+				if err := tx.UpsertAudioFile(file.AudioFile{FsFile: file.FsFile{Name: "/music/a.flac", Hash: []byte{2}}}); err != nil {
+					return err
+				}
+				return tx.Update(Eq(Filename{}, "/music/a.flac"), file.FsFile{Name: "/music/b.flac", Hash: []byte{2}})
+			}))
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/music/other.flac", Hash: []byte{3}}))
+			assert.Nil(t, store.Delete(Eq(Filename{}, "/music/b.flac")))
+
+			events, err := store.History("/music/a.flac")
+			assert.Nil(t, err)
+			kinds := []EventKind{}
+			for _, e := range events {
+				kinds = append(kinds, e.Kind)
+			}
+			assert.Equal(t, []EventKind{EventCreate, EventModify, EventRename, EventDelete}, kinds)
+
+			assert.Equal(t, SourceUser, events[0].Source)
+			assert.Equal(t, SourceWatcher, events[1].Source)
+			assert.Equal(t, []byte{1}, events[1].OldHash)
+			assert.Equal(t, "/music/b.flac", events[2].NewName)
+			assert.Equal(t, Event{
+				At:      events[3].At,
+				Kind:    EventDelete,
+				Source:  SourceUser,
+				OldName: "/music/b.flac",
+				OldHash: []byte{2},
+			}, events[3])
+
+			recent, err := store.RecentEvents(2)
+			assert.Nil(t, err)
+			assert.Len(t, recent, 2)
+			assert.Equal(t, EventDelete, recent[0].Kind)
+			assert.Equal(t, "/music/other.flac", recent[1].NewName)
+		})
+	}
+}
+
+func testEventKinds(events []Event) []EventKind {
+	result := []EventKind{}
+	for _, e := range events {
+		result = append(result, e.Kind)
+	}
+	return result
+}
+
+func TestHistoryBoundaries(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, store.UpsertAudioFile(testAudioFile("/music/a.flac", "One", "1")))
+			assert.Nil(t, store.Update(Eq(Filename{}, "/music/a.flac"), file.FsFile{Name: "/music/b.flac", Hash: []byte{1}}))
+			assert.Nil(t, store.Delete(Eq(Filename{}, "/music/b.flac")))
+			// Different files that took the names of the one gone
+			assert.Nil(t, store.UpsertAudioFile(testAudioFile("/music/a.flac", "Two", "2")))
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/music/b.flac", Hash: []byte{2}}))
+
+			events, err := store.History("/music/a.flac")
+			assert.Nil(t, err)
+			assert.Equal(t, []EventKind{EventCreate}, testEventKinds(events))
+			assert.Equal(t, []byte("/music/a.flac"), events[0].NewHash)
+
+			events, err = store.History("/music/b.flac")
+			assert.Nil(t, err)
+			assert.Equal(t, []EventKind{EventCreate}, testEventKinds(events))
+			assert.Equal(t, []byte{2}, events[0].NewHash)
+
+			// Found by its track, which is still there
+			events, err = store.TrackHistory(Eq(Title{}, "Two"))
+			assert.Nil(t, err)
+			assert.Equal(t, []EventKind{EventCreate}, testEventKinds(events))
+			assert.Nil(t, store.Update(Eq(Filename{}, "/music/a.flac"), file.FsFile{Name: "/music/c.flac", Hash: []byte{3}}))
+			events, err = store.TrackHistory(Eq(Tag{Name: file.TagArtist}, "First"))
+			assert.Nil(t, err)
+			assert.Equal(t, []EventKind{EventCreate, EventRename}, testEventKinds(events))
+			assert.Equal(t, "/music/c.flac", events[1].NewName)
+		})
+	}
+}
+
+func TestEventAppendOnly(t *testing.T) {
+	repo := testRepoInit(t)
+	assert.Nil(t, repo.Insert(file.FsFile{Name: "/music/a.flac", Hash: []byte{1}}))
+
+	_, err := repo.db.Exec("DELETE FROM event")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,228 @@
+package repo
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+// Credit roles of artists on a track, and tags they come from
+var trackArtistRoles = []struct {
+	role   string
+	tagKey string
+}{
+	{"artist", file.TagArtist},
+	{"composer", file.TagComposer},
+	{"conductor", file.TagConductor},
+}
+
+// Inserts or updates the audio file along with its tags, album, artist credits
+// and technical properties, all in one transaction
+func (repo *Repo) UpsertAudioFile(af file.AudioFile) error {
+	return repo.Batch(func(tx Tx) error {
+		return tx.UpsertAudioFile(af)
+	})
+}
+
+func (tx *sqlTx) UpsertAudioFile(af file.AudioFile) error {
+	_, err := audioFileUpsert(tx, af)
+	return err
+}
+
+// Returns the id of the track
+func audioFileUpsert(tx *sqlTx, af file.AudioFile) (int64, error) {
+	fsFileId, err := tx.fsFileUpsert(af.FsFile)
+	if err != nil {
+		return 0, err
+	}
+
+	albumId, err := albumUpsert(tx, af)
+	if err != nil {
+		return 0, err
+	}
+
+	title, _ := af.TagValue(file.TagTitle)
+	date, _ := af.TagValue(file.TagDate)
+	var trackId int64
+	if err := tx.QueryRow(
+		`INSERT INTO track(fs_file_id, album_id, title, track_num, disc_num, date, audio_hash)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(fs_file_id) DO UPDATE SET
+			album_id = excluded.album_id,
+			title = excluded.title,
+			track_num = excluded.track_num,
+			disc_num = excluded.disc_num,
+			date = excluded.date,
+			audio_hash = excluded.audio_hash
+		RETURNING id`,
+		fsFileId,
+		albumId,
+		title,
+		tagNumber(af, file.TagTrackNumber),
+		tagNumber(af, file.TagDiscNumber),
+		date,
+		af.AudioStreamHash,
+	).Scan(&trackId); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM tag WHERE track_id = ?", trackId); err != nil {
+		return 0, err
+	}
+	for i, tag := range af.Tags {
+		if _, err := tx.Exec(
+			"INSERT INTO tag(track_id, key, value, position) VALUES(?, ?, ?, ?)",
+			trackId,
+			tag.Key,
+			tag.Value,
+			i,
+		); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM track_artist WHERE track_id = ?", trackId); err != nil {
+		return 0, err
+	}
+	for _, credit := range trackArtistRoles {
+		for i, name := range af.TagValues(credit.tagKey) {
+			artistId, err := artistUpsert(tx, name)
+			if err != nil {
+				return 0, err
+			}
+			if _, err := tx.Exec(
+				`INSERT INTO track_artist(track_id, artist_id, role, position) VALUES(?, ?, ?, ?)
+				ON CONFLICT DO NOTHING`,
+				trackId,
+				artistId,
+				credit.role,
+				i,
+			); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	props := af.Properties
+	if _, err := tx.Exec(
+		`INSERT INTO track_property(
+			track_id, codec, sample_rate, channels, bits_per_sample, samples_total, duration_ms, bitrate
+		) VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(track_id) DO UPDATE SET
+			codec = excluded.codec,
+			sample_rate = excluded.sample_rate,
+			channels = excluded.channels,
+			bits_per_sample = excluded.bits_per_sample,
+			samples_total = excluded.samples_total,
+			duration_ms = excluded.duration_ms,
+			bitrate = excluded.bitrate`,
+		trackId,
+		props.Codec,
+		props.SampleRate,
+		props.Channels,
+		props.BitsPerSample,
+		props.SamplesTotal,
+		props.DurationMs(),
+		props.Bitrate,
+	); err != nil {
+		return 0, err
+	}
+
+	if err := tx.dialect.searchIndex(tx, trackId, albumId); err != nil {
+		return 0, err
+	}
+
+	return trackId, nil
+}
+
+// Returns nil id for files without an album tag
+func albumUpsert(tx *sqlTx, af file.AudioFile) (*int64, error) {
+	title, ok := af.TagValue(file.TagAlbum)
+	if !ok || strings.TrimSpace(title) == "" {
+		return nil, nil
+	}
+
+	// Album artists default to the track ones, that's what most players do
+	artists := af.TagValues(file.TagAlbumArtist)
+	if len(artists) == 0 {
+		artists = af.TagValues(file.TagArtist)
+	}
+	date, _ := af.TagValue(file.TagDate)
+	mbAlbumId, _ := af.TagValue(file.TagMbAlbumId)
+
+	var albumId int64
+	found := false
+	if mbAlbumId != "" {
+		err := tx.QueryRow("SELECT id FROM album WHERE mb_album_id = ?", mbAlbumId).Scan(&albumId)
+		switch err {
+		case nil:
+			found = true
+		case sql.ErrNoRows:
+		default:
+			return nil, err
+		}
+	}
+
+	if !found {
+		// Tracks of the same album don't always agree on the date, the first non-empty one wins
+		if err := tx.QueryRow(
+			`INSERT INTO album(title, artist_credit, date, mb_album_id) VALUES(?, ?, ?, ?)
+			ON CONFLICT(title, artist_credit) DO UPDATE SET
+				date = CASE WHEN album.date = '' THEN excluded.date ELSE album.date END,
+				mb_album_id = CASE WHEN album.mb_album_id = '' THEN excluded.mb_album_id ELSE album.mb_album_id END
+			RETURNING id`,
+			title,
+			strings.Join(artists, "; "),
+			date,
+			mbAlbumId,
+		).Scan(&albumId); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM album_artist WHERE album_id = ?", albumId); err != nil {
+		return nil, err
+	}
+	for i, name := range artists {
+		artistId, err := artistUpsert(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO album_artist(album_id, artist_id, position) VALUES(?, ?, ?) ON CONFLICT DO NOTHING",
+			albumId,
+			artistId,
+			i,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	return &albumId, nil
+}
+
+func artistUpsert(tx *sqlTx, name string) (int64, error) {
+	var id int64
+	err := tx.QueryRow(
+		// Updating to the same value is a no-op, but it makes RETURNING work for existing rows
+		"INSERT INTO artist(name) VALUES(?) ON CONFLICT(name) DO UPDATE SET name = excluded.name RETURNING id",
+		name,
+	).Scan(&id)
+	return id, err
+}
+
+// Parses numeric tags like track numbers, which can come in "3/12" form. Returns nil if there's no valid number
+func tagNumber(af file.AudioFile, key string) *int64 {
+	value, ok := af.TagValue(key)
+	if !ok {
+		return nil
+	}
+	num, _, _ := strings.Cut(value, "/")
+	result, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &result
+}
@@ -0,0 +1,69 @@
+package repo
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+)
+
+func testRepoInit(t *testing.T) Repo {
+	repo, err := Init(Config{DatabasePath: filepath.Join(t.TempDir(), "test.db")})
+	assert.Nil(t, err)
+	t.Cleanup(repo.Close)
+	return repo
+}
+
+func testAudioFile(name string, title string, trackNum string) file.AudioFile {
+	return file.AudioFile{
+		FsFile: file.FsFile{Name: name, Hash: []byte(name)},
+		Tags: []file.Tag{
+			{Key: file.TagTitle, Value: title},
+			{Key: file.TagArtist, Value: "First"},
+			{Key: file.TagArtist, Value: "Second"},
+			{Key: file.TagAlbum, Value: "Album"},
+			{Key: file.TagAlbumArtist, Value: "First"},
+			{Key: file.TagTrackNumber, Value: trackNum},
+		},
+		Properties: file.AudioProperties{Codec: "flac", SampleRate: 44100, SamplesTotal: 44100 * 3},
+	}
+}
+
+func TestUpsertAudioFile(t *testing.T) {
+	repo := testRepoInit(t)
+
+	assert.Nil(t, repo.UpsertAudioFile(testAudioFile("/music/1.flac", "One", "1/2")))
+	assert.Nil(t, repo.UpsertAudioFile(testAudioFile("/music/2.flac", "Two", "2/2")))
+
+	var albums, artists, credits int
+	assert.Nil(t, repo.db.QueryRow("SELECT COUNT(*) FROM album").Scan(&albums))
+	assert.Nil(t, repo.db.QueryRow("SELECT COUNT(*) FROM artist").Scan(&artists))
+	assert.Nil(t, repo.db.QueryRow("SELECT COUNT(*) FROM track_artist").Scan(&credits))
+	assert.Equal(t, 1, albums)
+	assert.Equal(t, 2, artists)
+	assert.Equal(t, 4, credits)
+
+	var trackNum, durationMs int
+	assert.Nil(t, repo.db.QueryRow(`SELECT t.track_num, p.duration_ms FROM track t
+		JOIN fs_file f ON f.id = t.fs_file_id
+		JOIN track_property p ON p.track_id = t.id
+		WHERE f.fs_name = ?`, "/music/2.flac").Scan(&trackNum, &durationMs))
+	assert.Equal(t, 2, trackNum)
+	assert.Equal(t, 3000, durationMs)
+}
+
+func TestUpsertAudioFileReplacesTags(t *testing.T) {
+	repo := testRepoInit(t)
+
+	assert.Nil(t, repo.UpsertAudioFile(testAudioFile("/music/1.flac", "One", "1")))
+	assert.Nil(t, repo.UpsertAudioFile(testAudioFile("/music/1.flac", "Uno", "1")))
+
+	var tracks, tags int
+	var title string
+	assert.Nil(t, repo.db.QueryRow("SELECT COUNT(*), MAX(title) FROM track").Scan(&tracks, &title))
+	assert.Nil(t, repo.db.QueryRow("SELECT COUNT(*) FROM tag").Scan(&tags))
+	assert.Equal(t, 1, tracks)
+	assert.Equal(t, "Uno", title)
+	assert.Equal(t, 6, tags)
+}
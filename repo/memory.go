@@ -0,0 +1,870 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"iter"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+// Library kept in memory only, mostly for tests. Queries behave the same as with
+// SQL databases, NULLs included, but search doesn't rank as well
+type Memory struct {
+	mu            sync.Mutex
+	files         map[string]*memFile
+	albumPictures map[string][]file.PictureLink
+	// Insertion counter, keeps the default order stable
+	seq    uint64
+	events []Event
+	roots  []Root
+	// Files the watcher has given up on
+	quarantine []Quarantined
+	// What writes are recorded as made by
+	source EventSource
+}
+
+type memFile struct {
+	fsFile  file.FsFile
+	addedAt time.Time
+	seq     uint64
+	// Nil for files that are not tracks
+	audio         *file.AudioFile
+	virtualTracks []file.VirtualTrack
+	pictures      []file.PictureLink
+}
+
+// Copy of everything known about the file, safe to hand out
+func (rec *memFile) audioFile() file.AudioFile {
+	if rec.audio == nil {
+		return file.AudioFile{FsFile: rec.fsFile}
+	}
+	result := *rec.audio
+	// Same as with SQL stores, tracks always have tags, even if there are none
+	result.Tags = append([]file.Tag{}, result.Tags...)
+	return result
+}
+
+// Result of a condition, which can be unknown when NULLs are involved, same as in SQL
+type memTruth int8
+
+const (
+	memFalse memTruth = iota
+	memTrue
+	memUnknown
+)
+
+func NewMemory() *Memory {
+	return &Memory{
+		files:         map[string]*memFile{},
+		albumPictures: map[string][]file.PictureLink{},
+		source:        SourceUser,
+	}
+}
+
+func (mem *Memory) Close() {}
+
+func (mem *Memory) Insert(f file.FsFile) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	mem.fsFileUpsert(f)
+	return nil
+}
+
+func (mem *Memory) Update(where Cond, f file.FsFile) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	matched := mem.match(where)
+	if existing, ok := mem.files[f.Name]; ok && !slices.Contains(matched, existing) {
+		return fmt.Errorf("can't rename to %s, it's already in the library", f.Name)
+	}
+	if len(matched) > 1 {
+		return fmt.Errorf("can't rename %d files to the same name %s", len(matched), f.Name)
+	}
+
+	for _, rec := range matched {
+		delete(mem.files, rec.fsFile.Name)
+		mem.eventLog(rec.fsFile.Name, rec.fsFile.Hash, f.Name, f.Hash)
+		rec.fsFile = f
+		if rec.audio != nil {
+			rec.audio.FsFile = f
+		}
+		for i := range rec.virtualTracks {
+			rec.virtualTracks[i].Source = f.Name
+		}
+		mem.files[f.Name] = rec
+	}
+
+	return nil
+}
+
+func (mem *Memory) Delete(where Cond) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for _, rec := range mem.match(where) {
+		delete(mem.files, rec.fsFile.Name)
+		mem.eventLog(rec.fsFile.Name, rec.fsFile.Hash, "", nil)
+	}
+	return nil
+}
+
+// Runs fn against a copy of the library, which replaces the original only if fn succeeds
+func (mem *Memory) Batch(fn func(tx Tx) error) error {
+	return mem.BatchFrom(SourceUser, fn)
+}
+
+func (mem *Memory) BatchFrom(source EventSource, fn func(tx Tx) error) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	copied := mem.clone()
+	copied.source = source
+	if err := fn(copied); err != nil {
+		return err
+	}
+
+	mem.files = copied.files
+	mem.albumPictures = copied.albumPictures
+	mem.seq = copied.seq
+	mem.events = copied.events
+	return nil
+}
+
+func (mem *Memory) History(path string) ([]Event, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	return historyFollow(path, func(names []string) ([]Event, error) {
+		result := []Event{}
+		for _, e := range mem.events {
+			if slices.Contains(names, e.OldName) || slices.Contains(names, e.NewName) {
+				result = append(result, e)
+			}
+		}
+		return result, nil
+	})
+}
+
+func (mem *Memory) TrackHistory(where Cond) ([]Event, error) {
+	files, err := mem.Find(Query{Where: where})
+	if err != nil {
+		return nil, err
+	}
+	return historyMerge(files, mem.History)
+}
+
+func (mem *Memory) RecentEvents(limit int) ([]Event, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	result := []Event{}
+	for i := len(mem.events) - 1; i >= 0 && len(result) < limit; i -= 1 {
+		result = append(result, mem.events[i])
+	}
+	return result, nil
+}
+
+func (mem *Memory) Find(q Query) ([]file.FsFile, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	result := []file.FsFile{}
+	for _, rec := range mem.found(q) {
+		result = append(result, rec.fsFile)
+	}
+	return result, nil
+}
+
+func (mem *Memory) GetByPath(path string) (file.AudioFile, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	rec, err := mem.get(path)
+	if err != nil {
+		return file.AudioFile{}, err
+	}
+	return rec.audioFile(), nil
+}
+
+func (mem *Memory) FindAudio(q Query) ([]file.AudioFile, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	result := []file.AudioFile{}
+	for _, rec := range mem.found(q) {
+		result = append(result, rec.audioFile())
+	}
+	return result, nil
+}
+
+func (mem *Memory) GetByHash(hash []byte) ([]file.FsFile, error) {
+	return mem.Find(Query{Where: Eq(Hash{}, hash), Order: []Order{{Key: Filename{}}}})
+}
+
+func (mem *Memory) Count(where Cond) (int, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	return len(mem.match(where)), nil
+}
+
+func (mem *Memory) ListPage(q Query, after Cursor) (Page, error) {
+	return listPage(q, after, mem.listRows)
+}
+
+func (mem *Memory) List(q Query) iter.Seq2[file.FsFile, error] {
+	return listIter(mem, q)
+}
+
+func (mem *Memory) listRows(q Query) ([]listRow, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	result := []listRow{}
+	for _, rec := range mem.found(q) {
+		row := listRow{fsFile: rec.fsFile}
+		for _, order := range q.Order {
+			row.keys = append(row.keys, memFirst(order.Key.memValues(rec)))
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+func (mem *Memory) UpsertAudioFile(af file.AudioFile) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	rec := mem.fsFileUpsert(af.FsFile)
+	af.Tags = slices.Clone(af.Tags)
+	rec.audio = &af
+	return nil
+}
+
+// Matches words as prefixes, with diacritics folded, but without any stemming
+func (mem *Memory) Search(query string) ([]SearchHit, error) {
+	result := []SearchHit{}
+
+	terms, err := searchTermsParse(query)
+	if err != nil || len(terms) == 0 {
+		return result, err
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	weights := map[string]float64{"title": 10, "artist": 8, "album": 5, "tags": 1}
+	for _, rec := range mem.ordered() {
+		if rec.audio == nil {
+			continue
+		}
+
+		columns := memSearchColumns(*rec.audio)
+		rank := 0.0
+		matches := true
+		for _, term := range terms {
+			termWords := searchWords(term.text)
+			found := false
+			for column, words := range columns {
+				if (term.column == "" || term.column == column) && memWordsMatch(words, termWords) {
+					found = true
+					rank -= weights[column]
+				}
+			}
+			if !found {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			title, _ := rec.audio.TagValue(file.TagTitle)
+			result = append(result, SearchHit{Name: rec.fsFile.Name, Title: title, Rank: rank})
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Rank < result[j].Rank
+	})
+	return result, nil
+}
+
+func (mem *Memory) ReplaceVirtualTracks(source string, tracks []file.VirtualTrack) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	rec, err := mem.get(source)
+	if err != nil {
+		return err
+	}
+
+	rec.virtualTracks = slices.Clone(tracks)
+	sort.SliceStable(rec.virtualTracks, func(i, j int) bool {
+		return rec.virtualTracks[i].TrackNum < rec.virtualTracks[j].TrackNum
+	})
+	return nil
+}
+
+func (mem *Memory) VirtualTracks(source string) ([]file.VirtualTrack, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	result := []file.VirtualTrack{}
+	if rec, ok := mem.files[source]; ok {
+		result = append(result, rec.virtualTracks...)
+	}
+	return result, nil
+}
+
+func (mem *Memory) ReplaceFilePictures(fsName string, pics []file.PictureLink) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	rec, err := mem.get(fsName)
+	if err != nil {
+		return err
+	}
+
+	rec.pictures = memPicturesSorted(pics)
+	return nil
+}
+
+func (mem *Memory) ReplaceAlbumPictures(albumDir string, pics []file.PictureLink) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	mem.albumPictures[albumDir] = memPicturesSorted(pics)
+	return nil
+}
+
+func (mem *Memory) MoveAlbumPictures(oldDir string, dir string) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for d := range mem.albumPictures {
+		if d == dir || strings.HasPrefix(d, rootPrefix(dir)) {
+			delete(mem.albumPictures, d)
+		}
+	}
+	moved := map[string][]file.PictureLink{}
+	for d, pics := range mem.albumPictures {
+		if d == oldDir || strings.HasPrefix(d, rootPrefix(oldDir)) {
+			delete(mem.albumPictures, d)
+			moved[dir+strings.TrimPrefix(d, oldDir)] = pics
+		}
+	}
+	maps.Copy(mem.albumPictures, moved)
+	return nil
+}
+
+func (mem *Memory) FilePictures(fsName string) ([]file.PictureLink, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	result := []file.PictureLink{}
+	if rec, ok := mem.files[fsName]; ok {
+		result = append(result, rec.pictures...)
+	}
+	return result, nil
+}
+
+func (mem *Memory) AlbumPictures(albumDir string) ([]file.PictureLink, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	return append([]file.PictureLink{}, mem.albumPictures[albumDir]...), nil
+}
+
+// Must be called with the lock held
+func (mem *Memory) clone() *Memory {
+	result := NewMemory()
+	result.seq = mem.seq
+	result.events = slices.Clone(mem.events)
+	for name, rec := range mem.files {
+		copied := *rec
+		if rec.audio != nil {
+			audio := *rec.audio
+			audio.Tags = slices.Clone(audio.Tags)
+			copied.audio = &audio
+		}
+		copied.virtualTracks = slices.Clone(rec.virtualTracks)
+		copied.pictures = slices.Clone(rec.pictures)
+		result.files[name] = &copied
+	}
+	for dir, pics := range mem.albumPictures {
+		result.albumPictures[dir] = slices.Clone(pics)
+	}
+	return result
+}
+
+// Must be called with the lock held
+func (mem *Memory) fsFileUpsert(f file.FsFile) *memFile {
+	if rec, ok := mem.files[f.Name]; ok {
+		mem.eventLog(rec.fsFile.Name, rec.fsFile.Hash, f.Name, f.Hash)
+		rec.fsFile = f
+		return rec
+	}
+
+	mem.eventLog("", nil, f.Name, f.Hash)
+	mem.seq += 1
+	rec := &memFile{fsFile: f, addedAt: time.Now(), seq: mem.seq}
+	mem.files[f.Name] = rec
+	return rec
+}
+
+// Must be called with the lock held
+func (mem *Memory) eventLog(oldName string, oldHash []byte, newName string, newHash []byte) {
+	if e := eventOf(oldName, oldHash, newName, newHash); e != nil {
+		e.At = time.Now()
+		e.Source = mem.source
+		mem.events = append(mem.events, *e)
+	}
+}
+
+// Must be called with the lock held. Fails with [NotFoundErr] if there's no such file
+func (mem *Memory) get(name string) (*memFile, error) {
+	rec, ok := mem.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, NotFoundErr)
+	}
+	return rec, nil
+}
+
+// Must be called with the lock held
+func (mem *Memory) ordered() []*memFile {
+	result := make([]*memFile, 0, len(mem.files))
+	for _, rec := range mem.files {
+		result = append(result, rec)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].seq < result[j].seq
+	})
+	return result
+}
+
+// Must be called with the lock held
+func (mem *Memory) found(q Query) []*memFile {
+	matched := mem.match(q.Where)
+	sort.SliceStable(matched, func(i, j int) bool {
+		for _, order := range q.Order {
+			c := memOrderCompare(memFirst(order.Key.memValues(matched[i])), memFirst(order.Key.memValues(matched[j])))
+			if order.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	if q.Offset >= len(matched) {
+		matched = nil
+	} else {
+		matched = matched[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(matched) {
+		matched = matched[:q.Limit]
+	}
+	return matched
+}
+
+// Must be called with the lock held
+func (mem *Memory) match(where Cond) []*memFile {
+	result := []*memFile{}
+	for _, rec := range mem.ordered() {
+		if where == nil || where.condMatch(rec) == memTrue {
+			result = append(result, rec)
+		}
+	}
+	return result
+}
+
+func memPicturesSorted(pics []file.PictureLink) []file.PictureLink {
+	result := slices.Clone(pics)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].PicType < result[j].PicType
+	})
+	return result
+}
+
+func memSearchColumns(af file.AudioFile) map[string][]string {
+	words := func(keys ...string) []string {
+		result := []string{}
+		for _, key := range keys {
+			for _, value := range af.TagValues(key) {
+				result = append(result, searchWords(value)...)
+			}
+		}
+		return result
+	}
+
+	tags := []string{}
+	for _, tag := range af.Tags {
+		tags = append(tags, searchWords(tag.Value)...)
+	}
+
+	return map[string][]string{
+		"title":  words(file.TagTitle),
+		"artist": words(file.TagArtist, file.TagAlbumArtist, file.TagComposer, file.TagConductor),
+		"album":  words(file.TagAlbum),
+		"tags":   tags,
+	}
+}
+
+// Whether the term words follow each other in the text, each matching as a prefix
+func memWordsMatch(words []string, termWords []string) bool {
+	if len(termWords) == 0 {
+		return false
+	}
+	for i := 0; i+len(termWords) <= len(words); i += 1 {
+		found := true
+		for j, termWord := range termWords {
+			if !strings.HasPrefix(words[i+j], termWord) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func (cond condAll) condMatch(rec *memFile) memTruth {
+	result := memFalse
+	combine := memOr
+	if cond.op == "AND" {
+		result, combine = memTrue, memAnd
+	}
+
+	for _, c := range cond.conds {
+		result = combine(result, c.condMatch(rec))
+	}
+	return result
+}
+
+func (cond condUnder) condMatch(rec *memFile) memTruth {
+	return cond.byName().condMatch(rec)
+}
+
+func (cond condNot) condMatch(rec *memFile) memTruth {
+	switch cond.cond.condMatch(rec) {
+	case memTrue:
+		return memFalse
+	case memFalse:
+		return memTrue
+	}
+	return memUnknown
+}
+
+func (cond condCompare) condMatch(rec *memFile) memTruth {
+	values := cond.key.memValues(rec)
+	if cond.op == "IS NULL" {
+		if len(values) == 0 {
+			return memTrue
+		}
+		return memFalse
+	}
+
+	_, multi := cond.key.(multiKey)
+	if len(values) == 0 {
+		// EXISTS over no rows is plain false
+		if multi {
+			return memFalse
+		}
+		return memUnknown
+	}
+
+	result := memFalse
+	for _, v := range values {
+		switch cond.valueMatch(v) {
+		case memTrue:
+			return memTrue
+		case memUnknown:
+			result = memUnknown
+		}
+	}
+	return result
+}
+
+func (cond condCompare) valueMatch(v any) memTruth {
+	compare := func(other any, ok func(c int) bool) memTruth {
+		c, comparable := memCompare(v, memNormalize(other))
+		switch {
+		case !comparable:
+			return memUnknown
+		case ok(c):
+			return memTrue
+		}
+		return memFalse
+	}
+
+	switch cond.op {
+	case "=":
+		return compare(cond.values[0], func(c int) bool { return c == 0 })
+	case "!=":
+		return compare(cond.values[0], func(c int) bool { return c != 0 })
+	case "<":
+		return compare(cond.values[0], func(c int) bool { return c < 0 })
+	case "<=":
+		return compare(cond.values[0], func(c int) bool { return c <= 0 })
+	case ">":
+		return compare(cond.values[0], func(c int) bool { return c > 0 })
+	case ">=":
+		return compare(cond.values[0], func(c int) bool { return c >= 0 })
+	case "BETWEEN":
+		low := compare(cond.values[0], func(c int) bool { return c >= 0 })
+		high := compare(cond.values[1], func(c int) bool { return c <= 0 })
+		return memAnd(low, high)
+	case "IN":
+		result := memFalse
+		for _, other := range cond.values {
+			switch compare(other, func(c int) bool { return c == 0 }) {
+			case memTrue:
+				return memTrue
+			case memUnknown:
+				result = memUnknown
+			}
+		}
+		return result
+	case "LIKE", "GLOB":
+		s, ok := v.(string)
+		pattern, patternOk := cond.values[0].(string)
+		if !ok || !patternOk {
+			return memUnknown
+		}
+		var re string
+		if cond.op == "LIKE" {
+			re = likeRegexp(pattern)
+		} else {
+			re = globRegexp(pattern)
+		}
+		if regexp.MustCompile(re).MatchString(s) {
+			return memTrue
+		}
+		return memFalse
+	}
+
+	panic(fmt.Sprintf("unknown operator %s", cond.op))
+}
+
+func memAnd(a memTruth, b memTruth) memTruth {
+	switch {
+	case a == memFalse || b == memFalse:
+		return memFalse
+	case a == memUnknown || b == memUnknown:
+		return memUnknown
+	}
+	return memTrue
+}
+
+func memOr(a memTruth, b memTruth) memTruth {
+	switch {
+	case a == memTrue || b == memTrue:
+		return memTrue
+	case a == memUnknown || b == memUnknown:
+		return memUnknown
+	}
+	return memFalse
+}
+
+func (_ Filename) memValues(rec *memFile) []any { return []any{rec.fsFile.Name} }
+func (_ Hash) memValues(rec *memFile) []any     { return []any{rec.fsFile.Hash} }
+func (_ Size) memValues(rec *memFile) []any     { return []any{rec.fsFile.Size} }
+
+func (_ ModTime) memValues(rec *memFile) []any {
+	if rec.fsFile.ModTime.IsZero() {
+		return nil
+	}
+	return []any{rec.fsFile.ModTime.UnixMilli()}
+}
+
+func (_ AddedAt) memValues(rec *memFile) []any { return []any{rec.addedAt.UnixMilli()} }
+
+func (_ Title) memValues(rec *memFile) []any { return memTagFirst(rec, file.TagTitle) }
+func (_ Date) memValues(rec *memFile) []any  { return memTagFirst(rec, file.TagDate) }
+
+func (_ TrackNum) memValues(rec *memFile) []any { return memTagNumber(rec, file.TagTrackNumber) }
+func (_ DiscNum) memValues(rec *memFile) []any  { return memTagNumber(rec, file.TagDiscNumber) }
+
+func (_ AlbumTitle) memValues(rec *memFile) []any {
+	if rec.audio == nil {
+		return nil
+	}
+	title, ok := rec.audio.TagValue(file.TagAlbum)
+	if !ok || strings.TrimSpace(title) == "" {
+		return nil
+	}
+	return []any{title}
+}
+
+func (_ Codec) memValues(rec *memFile) []any {
+	return memProperty(rec, func(p file.AudioProperties) any { return p.Codec })
+}
+
+func (_ SampleRate) memValues(rec *memFile) []any {
+	return memProperty(rec, func(p file.AudioProperties) any { return p.SampleRate })
+}
+
+func (_ Channels) memValues(rec *memFile) []any {
+	return memProperty(rec, func(p file.AudioProperties) any { return p.Channels })
+}
+
+func (_ BitsPerSample) memValues(rec *memFile) []any {
+	return memProperty(rec, func(p file.AudioProperties) any { return p.BitsPerSample })
+}
+
+func (_ DurationMs) memValues(rec *memFile) []any {
+	return memProperty(rec, func(p file.AudioProperties) any { return p.DurationMs() })
+}
+
+func (_ Bitrate) memValues(rec *memFile) []any {
+	return memProperty(rec, func(p file.AudioProperties) any { return p.Bitrate })
+}
+
+func (key Tag) memValues(rec *memFile) []any {
+	if rec.audio == nil {
+		return nil
+	}
+	result := []any{}
+	for _, value := range rec.audio.TagValues(key.Name) {
+		result = append(result, value)
+	}
+	return result
+}
+
+// Tracks always have these, even if empty, other files don't
+func memTagFirst(rec *memFile, key string) []any {
+	if rec.audio == nil {
+		return nil
+	}
+	value, _ := rec.audio.TagValue(key)
+	return []any{value}
+}
+
+func memTagNumber(rec *memFile, key string) []any {
+	if rec.audio == nil {
+		return nil
+	}
+	if num := tagNumber(*rec.audio, key); num != nil {
+		return []any{*num}
+	}
+	return nil
+}
+
+func memProperty(rec *memFile, get func(p file.AudioProperties) any) []any {
+	if rec.audio == nil {
+		return nil
+	}
+	return []any{memNormalize(get(rec.audio.Properties))}
+}
+
+func memFirst(values []any) any {
+	if len(values) == 0 {
+		return nil
+	}
+	return memNormalize(values[0])
+}
+
+// Brings values to the few types they are stored as: int64, float64, string and []byte
+func memNormalize(v any) any {
+	switch v := argValue(v).(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return memUint(uint64(v))
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return memUint(v)
+	case float32:
+		return float64(v)
+	default:
+		return v
+	}
+}
+
+func memUint(v uint64) any {
+	if v > math.MaxInt64 {
+		return float64(v)
+	}
+	return int64(v)
+}
+
+// Compares values of the same kind, numbers of different types included
+func memCompare(a any, b any) (int, bool) {
+	a, b = memNormalize(a), memNormalize(b)
+
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return memCmp(a, b), true
+		case float64:
+			return memCmp(float64(a), b), true
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return memCmp(a, float64(b)), true
+		case float64:
+			return memCmp(a, b), true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case []byte:
+		if b, ok := b.([]byte); ok {
+			return bytes.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
+
+// Orders NULLs before everything else, like SQLite does
+func memOrderCompare(a any, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	c, _ := memCompare(a, b)
+	return c
+}
+
+func memCmp[T int64 | float64](a T, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
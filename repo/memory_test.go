@@ -0,0 +1,44 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+)
+
+func TestMemorySearch(t *testing.T) {
+	mem := NewMemory()
+
+	one := testAudioFile("/music/1.flac", "Jóga", "1")
+	assert.Nil(t, mem.UpsertAudioFile(one))
+	two := testAudioFile("/music/2.flac", "Hunter", "2")
+	two.Tags[1].Value = "Björk"
+	assert.Nil(t, mem.UpsertAudioFile(two))
+
+	hits, err := mem.Search("jog")
+	assert.Nil(t, err)
+	assert.Equal(t, []SearchHit{{Name: "/music/1.flac", Title: "Jóga", Rank: -11}}, hits)
+
+	hits, err = mem.Search("artist:bjork")
+	assert.Nil(t, err)
+	assert.Len(t, hits, 1)
+	assert.Equal(t, "Hunter", hits[0].Title)
+
+	hits, err = mem.Search(`album:"album" first`)
+	assert.Nil(t, err)
+	assert.Len(t, hits, 2)
+}
+
+func TestMemoryPictures(t *testing.T) {
+	mem := NewMemory()
+
+	pics := []file.PictureLink{{PicType: 4}, {PicType: 3}}
+	assert.NotNil(t, mem.ReplaceFilePictures("/music/1.flac", pics))
+
+	assert.Nil(t, mem.Insert(file.FsFile{Name: "/music/1.flac"}))
+	assert.Nil(t, mem.ReplaceFilePictures("/music/1.flac", pics))
+	result, err := mem.FilePictures("/music/1.flac")
+	assert.Nil(t, err)
+	assert.Equal(t, []file.PictureLink{{PicType: 3}, {PicType: 4}}, result)
+}
@@ -0,0 +1,136 @@
+package repo
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema changes, applied in order, in a separate directory for each database. Files are
+// named NNNN_description.sql, numbers go without gaps, starting from 1. Applied migrations
+// must never be changed, add a new one instead, for every database
+//
+//go:embed sql/sqlite/*.sql sql/postgres/*.sql
+var migrationsFs embed.FS
+
+type migration struct {
+	version int
+	name    string
+	query   string
+}
+
+type NewerSchemaErr struct {
+	Current   int
+	Supported int
+}
+
+func (err NewerSchemaErr) Error() string {
+	return fmt.Sprintf(
+		"database schema version %d is newer than the latest supported one, %d. Refusing to open it",
+		err.Current,
+		err.Supported,
+	)
+}
+
+type MigrationErr struct {
+	Version int
+	Name    string
+	Err     error
+}
+
+func (err MigrationErr) Error() string {
+	return fmt.Sprintf("can't apply migration %04d (%s): %s", err.Version, err.Name, err.Err)
+}
+
+func (err MigrationErr) Unwrap() error {
+	return err.Err
+}
+
+func migrationsLoad(dialect dialect) ([]migration, error) {
+	dir := path.Join("sql", dialect.migrationsDir())
+	entries, err := migrationsFs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		versionStr, desc, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name %s, expected NNNN_description.sql", name)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s, expected NNNN_description.sql", name)
+		}
+
+		query, err := migrationsFs.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, migration{version: version, name: desc, query: string(query)})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].version < result[j].version
+	})
+	for i, m := range result {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migrations must be numbered without gaps, expected %04d, got %04d", i+1, m.version)
+		}
+	}
+
+	return result, nil
+}
+
+// Brings the schema up to date, applying each missing migration in its own transaction
+func migrate(db *sql.DB, dialect dialect, migrations []migration) error {
+	current, err := dialect.schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	latest := len(migrations)
+	if current > latest {
+		return NewerSchemaErr{Current: current, Supported: latest}
+	}
+
+	for _, m := range migrations[current:] {
+		if err := migrationApply(db, dialect, m); err != nil {
+			return MigrationErr{Version: m.version, Name: m.name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// Applies the migration, unless another client sharing the database has done it since the version was read
+func migrationApply(db *sql.DB, dialect dialect, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := dialect.migrationLock(tx)
+	if err != nil {
+		return err
+	}
+	if current >= m.version {
+		return nil
+	}
+
+	if _, err := tx.Exec(m.query); err != nil {
+		return err
+	}
+	if err := dialect.schemaVersionSet(tx, m.version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
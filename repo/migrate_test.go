@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDbOpen(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	assert.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrationsLoad(t *testing.T) {
+	migrations, err := migrationsLoad(sqliteDialect{})
+	assert.Nil(t, err)
+	assert.Greater(t, len(migrations), 0)
+	assert.Equal(t, "init", migrations[0].name)
+}
+
+func TestMigrateFresh(t *testing.T) {
+	db := testDbOpen(t)
+	migrations, err := migrationsLoad(sqliteDialect{})
+	assert.Nil(t, err)
+
+	assert.Nil(t, migrate(db, sqliteDialect{}, migrations))
+	version, err := sqliteDialect{}.schemaVersion(db)
+	assert.Nil(t, err)
+	assert.Equal(t, len(migrations), version)
+
+	// Running it again is a no-op
+	assert.Nil(t, migrate(db, sqliteDialect{}, migrations))
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	db := testDbOpen(t)
+	_, err := db.Exec("PRAGMA user_version = 1000")
+	assert.Nil(t, err)
+
+	err = migrate(db, sqliteDialect{}, []migration{{version: 1, name: "init", query: "SELECT 1"}})
+	assert.Equal(t, NewerSchemaErr{Current: 1000, Supported: 1}, err)
+}
+
+func TestMigrateRollsBackFailedMigration(t *testing.T) {
+	db := testDbOpen(t)
+	migrations := []migration{
+		{version: 1, name: "first", query: "CREATE TABLE first (id INTEGER)"},
+		{version: 2, name: "second", query: "CREATE TABLE second (id INTEGER); NOT EVEN SQL"},
+	}
+
+	err := migrate(db, sqliteDialect{}, migrations)
+	var migrationErr MigrationErr
+	assert.True(t, errors.As(err, &migrationErr))
+	assert.Equal(t, 2, migrationErr.Version)
+
+	version, err := sqliteDialect{}.schemaVersion(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, version)
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'second'").Scan(&count)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestMigrateAlbumPictureRoot(t *testing.T) {
+	db := testDbOpen(t)
+	migrations, err := migrationsLoad(sqliteDialect{})
+	assert.Nil(t, err)
+	assert.Nil(t, migrate(db, sqliteDialect{}, migrations[:6]))
+
+	_, err = db.Exec(`INSERT INTO root(id, prefix) VALUES (1, '/music/');
+		INSERT INTO picture(id, sha1, mime_type, width, height, size) VALUES (1, x'01', 'image/jpeg', 1, 1, 1);
+		INSERT INTO album_picture(album_dir, picture_id, pic_type, description) VALUES
+			('/music', 1, 3, ''), ('/music/Album', 1, 3, ''), ('/other/Album', 1, 3, ''), ('/musical', 1, 3, '')`)
+	assert.Nil(t, err)
+	assert.Nil(t, migrate(db, sqliteDialect{}, migrations))
+
+	// Directories in roots become relative to them, the others are left as they are
+	rows, err := db.Query("SELECT root_id, album_dir FROM album_picture ORDER BY root_id, album_dir")
+	assert.Nil(t, err)
+	defer rows.Close()
+	type row struct {
+		rootId int64
+		dir    string
+	}
+	result := []row{}
+	for rows.Next() {
+		var r row
+		assert.Nil(t, rows.Scan(&r.rootId, &r.dir))
+		result = append(result, r)
+	}
+	assert.Nil(t, rows.Err())
+	assert.Equal(t, []row{{0, "/musical"}, {0, "/other/Album"}, {1, ""}, {1, "Album"}}, result)
+}
+
+func TestMigrationApplySkipsApplied(t *testing.T) {
+	db := testDbOpen(t)
+	m := migration{version: 1, name: "first", query: "CREATE TABLE first (id INTEGER)"}
+	assert.Nil(t, migrationApply(db, sqliteDialect{}, m))
+	// Applied by someone else after the version was read, it's not applied again
+	assert.Nil(t, migrationApply(db, sqliteDialect{}, m))
+}
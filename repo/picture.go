@@ -0,0 +1,227 @@
+package repo
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+// Replaces all pictures attached to the given file, which must already be inserted
+func (repo *Repo) ReplaceFilePictures(fsName string, pics []file.PictureLink) error {
+	return repo.Batch(func(tx Tx) error {
+		return tx.ReplaceFilePictures(fsName, pics)
+	})
+}
+
+func (tx *sqlTx) ReplaceFilePictures(fsName string, pics []file.PictureLink) error {
+	fsFileId, err := tx.fsFileId(fsName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM fs_file_picture WHERE fs_file_id = ?", fsFileId); err != nil {
+		return err
+	}
+
+	for _, pic := range pics {
+		pictureId, err := pictureUpsert(tx, pic.Picture)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO fs_file_picture(fs_file_id, picture_id, pic_type, description) VALUES(?, ?, ?, ?)
+			ON CONFLICT DO NOTHING`,
+			fsFileId,
+			pictureId,
+			pic.PicType,
+			pic.Desc,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replaces all pictures attached to the album in the given directory
+func (repo *Repo) ReplaceAlbumPictures(albumDir string, pics []file.PictureLink) error {
+	return repo.Batch(func(tx Tx) error {
+		return tx.ReplaceAlbumPictures(albumDir, pics)
+	})
+}
+
+func (tx *sqlTx) ReplaceAlbumPictures(albumDir string, pics []file.PictureLink) error {
+	rootId, relDir, err := albumDirSplit(tx.rootSplit, albumDir)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM album_picture WHERE root_id = ? AND album_dir = ?", rootId, relDir); err != nil {
+		return err
+	}
+
+	for _, pic := range pics {
+		pictureId, err := pictureUpsert(tx, pic.Picture)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO album_picture(root_id, album_dir, picture_id, pic_type, description) VALUES(?, ?, ?, ?, ?)
+			ON CONFLICT DO NOTHING`,
+			rootId,
+			relDir,
+			pictureId,
+			pic.PicType,
+			pic.Desc,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Gives pictures of albums in the directory, and in the ones inside, the new directory
+func (repo *Repo) MoveAlbumPictures(oldDir string, dir string) error {
+	return repo.Batch(func(tx Tx) error {
+		return tx.MoveAlbumPictures(oldDir, dir)
+	})
+}
+
+func (tx *sqlTx) MoveAlbumPictures(oldDir string, dir string) error {
+	oldRootId, oldRelDir, err := albumDirSplit(tx.rootSplit, oldDir)
+	if err != nil {
+		return err
+	}
+	rootId, relDir, err := albumDirSplit(tx.rootSplit, dir)
+	if err != nil {
+		return err
+	}
+
+	// Lengths are in characters, same as in the string functions of both databases
+	prefix := oldRelDir + string(filepath.Separator)
+	newPrefix := relDir + string(filepath.Separator)
+	if _, err := tx.Exec(
+		"DELETE FROM album_picture WHERE root_id = ? AND (album_dir = ? OR substr(album_dir, 1, ?) = ?)",
+		rootId,
+		relDir,
+		utf8.RuneCountInString(newPrefix),
+		newPrefix,
+	); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`UPDATE album_picture SET root_id = ?, album_dir = CAST(? AS TEXT) || substr(album_dir, ?)
+		WHERE root_id = ? AND (album_dir = ? OR substr(album_dir, 1, ?) = ?)`,
+		rootId,
+		relDir,
+		utf8.RuneCountInString(oldRelDir)+1,
+		oldRootId,
+		oldRelDir,
+		utf8.RuneCountInString(prefix),
+		prefix,
+	)
+	return err
+}
+
+func (repo *Repo) FilePictures(fsName string) ([]file.PictureLink, error) {
+	rootId, relName, err := repo.rootSplit(fsName)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.picturesQuery(`SELECT
+		p.sha1, p.mime_type, p.width, p.height, p.size, fp.pic_type, fp.description
+		FROM fs_file_picture fp
+		JOIN picture p ON p.id = fp.picture_id
+		JOIN fs_file f ON f.id = fp.fs_file_id
+		WHERE f.root_id = ? AND f.fs_name = ?
+		ORDER BY fp.pic_type`,
+		rootId,
+		relName,
+	)
+}
+
+func (repo *Repo) AlbumPictures(albumDir string) ([]file.PictureLink, error) {
+	rootId, relDir, err := albumDirSplit(repo.rootSplit, albumDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.picturesQuery(`SELECT
+		p.sha1, p.mime_type, p.width, p.height, p.size, ap.pic_type, ap.description
+		FROM album_picture ap
+		JOIN picture p ON p.id = ap.picture_id
+		WHERE ap.root_id = ? AND ap.album_dir = ?
+		ORDER BY ap.pic_type`,
+		rootId,
+		relDir,
+	)
+}
+
+// Id of the root the album directory belongs to, and the directory relative to it, which is
+// empty for the root itself. Directories outside of any root keep their full names
+func albumDirSplit(split func(name string) (int64, string, error), albumDir string) (int64, string, error) {
+	rootId, relDir, err := split(rootPrefix(albumDir))
+	if err != nil {
+		return 0, "", err
+	}
+	if rootId == 0 {
+		return 0, albumDir, nil
+	}
+	return rootId, strings.TrimSuffix(relDir, string(filepath.Separator)), nil
+}
+
+// Removes pictures nothing links to anymore
+func (tx *sqlTx) picturesPrune() error {
+	_, err := tx.Exec(`DELETE FROM picture
+		WHERE NOT EXISTS (SELECT 1 FROM fs_file_picture fp WHERE fp.picture_id = picture.id)
+		AND NOT EXISTS (SELECT 1 FROM album_picture ap WHERE ap.picture_id = picture.id)`)
+	return err
+}
+
+func pictureUpsert(tx *sqlTx, pic file.Picture) (int64, error) {
+	if _, err := tx.Exec(
+		"INSERT INTO picture(sha1, mime_type, width, height, size) VALUES(?, ?, ?, ?, ?) ON CONFLICT DO NOTHING",
+		pic.Hash,
+		pic.MimeType,
+		pic.Width,
+		pic.Height,
+		pic.Size,
+	); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := tx.QueryRow("SELECT id FROM picture WHERE sha1 = ?", pic.Hash).Scan(&id)
+	return id, err
+}
+
+func (repo *Repo) picturesQuery(query string, args ...any) ([]file.PictureLink, error) {
+	rows, err := repo.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []file.PictureLink{}
+	for rows.Next() {
+		var pic file.PictureLink
+		if err := rows.Scan(
+			&pic.Hash,
+			&pic.MimeType,
+			&pic.Width,
+			&pic.Height,
+			&pic.Size,
+			&pic.PicType,
+			&pic.Desc,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, pic)
+	}
+
+	return result, rows.Err()
+}
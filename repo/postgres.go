@@ -0,0 +1,205 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+type PostgresConfig struct {
+	// Connection string, either a URL or key=value pairs, as accepted by lib/pq
+	Dsn string
+}
+
+// Connects to a PostgreSQL server, so that the library can be shared by many instances
+func InitPostgres(cfg PostgresConfig) (Repo, error) {
+	var result Repo
+
+	db, err := sql.Open("postgres", cfg.Dsn)
+	if err != nil {
+		return result, err
+	}
+	if err := dbPrepare(db, postgresDialect{}); err != nil {
+		db.Close()
+		return result, err
+	}
+
+	result = Repo{db: db, dialect: postgresDialect{}}
+	return result, nil
+}
+
+type postgresDialect struct{}
+
+func (_ postgresDialect) rebind(query string) string {
+	return rebindNumbered(query)
+}
+
+func (_ postgresDialect) migrationsDir() string {
+	return "postgres"
+}
+
+// Key of the advisory lock held by clients applying migrations, the same for all of them
+const migrationLockKey = 0x766f696468
+
+// There's no user_version in PostgreSQL, so the version is kept in a table of its own
+func (dialect postgresDialect) schemaVersion(db *sql.DB) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := dialect.migrationLock(tx)
+	if err != nil {
+		return 0, err
+	}
+	return result, tx.Commit()
+}
+
+// Clients sharing the server may start at the same time. The table of the version is
+// made under the lock as well, making it at once fails for all of them but one
+func (_ postgresDialect) migrationLock(tx *sql.Tx) (int, error) {
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockKey); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)"); err != nil {
+		return 0, err
+	}
+
+	var result int
+	err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&result)
+	return result, err
+}
+
+func (_ postgresDialect) schemaVersionSet(tx *sql.Tx, version int) error {
+	if _, err := tx.Exec("DELETE FROM schema_version"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("INSERT INTO schema_version(version) VALUES($1)", version)
+	return err
+}
+
+func (_ postgresDialect) likeWrite(w *queryWriter, expr string, pattern any) {
+	w.write(expr + " ILIKE ")
+	w.arg(pattern)
+	w.write(` ESCAPE '\'`)
+}
+
+func (_ postgresDialect) globWrite(w *queryWriter, expr string, pattern any) {
+	if s, ok := pattern.(string); ok {
+		pattern = globRegexp(s)
+	}
+	w.write(expr + " ~ ")
+	w.arg(pattern)
+}
+
+func (_ postgresDialect) noLimit() string {
+	return "ALL"
+}
+
+// Lost a serialization conflict or a deadlock, or gave up waiting for a lock
+func (_ postgresDialect) busy(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "40001", "40P01", "55P03":
+		return true
+	}
+	return false
+}
+
+// The search table is a part of the schema, and it's always in sync
+func (_ postgresDialect) searchInit(_ *sql.DB) error {
+	return nil
+}
+
+// Diacritics are folded with the same table as on the Go side, so that
+// it doesn't depend on the unaccent extension being installed
+func (_ postgresDialect) searchIndex(tx *sqlTx, trackId int64, albumId *int64) error {
+	folded := func(expr string) string {
+		return "translate(lower(" + expr + "), ?, ?)"
+	}
+	args := []any{}
+	for range 4 {
+		args = append(args, searchFoldFrom, searchFoldTo)
+	}
+	args = append(args, trackId, albumId)
+
+	_, err := tx.Exec(`INSERT INTO track_search(track_id, doc)
+		SELECT t.id,
+			setweight(to_tsvector('simple', `+folded("t.title")+`), 'A') ||
+			setweight(to_tsvector('simple', `+folded(`COALESCE((SELECT string_agg(a.name, ' ') FROM (
+				SELECT artist_id FROM track_artist WHERE track_id = t.id
+				UNION SELECT artist_id FROM album_artist WHERE album_id = t.album_id
+			) credit JOIN artist a ON a.id = credit.artist_id), '')`)+`), 'B') ||
+			setweight(to_tsvector('simple', `+folded("COALESCE(al.title, '')")+`), 'C') ||
+			setweight(to_tsvector('simple', `+folded(`COALESCE((
+				SELECT string_agg(tg.value, ' ') FROM tag tg WHERE tg.track_id = t.id
+			), '')`)+`), 'D')
+		FROM track t
+		LEFT JOIN album al ON al.id = t.album_id
+		WHERE t.id = ? OR t.album_id = ?
+		ON CONFLICT (track_id) DO UPDATE SET doc = excluded.doc`,
+		args...,
+	)
+	return err
+}
+
+func (_ postgresDialect) search(db *sql.DB, terms []searchTerm) ([]SearchHit, error) {
+	result := []SearchHit{}
+
+	query := postgresTsquery(terms)
+	if query == "" {
+		return result, nil
+	}
+
+	// Weights go from D to A, same proportions as with FTS5. Rank is negated, so that lower is better
+	rows, err := db.Query(`SELECT `+filenameExpr+`, t.title, -ts_rank('{0.1, 0.5, 0.8, 1.0}', s.doc, q) AS score
+		FROM track_search s
+		JOIN track t ON t.id = s.track_id
+		JOIN fs_file f ON f.id = t.fs_file_id
+		JOIN root r ON r.id = f.root_id,
+		to_tsquery('simple', $1) q
+		WHERE s.doc @@ q
+		ORDER BY score`,
+		query,
+	)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hit SearchHit
+		if err := rows.Scan(&hit.Name, &hit.Title, &hit.Rank); err != nil {
+			return result, err
+		}
+		result = append(result, hit)
+	}
+
+	return result, rows.Err()
+}
+
+// Builds a tsquery out of the terms. Words are made of letters and digits only, so nothing needs quoting
+func postgresTsquery(terms []searchTerm) string {
+	weights := map[string]string{"title": "A", "artist": "B", "album": "C", "tags": "D"}
+
+	exprs := []string{}
+	for _, term := range terms {
+		words := searchWords(term.text)
+		if len(words) == 0 {
+			continue
+		}
+		for i, word := range words {
+			words[i] = word + ":*" + weights[term.column]
+		}
+		// Words of a phrase must follow each other
+		exprs = append(exprs, "("+strings.Join(words, " <-> ")+")")
+	}
+
+	return strings.Join(exprs, " & ")
+}
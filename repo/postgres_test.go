@@ -0,0 +1,47 @@
+package repo
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebindNumbered(t *testing.T) {
+	assert.Equal(
+		t,
+		`SELECT '?', "a?" FROM t WHERE a = $1 AND b LIKE $2 ESCAPE '\'`,
+		rebindNumbered(`SELECT '?', "a?" FROM t WHERE a = ? AND b LIKE ? ESCAPE '\'`),
+	)
+}
+
+func TestPostgresTsquery(t *testing.T) {
+	terms, err := searchTermsParse(`artist:Björk "all is full" x:y`)
+	assert.Nil(t, err)
+	assert.Equal(t, "(bjork:*B) & (all:* <-> is:* <-> full:*) & (x:* <-> y:*)", postgresTsquery(terms))
+}
+
+// Clients sharing the server start at the same time, the migrations are applied once
+func TestPostgresMigrateConcurrently(t *testing.T) {
+	dsn := os.Getenv(testPostgresEnv)
+	if dsn == "" {
+		t.Skip("no PostgreSQL server, set " + testPostgresEnv)
+	}
+	dsn = testPostgresSchema(t, dsn)
+	migrations, err := migrationsLoad(postgresDialect{})
+	assert.Nil(t, err)
+
+	errs := make(chan error)
+	for range 4 {
+		db, err := sql.Open("postgres", dsn)
+		assert.Nil(t, err)
+		t.Cleanup(func() { db.Close() })
+		go func() {
+			errs <- migrate(db, postgresDialect{}, migrations)
+		}()
+	}
+	for range 4 {
+		assert.Nil(t, <-errs)
+	}
+}
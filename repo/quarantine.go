@@ -0,0 +1,167 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// File the watcher has given up on, after failing to index it over and over. It's
+// left alone until it changes, or until it's released
+type Quarantined struct {
+	Name string
+	// Size and modification time the file had when it was put in quarantine
+	Size    int64
+	ModTime time.Time
+	// Last error
+	Reason   string
+	Attempts int
+	At       time.Time
+}
+
+// Whether the file is still the way it was when it was put in quarantine, and there's no point in trying it again
+func (q Quarantined) Same(size int64, modTime time.Time) bool {
+	return q.Size == size && q.ModTime.UnixMilli() == modTime.UnixMilli()
+}
+
+// Files in quarantine, ordered by name
+func (repo *Repo) Quarantined() ([]Quarantined, error) {
+	rows, err := repo.query("SELECT name, size, mtime, reason, attempts, at FROM quarantine ORDER BY name")
+	if err != nil {
+		return nil, busyWrap(repo.dialect, err)
+	}
+	defer rows.Close()
+
+	result := []Quarantined{}
+	for rows.Next() {
+		var q Quarantined
+		var mtime, at int64
+		if err := rows.Scan(&q.Name, &q.Size, &mtime, &q.Reason, &q.Attempts, &at); err != nil {
+			return nil, err
+		}
+		q.ModTime = time.UnixMilli(mtime)
+		q.At = time.UnixMilli(at)
+		result = append(result, q)
+	}
+	return result, rows.Err()
+}
+
+// Fails with [NotFoundErr] if the file isn't in quarantine
+func (repo *Repo) QuarantinedGet(name string) (Quarantined, error) {
+	result := Quarantined{Name: name}
+	var mtime, at int64
+	err := repo.db.QueryRow(
+		repo.dialect.rebind("SELECT size, mtime, reason, attempts, at FROM quarantine WHERE name = ?"),
+		name,
+	).Scan(&result.Size, &mtime, &result.Reason, &result.Attempts, &at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, NotFoundErr
+	} else if err != nil {
+		return result, busyWrap(repo.dialect, err)
+	}
+	result.ModTime = time.UnixMilli(mtime)
+	result.At = time.UnixMilli(at)
+	return result, nil
+}
+
+// Puts the file in quarantine, or updates it if it's there already
+func (repo *Repo) Quarantine(q Quarantined) error {
+	return repo.txRun(SourceUser, func(tx *sqlTx) error {
+		_, err := tx.Exec(
+			`INSERT INTO quarantine(name, size, mtime, reason, attempts, at) VALUES(?, ?, ?, ?, ?, ?)
+			ON CONFLICT(name) DO UPDATE SET size = excluded.size, mtime = excluded.mtime,
+				reason = excluded.reason, attempts = excluded.attempts, at = excluded.at`,
+			q.Name,
+			q.Size,
+			q.ModTime.UnixMilli(),
+			q.Reason,
+			q.Attempts,
+			q.At.UnixMilli(),
+		)
+		return err
+	})
+}
+
+// Takes the file out of quarantine, or all files inside of it if it's a directory.
+// Fails with [NotFoundErr] if there are none
+func (repo *Repo) Release(name string) error {
+	prefix := rootPrefix(name)
+	return repo.txRun(SourceUser, func(tx *sqlTx) error {
+		res, err := tx.Exec(
+			"DELETE FROM quarantine WHERE name = ? OR substr(name, 1, ?) = ?",
+			name,
+			utf8.RuneCountInString(prefix),
+			prefix,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("%s: %w", name, NotFoundErr)
+		}
+		return nil
+	})
+}
+
+func (mem *Memory) Quarantined() ([]Quarantined, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	result := slices.Clone(mem.quarantine)
+	slices.SortFunc(result, func(a Quarantined, b Quarantined) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return result, nil
+}
+
+func (mem *Memory) QuarantinedGet(name string) (Quarantined, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for _, q := range mem.quarantine {
+		if q.Name == name {
+			return q, nil
+		}
+	}
+	return Quarantined{Name: name}, NotFoundErr
+}
+
+func (mem *Memory) Quarantine(q Quarantined) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	// Same precision as in the databases
+	q.ModTime = time.UnixMilli(q.ModTime.UnixMilli())
+	q.At = time.UnixMilli(q.At.UnixMilli())
+	for i, other := range mem.quarantine {
+		if other.Name == q.Name {
+			mem.quarantine[i] = q
+			return nil
+		}
+	}
+	mem.quarantine = append(mem.quarantine, q)
+	return nil
+}
+
+func (mem *Memory) Release(name string) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	prefix := rootPrefix(name)
+	n := len(mem.quarantine)
+	mem.quarantine = slices.DeleteFunc(mem.quarantine, func(q Quarantined) bool {
+		return q.Name == name || strings.HasPrefix(q.Name, prefix)
+	})
+	if len(mem.quarantine) == n {
+		return fmt.Errorf("%s: %w", name, NotFoundErr)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package repo
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantine(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, store.AddRoot(DefaultRoot("/music")))
+			modTime := time.UnixMilli(1700000000123)
+			broken := Quarantined{
+				Name:     "/music/a/broken.flac",
+				Size:     10,
+				ModTime:  modTime,
+				Reason:   "permission denied",
+				Attempts: 1,
+				At:       time.UnixMilli(1700000001000),
+			}
+			assert.Nil(t, store.Quarantine(broken))
+			broken.Attempts = 5
+			assert.Nil(t, store.Quarantine(broken))
+			other := Quarantined{Name: "/other/x.flac", ModTime: modTime, At: modTime, Reason: "busy", Attempts: 5}
+			assert.Nil(t, store.Quarantine(other))
+
+			list, err := store.Quarantined()
+			assert.Nil(t, err)
+			assert.Equal(t, []Quarantined{broken, other}, list)
+			q, err := store.QuarantinedGet(broken.Name)
+			assert.Nil(t, err)
+			assert.True(t, q.Same(10, modTime.Add(100*time.Microsecond)))
+			assert.False(t, q.Same(11, modTime))
+			_, err = store.QuarantinedGet("/music/a/fine.flac")
+			assert.True(t, errors.Is(err, NotFoundErr))
+
+			// Released by the directory it's in
+			assert.Nil(t, store.Release("/music/a"))
+			assert.True(t, errors.Is(store.Release("/music/a"), NotFoundErr))
+			assert.Nil(t, store.Quarantine(broken))
+			// Gone along with its root
+			assert.Nil(t, store.RemoveRoot("/music"))
+			list, err = store.Quarantined()
+			assert.Nil(t, err)
+			assert.Equal(t, []Quarantined{other}, list)
+		})
+	}
+}
@@ -0,0 +1,508 @@
+package repo
+
+import (
+	"database/sql"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Files joined with everything that can be queried about them. Files that are not
+// audio, or that couldn't be parsed, have NULLs for all track columns
+const queryFrom = `fs_file f
+	JOIN root r ON r.id = f.root_id
+	LEFT JOIN track t ON t.fs_file_id = f.id
+	LEFT JOIN track_property p ON p.track_id = t.id
+	LEFT JOIN album al ON al.id = t.album_id`
+
+// Full name of the file. Names are stored relative to the root of the file, conditions
+// on them can't use indexes, so [Eq] and [Under] split the name instead, see [queryWriter.roots]
+const filenameExpr = "(r.prefix || f.fs_name)"
+
+// Selects files matching the condition, in the given order. Zero limit means no limit
+type Query struct {
+	// Nil matches everything
+	Where  Cond
+	Order  []Order
+	Limit  int
+	Offset int
+}
+
+type Order struct {
+	Key  Key
+	Desc bool
+}
+
+// Condition over the keys of a file, built with [And], [Or], [Not], [Eq] and friends.
+// All values end up bound as parameters, never formatted into the query itself
+type Cond interface {
+	condWrite(w *queryWriter)
+	condMatch(rec *memFile) memTruth
+}
+
+// Something to filter or order files by. Time values are compared as Unix milliseconds
+type Key interface {
+	// Writes the expression of the key value. Multi-valued keys write their first value
+	exprWrite(w *queryWriter)
+	// Values of the key for [Memory], empty for NULL
+	memValues(rec *memFile) []any
+}
+
+// Key with any amount of values per file, conditions on it hold if any of the values matches
+type multiKey interface {
+	Key
+	anyWrite(w *queryWriter, valueCond func(expr string))
+}
+
+type Filename struct{}
+type Hash struct{}
+
+// Size of the file in bytes
+type Size struct{}
+
+// Modification time of the file
+type ModTime struct{}
+
+// Time the file was first indexed
+type AddedAt struct{}
+
+type Title struct{}
+type TrackNum struct{}
+type DiscNum struct{}
+type Date struct{}
+type AlbumTitle struct{}
+type Codec struct{}
+type SampleRate struct{}
+type Channels struct{}
+type BitsPerSample struct{}
+type DurationMs struct{}
+type Bitrate struct{}
+
+// Values of a tag, by its normalized name, like [file.TagArtist]
+type Tag struct {
+	Name string
+}
+
+func (_ Filename) exprWrite(w *queryWriter)      { w.write(filenameExpr) }
+func (_ Hash) exprWrite(w *queryWriter)          { w.write("f.sha1") }
+func (_ Size) exprWrite(w *queryWriter)          { w.write("f.size") }
+func (_ ModTime) exprWrite(w *queryWriter)       { w.write("f.mtime") }
+func (_ AddedAt) exprWrite(w *queryWriter)       { w.write("f.added_at") }
+func (_ Title) exprWrite(w *queryWriter)         { w.write("t.title") }
+func (_ TrackNum) exprWrite(w *queryWriter)      { w.write("t.track_num") }
+func (_ DiscNum) exprWrite(w *queryWriter)       { w.write("t.disc_num") }
+func (_ Date) exprWrite(w *queryWriter)          { w.write("t.date") }
+func (_ AlbumTitle) exprWrite(w *queryWriter)    { w.write("al.title") }
+func (_ Codec) exprWrite(w *queryWriter)         { w.write("p.codec") }
+func (_ SampleRate) exprWrite(w *queryWriter)    { w.write("p.sample_rate") }
+func (_ Channels) exprWrite(w *queryWriter)      { w.write("p.channels") }
+func (_ BitsPerSample) exprWrite(w *queryWriter) { w.write("p.bits_per_sample") }
+func (_ DurationMs) exprWrite(w *queryWriter)    { w.write("p.duration_ms") }
+func (_ Bitrate) exprWrite(w *queryWriter)       { w.write("p.bitrate") }
+
+func (key Tag) exprWrite(w *queryWriter) {
+	w.write("(SELECT tg.value FROM tag tg WHERE tg.track_id = t.id AND tg.key = ")
+	w.arg(key.Name)
+	w.write(" ORDER BY tg.position LIMIT 1)")
+}
+
+func (key Tag) anyWrite(w *queryWriter, valueCond func(expr string)) {
+	w.write("EXISTS (SELECT 1 FROM tag tg WHERE tg.track_id = t.id AND tg.key = ")
+	w.arg(key.Name)
+	w.write(" AND ")
+	valueCond("tg.value")
+	w.write(")")
+}
+
+type condAll struct {
+	op    string
+	conds []Cond
+}
+
+type condNot struct {
+	cond Cond
+}
+
+// Files inside of the directory, see [Under]
+type condUnder struct {
+	dir string
+}
+
+type condCompare struct {
+	key Key
+	op  string
+	// Values to the right of the operator, joined with AND for BETWEEN, or listed for IN
+	values []any
+}
+
+// Holds if all of the conditions hold, or if there are none
+func And(conds ...Cond) Cond {
+	return condAll{op: "AND", conds: conds}
+}
+
+// Holds if any of the conditions holds. Never holds if there are none
+func Or(conds ...Cond) Cond {
+	return condAll{op: "OR", conds: conds}
+}
+
+func Not(cond Cond) Cond {
+	return condNot{cond}
+}
+
+func Eq(key Key, value any) Cond { return condCompare{key, "=", []any{value}} }
+func Ne(key Key, value any) Cond { return condCompare{key, "!=", []any{value}} }
+func Lt(key Key, value any) Cond { return condCompare{key, "<", []any{value}} }
+func Le(key Key, value any) Cond { return condCompare{key, "<=", []any{value}} }
+func Gt(key Key, value any) Cond { return condCompare{key, ">", []any{value}} }
+func Ge(key Key, value any) Cond { return condCompare{key, ">=", []any{value}} }
+
+// Inclusive range
+func Between(key Key, low any, high any) Cond {
+	return condCompare{key, "BETWEEN", []any{low, high}}
+}
+
+// SQL LIKE pattern, case insensitive, at least for ASCII. Backslash escapes % and _
+func Like(key Key, pattern string) Cond {
+	return condCompare{key, "LIKE", []any{pattern}}
+}
+
+// Unix glob pattern, case sensitive
+func Glob(key Key, pattern string) Cond {
+	return condCompare{key, "GLOB", []any{pattern}}
+}
+
+// Holds if the value is any of the given ones. Never holds if there are none
+func In(key Key, values ...any) Cond {
+	return condCompare{key, "IN", values}
+}
+
+// Holds if the key has no value at all, e.g. a file that is not a track has no title
+func IsNull(key Key) Cond {
+	return condCompare{key, "IS NULL", nil}
+}
+
+// Holds for files anywhere inside the directory. Names are compared as strings, and
+// the ones inside are the ones between the separator and the character following it
+func Under(dir string) Cond {
+	return condUnder{dir}
+}
+
+// Same condition, comparing full names
+func (cond condUnder) byName() Cond {
+	prefix := rootPrefix(cond.dir)
+	return And(
+		Gt(Filename{}, prefix),
+		Lt(Filename{}, prefix[:len(prefix)-1]+string(filepath.Separator+1)),
+	)
+}
+
+// Whether the condition is on file names, which are resolved to roots of the files if there are any
+func condNamed(cond Cond) bool {
+	switch cond := cond.(type) {
+	case condAll:
+		for _, c := range cond.conds {
+			if condNamed(c) {
+				return true
+			}
+		}
+	case condNot:
+		return condNamed(cond.cond)
+	case condUnder:
+		return true
+	case condCompare:
+		_, ok := cond.key.(Filename)
+		return ok && cond.op == "="
+	}
+	return false
+}
+
+func (cond condAll) condWrite(w *queryWriter) {
+	if len(cond.conds) == 0 {
+		if cond.op == "AND" {
+			w.write("TRUE")
+		} else {
+			w.write("FALSE")
+		}
+		return
+	}
+
+	w.write("(")
+	for i, c := range cond.conds {
+		if i > 0 {
+			w.write(" " + cond.op + " ")
+		}
+		c.condWrite(w)
+	}
+	w.write(")")
+}
+
+func (cond condNot) condWrite(w *queryWriter) {
+	w.write("NOT (")
+	cond.cond.condWrite(w)
+	w.write(")")
+}
+
+// Names inside of the root are ranges of its index, the roots inside are taken whole
+func (cond condUnder) condWrite(w *queryWriter) {
+	if w.roots == nil {
+		cond.byName().condWrite(w)
+		return
+	}
+
+	prefix := rootPrefix(cond.dir)
+	end := prefix[:len(prefix)-1] + string(filepath.Separator+1)
+	root := rootRefOf(w.roots, prefix)
+	w.write("(f.root_id = ")
+	w.arg(root.id)
+	if len(root.prefix) < len(prefix) {
+		w.write(" AND f.fs_name > ")
+		w.arg(prefix[len(root.prefix):])
+		w.write(" AND f.fs_name < ")
+		w.arg(end[len(root.prefix):])
+	}
+	for _, inside := range w.roots {
+		if len(inside.prefix) > len(prefix) && strings.HasPrefix(inside.prefix, prefix) {
+			w.write(" OR f.root_id = ")
+			w.arg(inside.id)
+		}
+	}
+	w.write(")")
+}
+
+func (cond condCompare) condWrite(w *queryWriter) {
+	if cond.op == "IN" && len(cond.values) == 0 {
+		w.write("FALSE")
+		return
+	}
+
+	if _, ok := cond.key.(Filename); ok && cond.op == "=" && w.roots != nil {
+		if name, ok := cond.values[0].(string); ok {
+			root := rootRefOf(w.roots, name)
+			w.write("(f.root_id = ")
+			w.arg(root.id)
+			w.write(" AND f.fs_name = ")
+			w.arg(name[len(root.prefix):])
+			w.write(")")
+			return
+		}
+	}
+
+	valueCond := func(expr string) {
+		switch cond.op {
+		case "LIKE":
+			w.dialect.likeWrite(w, expr, cond.values[0])
+			return
+		case "GLOB":
+			w.dialect.globWrite(w, expr, cond.values[0])
+			return
+		}
+
+		w.write(expr + " " + cond.op)
+		switch cond.op {
+		case "IS NULL":
+		case "BETWEEN":
+			w.write(" ")
+			w.arg(cond.values[0])
+			w.write(" AND ")
+			w.arg(cond.values[1])
+		case "IN":
+			w.write(" (")
+			for i, v := range cond.values {
+				if i > 0 {
+					w.write(", ")
+				}
+				w.arg(v)
+			}
+			w.write(")")
+		default:
+			w.write(" ")
+			w.arg(cond.values[0])
+		}
+	}
+
+	if key, ok := cond.key.(multiKey); ok && cond.op != "IS NULL" {
+		key.anyWrite(w, valueCond)
+		return
+	}
+
+	expr := queryWriter{dialect: w.dialect}
+	cond.key.exprWrite(&expr)
+	w.args = append(w.args, expr.args...)
+	valueCond(expr.sql.String())
+}
+
+type queryWriter struct {
+	dialect dialect
+	sql     strings.Builder
+	args    []any
+	// Roots to split file names of conditions with, for them to use the index of names
+	// relative to the roots. Nil compares full names instead, which scans all files
+	roots []rootRef
+}
+
+// Root as it's stored, root 0 of files outside of any root included
+type rootRef struct {
+	id     int64
+	prefix string
+}
+
+// Root holding the file, by the longest prefix of its name, same as [rootSplitQuery]
+func rootRefOf(roots []rootRef, name string) rootRef {
+	result := rootRef{}
+	for _, root := range roots {
+		if strings.HasPrefix(name, root.prefix) && len(root.prefix) >= len(result.prefix) {
+			result = root
+		}
+	}
+	return result
+}
+
+// Writer of queries over files matching the condition, with the roots read by the query,
+// if the condition needs them
+func queryWriterMake(
+	dialect dialect,
+	where Cond,
+	query func(query string, args ...any) (*sql.Rows, error),
+) (queryWriter, error) {
+	result := queryWriter{dialect: dialect}
+	if where == nil || !condNamed(where) {
+		return result, nil
+	}
+
+	rows, err := query("SELECT id, prefix FROM root")
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	result.roots = []rootRef{}
+	for rows.Next() {
+		var root rootRef
+		if err := rows.Scan(&root.id, &root.prefix); err != nil {
+			return result, err
+		}
+		result.roots = append(result.roots, root)
+	}
+	return result, rows.Err()
+}
+
+func (w *queryWriter) write(s string) {
+	w.sql.WriteString(s)
+}
+
+func (w *queryWriter) arg(v any) {
+	w.sql.WriteString("?")
+	w.args = append(w.args, argValue(v))
+}
+
+// Converts values to the way they are stored
+func argValue(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UnixMilli()
+	case time.Duration:
+		return v.Milliseconds()
+	}
+	return v
+}
+
+// Writes the SELECT of file ids, along with the given columns, for the query
+func (q Query) selectWrite(w *queryWriter, columns string) {
+	w.write("SELECT " + columns + " FROM " + queryFrom)
+	if q.Where != nil {
+		w.write(" WHERE ")
+		q.Where.condWrite(w)
+	}
+
+	if len(q.Order) > 0 {
+		w.write(" ORDER BY ")
+		for i, order := range q.Order {
+			if i > 0 {
+				w.write(", ")
+			}
+			order.Key.exprWrite(w)
+			// Databases disagree on where NULLs go by default
+			if order.Desc {
+				w.write(" DESC NULLS LAST")
+			} else {
+				w.write(" ASC NULLS FIRST")
+			}
+		}
+	}
+
+	if q.Limit > 0 || q.Offset > 0 {
+		w.write(" LIMIT ")
+		if q.Limit > 0 {
+			w.arg(q.Limit)
+		} else {
+			w.write(w.dialect.noLimit())
+		}
+		w.write(" OFFSET ")
+		w.arg(q.Offset)
+	}
+}
+
+// Translates a glob pattern into an anchored regular expression, for when there's no GLOB around
+func globRegexp(pattern string) string {
+	var result strings.Builder
+	result.WriteString("^")
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i += 1 {
+		switch r := runes[i]; r {
+		case '*':
+			result.WriteString(".*")
+		case '?':
+			result.WriteString(".")
+		case '[':
+			end := i + 1
+			// A closing bracket right after the opening one is a part of the set
+			if end < len(runes) && runes[end] == '^' {
+				end += 1
+			}
+			if end < len(runes) && runes[end] == ']' {
+				end += 1
+			}
+			for end < len(runes) && runes[end] != ']' {
+				end += 1
+			}
+			if end >= len(runes) {
+				result.WriteString(regexp.QuoteMeta(string(r)))
+				continue
+			}
+			set := strings.ReplaceAll(string(runes[i+1:end]), `\`, `\\`)
+			result.WriteString("[" + set + "]")
+			i = end
+		default:
+			result.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	result.WriteString("$")
+	return result.String()
+}
+
+// Translates a LIKE pattern into an anchored, case insensitive regular expression
+func likeRegexp(pattern string) string {
+	var result strings.Builder
+	result.WriteString("(?is)^")
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i += 1 {
+		switch r := runes[i]; {
+		case r == '\\' && i+1 < len(runes):
+			i += 1
+			result.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case r == '%':
+			result.WriteString(".*")
+		case r == '_':
+			result.WriteString(".")
+		default:
+			result.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	result.WriteString("$")
+	return result.String()
+}
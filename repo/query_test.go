@@ -0,0 +1,170 @@
+package repo
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+)
+
+func testFindNames(t *testing.T, store Store, q Query) []string {
+	files, err := store.Find(q)
+	assert.Nil(t, err)
+	result := []string{}
+	for _, f := range files {
+		result = append(result, f.Name)
+	}
+	return result
+}
+
+func TestFind(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			one := testAudioFile("/music/1.flac", "One", "1")
+			one.FsFile.Size = 100
+			two := testAudioFile("/music/2.mp3", "Two", "2")
+			two.Properties.Codec = "mp3"
+			two.FsFile.Size = 200
+			assert.Nil(t, store.UpsertAudioFile(one))
+			assert.Nil(t, store.UpsertAudioFile(two))
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/music/cover.jpg", Hash: []byte{1}, Size: 300}))
+
+			weekAgo := time.Now().Add(-7 * 24 * time.Hour)
+			assert.Equal(t, []string{"/music/1.flac"}, testFindNames(t, store, Query{Where: And(
+				Eq(Codec{}, "flac"),
+				Eq(Tag{Name: file.TagArtist}, "Second"),
+				Gt(AddedAt{}, weekAgo),
+			)}))
+
+			assert.Equal(t, []string{"/music/2.mp3", "/music/1.flac"}, testFindNames(t, store, Query{
+				Where: Not(IsNull(Title{})),
+				Order: []Order{{Key: TrackNum{}, Desc: true}},
+			}))
+
+			// Files that are not tracks have no title, so they are neither equal to it, nor not
+			assert.Equal(t, []string{"/music/2.mp3"}, testFindNames(t, store, Query{
+				Where: Not(Eq(Title{}, "One")),
+			}))
+
+			assert.Equal(t, []string{"/music/cover.jpg"}, testFindNames(t, store, Query{Where: Or(
+				Between(Size{}, 250, 350),
+				Glob(Filename{}, "*.ogg"),
+			)}))
+
+			assert.Equal(t, []string{"/music/2.mp3"}, testFindNames(t, store, Query{
+				Where:  In(Codec{}, "flac", "mp3"),
+				Order:  []Order{{Key: Filename{}}},
+				Limit:  1,
+				Offset: 1,
+			}))
+
+			assert.Equal(t, []string{"/music/cover.jpg"}, testFindNames(t, store, Query{
+				Order:  []Order{{Key: Filename{}}},
+				Offset: 2,
+			}))
+
+			assert.Equal(t, []string{}, testFindNames(t, store, Query{Where: In(Codec{})}))
+			assert.Equal(t, []string{"/music/1.flac"}, testFindNames(t, store, Query{Where: Like(Title{}, "o%")}))
+			assert.Equal(t, []string{"/music/1.flac"}, testFindNames(t, store, Query{Where: Glob(Filename{}, "/music/[0-1].*")}))
+		})
+	}
+}
+
+func TestUpdateDelete(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, store.UpsertAudioFile(testAudioFile("/music/1.flac", "One", "1")))
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/music/cover.jpg", Hash: []byte{1}}))
+
+			assert.Nil(t, store.Update(Eq(Hash{}, []byte{1}), file.FsFile{Name: "/music/front.jpg", Hash: []byte{1}}))
+			assert.Equal(t, []string{"/music/1.flac", "/music/front.jpg"}, testFindNames(t, store, Query{
+				Order: []Order{{Key: Filename{}}},
+			}))
+
+			assert.Nil(t, store.Delete(Eq(Tag{Name: file.TagTitle}, "One")))
+			assert.Equal(t, []string{"/music/front.jpg"}, testFindNames(t, store, Query{}))
+		})
+	}
+}
+
+func TestUnder(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, name := range []string{"/music/a", "/music/a/1.flac", "/music/a/b/2.flac", "/music/a b/3.flac", "/music/a0"} {
+				assert.Nil(t, store.Insert(file.FsFile{Name: name, Hash: []byte(name)}))
+			}
+
+			assert.Equal(t, []string{"/music/a/1.flac", "/music/a/b/2.flac"}, testFindNames(t, store, Query{
+				Where: Under("/music/a"),
+				Order: []Order{{Key: Filename{}}},
+			}))
+		})
+	}
+}
+
+func TestUnderRoots(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, store.AddRoot(DefaultRoot("/music/a")))
+			assert.Nil(t, store.AddRoot(DefaultRoot("/music/c")))
+			names := []string{"/music/a/1.flac", "/music/a/b/2.flac", "/music/a/b0.flac", "/music/ab/3.flac", "/music/c/4.flac", "/music/d.flac"}
+			for _, name := range names {
+				assert.Nil(t, store.Insert(file.FsFile{Name: name, Hash: []byte(name)}))
+			}
+
+			under := func(dir string) []string {
+				return testFindNames(t, store, Query{Where: Under(dir), Order: []Order{{Key: Filename{}}}})
+			}
+			assert.Equal(t, []string{"/music/a/1.flac", "/music/a/b/2.flac", "/music/a/b0.flac"}, under("/music/a"))
+			assert.Equal(t, []string{"/music/a/b/2.flac"}, under("/music/a/b"))
+			assert.Equal(t, []string{"/music/ab/3.flac"}, under("/music/ab"))
+			assert.Equal(t, names, under("/music"))
+			assert.Equal(t, names, under("/"))
+			assert.Empty(t, under("/other"))
+
+			for _, name := range names {
+				assert.Equal(t, []string{name}, testFindNames(t, store, Query{Where: Eq(Filename{}, name)}))
+			}
+			assert.Empty(t, testFindNames(t, store, Query{Where: Eq(Filename{}, "/music/a")}))
+		})
+	}
+}
+
+// Conditions on names of files use the index of names within roots, instead of scanning all files
+func TestFilenameIndexed(t *testing.T) {
+	repo := testRepoInit(t)
+	assert.Nil(t, repo.AddRoot(DefaultRoot("/music")))
+
+	for _, cond := range []Cond{Eq(Filename{}, "/music/a/1.flac"), Under("/music/a")} {
+		w, err := queryWriterMake(repo.dialect, cond, repo.query)
+		assert.Nil(t, err)
+		Query{Where: cond}.selectWrite(&w, "f.id")
+
+		rows, err := repo.query("EXPLAIN QUERY PLAN "+w.sql.String(), w.args...)
+		assert.Nil(t, err)
+		plan := []string{}
+		for rows.Next() {
+			var id, parent, notUsed int
+			var detail string
+			assert.Nil(t, rows.Scan(&id, &parent, &notUsed, &detail))
+			plan = append(plan, detail)
+		}
+		rows.Close()
+		assert.True(t, slices.ContainsFunc(plan, func(detail string) bool {
+			return strings.HasPrefix(detail, "SEARCH f USING") && strings.Contains(detail, "fs_file_root_name (root_id=? AND fs_name")
+		}), plan)
+	}
+}
+
+func TestGlobRegexp(t *testing.T) {
+	assert.Equal(t, `^.*\.flac$`, globRegexp("*.flac"))
+	assert.Equal(t, `^track.[0-9][^a]$`, globRegexp("track?[0-9][^a]"))
+	assert.Equal(t, `^\[oops$`, globRegexp("[oops"))
+}
+
+func TestLikeRegexp(t *testing.T) {
+	assert.Equal(t, `(?is)^a.*b.%$`, likeRegexp(`a%b_\%`))
+}
@@ -0,0 +1,401 @@
+package repo
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+// Page size of [Repo.List] and friends, for queries without a limit
+const listPageSize = 500
+
+// Where a listing left off, opaque to callers. Empty means the very beginning
+// for [Store.ListPage], or that there's nothing left in [Page.Next]
+type Cursor string
+
+type Page struct {
+	Files []file.FsFile
+	Next  Cursor
+}
+
+// Returned when a cursor is malformed, or doesn't match the order of the query
+var CursorInvalidErr = fmt.Errorf("invalid cursor")
+
+// File along with the values of the keys it's ordered by, the last one being [fileId]
+type listRow struct {
+	fsFile file.FsFile
+	keys   []any
+}
+
+// Unique per file, ties files with equal order keys
+type fileId struct{}
+
+func (_ fileId) exprWrite(w *queryWriter)     { w.write("f.id") }
+func (_ fileId) memValues(rec *memFile) []any { return []any{int64(rec.seq)} }
+
+// Orders by the first value of a multi-valued key, while comparing only that value too
+type keyFirst struct {
+	key Key
+}
+
+func (k keyFirst) exprWrite(w *queryWriter) { k.key.exprWrite(w) }
+
+func (k keyFirst) memValues(rec *memFile) []any {
+	values := k.key.memValues(rec)
+	if len(values) > 1 {
+		return values[:1]
+	}
+	return values
+}
+
+// Columns scanned by [audioFileScan]
+const audioFileColumns = filenameExpr + `, f.sha1, f.size, f.mtime, t.id, t.audio_hash,
+	p.codec, p.sample_rate, p.channels, p.bits_per_sample, p.samples_total, p.bitrate`
+
+// Scans a row of [audioFileColumns], everything but tags. Track id isn't valid for files that are not tracks
+func audioFileScan(row interface{ Scan(dest ...any) error }) (file.AudioFile, sql.NullInt64, error) {
+	var result file.AudioFile
+
+	var trackId sql.NullInt64
+	var size, mtime sql.NullInt64
+	var codec sql.NullString
+	var sampleRate, channels, bitsPerSample, samplesTotal, bitrate sql.NullInt64
+	err := row.Scan(
+		&result.FsFile.Name,
+		&result.FsFile.Hash,
+		&size,
+		&mtime,
+		&trackId,
+		&result.AudioStreamHash,
+		&codec,
+		&sampleRate,
+		&channels,
+		&bitsPerSample,
+		&samplesTotal,
+		&bitrate,
+	)
+	if err != nil {
+		return result, trackId, err
+	}
+
+	result.FsFile.Size = size.Int64
+	if mtime.Valid {
+		result.FsFile.ModTime = time.UnixMilli(mtime.Int64)
+	}
+	if !trackId.Valid {
+		return result, trackId, nil
+	}
+
+	result.Properties = file.AudioProperties{
+		Codec:         codec.String,
+		SampleRate:    uint32(sampleRate.Int64),
+		Channels:      uint8(channels.Int64),
+		BitsPerSample: uint8(bitsPerSample.Int64),
+		SamplesTotal:  uint64(samplesTotal.Int64),
+		Bitrate:       uint32(bitrate.Int64),
+	}
+	result.Tags = []file.Tag{}
+	return result, trackId, nil
+}
+
+// Returns the file with everything known about it. Files that are not tracks have
+// only FsFile set, tracks always have non-nil tags
+func (repo *Repo) GetByPath(path string) (file.AudioFile, error) {
+	rootId, relName, err := repo.rootSplit(path)
+	if err != nil {
+		return file.AudioFile{}, err
+	}
+	result, trackId, err := audioFileScan(repo.db.QueryRow(repo.dialect.rebind(`SELECT `+audioFileColumns+`
+		FROM `+queryFrom+`
+		WHERE f.root_id = ? AND f.fs_name = ?`),
+		rootId,
+		relName,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, fmt.Errorf("%s: %w", path, NotFoundErr)
+	}
+	if err != nil {
+		return result, busyWrap(repo.dialect, err)
+	}
+	if !trackId.Valid {
+		return result, nil
+	}
+
+	rows, err := repo.query("SELECT key, value FROM tag WHERE track_id = ? ORDER BY position", trackId.Int64)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag file.Tag
+		if err := rows.Scan(&tag.Key, &tag.Value); err != nil {
+			return result, err
+		}
+		result.Tags = append(result.Tags, tag)
+	}
+
+	return result, rows.Err()
+}
+
+// Same as [Repo.GetByPath] for all files matching the query, with tags of all of
+// them fetched at once. Tags of tracks added in between the two are left empty
+func (repo *Repo) FindAudio(q Query) ([]file.AudioFile, error) {
+	w, err := queryWriterMake(repo.dialect, q.Where, repo.query)
+	if err != nil {
+		return nil, err
+	}
+	q.selectWrite(&w, audioFileColumns)
+
+	rows, err := repo.query(w.sql.String(), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []file.AudioFile{}
+	// Indices of the tracks in the result, by their ids
+	tracks := map[int64]int{}
+	for rows.Next() {
+		af, trackId, err := audioFileScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		if trackId.Valid {
+			tracks[trackId.Int64] = len(result)
+		}
+		result = append(result, af)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(tracks) == 0 {
+		return result, nil
+	}
+
+	w = queryWriter{dialect: repo.dialect, roots: w.roots}
+	w.write("SELECT track_id, key, value FROM tag WHERE track_id IN (")
+	q.selectWrite(&w, "t.id")
+	w.write(") ORDER BY track_id, position")
+
+	tagRows, err := repo.query(w.sql.String(), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer tagRows.Close()
+
+	for tagRows.Next() {
+		var trackId int64
+		var tag file.Tag
+		if err := tagRows.Scan(&trackId, &tag.Key, &tag.Value); err != nil {
+			return nil, err
+		}
+		// Tracks added since the files were read have no place in the result
+		if i, ok := tracks[trackId]; ok {
+			result[i].Tags = append(result[i].Tags, tag)
+		}
+	}
+
+	return result, tagRows.Err()
+}
+
+// Returns all files with the given contents, ordered by name
+func (repo *Repo) GetByHash(hash []byte) ([]file.FsFile, error) {
+	return repo.Find(Query{Where: Eq(Hash{}, hash), Order: []Order{{Key: Filename{}}}})
+}
+
+// Counts files matching the condition, nil matches everything
+func (repo *Repo) Count(where Cond) (int, error) {
+	var result int
+	w, err := queryWriterMake(repo.dialect, where, repo.query)
+	if err != nil {
+		return result, err
+	}
+	Query{Where: where}.selectWrite(&w, "COUNT(*)")
+
+	err = repo.db.QueryRow(repo.dialect.rebind(w.sql.String()), w.args...).Scan(&result)
+	return result, err
+}
+
+// Returns the page of files that follows the cursor. Pages are as long as the query
+// limit, its offset is ignored. Unlike with offsets, files that are changed between
+// pages are neither skipped, nor seen twice, unless their order keys change
+func (repo *Repo) ListPage(q Query, after Cursor) (Page, error) {
+	return listPage(q, after, repo.listRows)
+}
+
+// Iterates over all files matching the query, fetching them page by page. Stops at the first error
+func (repo *Repo) List(q Query) iter.Seq2[file.FsFile, error] {
+	return listIter(repo, q)
+}
+
+func (repo *Repo) listRows(q Query) ([]listRow, error) {
+	w, err := queryWriterMake(repo.dialect, q.Where, repo.query)
+	if err != nil {
+		return nil, err
+	}
+	columns := filenameExpr + ", f.sha1, f.size, f.mtime"
+	for _, order := range q.Order {
+		keyW := queryWriter{dialect: repo.dialect}
+		order.Key.exprWrite(&keyW)
+		columns += ", " + keyW.sql.String()
+		w.args = append(w.args, keyW.args...)
+	}
+	q.selectWrite(&w, columns)
+
+	rows, err := repo.query(w.sql.String(), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []listRow{}
+	for rows.Next() {
+		var row listRow
+		var size, mtime sql.NullInt64
+		row.keys = make([]any, len(q.Order))
+		dest := []any{&row.fsFile.Name, &row.fsFile.Hash, &size, &mtime}
+		for i := range row.keys {
+			dest = append(dest, &row.keys[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row.fsFile.Size = size.Int64
+		if mtime.Valid {
+			row.fsFile.ModTime = time.UnixMilli(mtime.Int64)
+		}
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
+
+// Fetches a page with the rows function, which must return files in the query order along with their keys
+func listPage(q Query, after Cursor, rows func(q Query) ([]listRow, error)) (Page, error) {
+	var result Page
+
+	order := []Order{}
+	for _, o := range q.Order {
+		order = append(order, Order{Key: keyFirst{o.Key}, Desc: o.Desc})
+	}
+	order = append(order, Order{Key: fileId{}})
+
+	where := q.Where
+	if after != "" {
+		values, err := cursorDecode(after)
+		if err != nil {
+			return result, err
+		}
+		if len(values) != len(order) {
+			return result, fmt.Errorf("%w: it has %d keys instead of %d", CursorInvalidErr, len(values), len(order))
+		}
+		if where == nil {
+			where = cursorCond(order, values)
+		} else {
+			where = And(where, cursorCond(order, values))
+		}
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = listPageSize
+	}
+
+	found, err := rows(Query{Where: where, Order: order, Limit: limit})
+	if err != nil {
+		return result, err
+	}
+
+	result.Files = []file.FsFile{}
+	for _, row := range found {
+		result.Files = append(result.Files, row.fsFile)
+	}
+	if len(found) == limit {
+		result.Next, err = cursorEncode(found[len(found)-1].keys)
+	}
+	return result, err
+}
+
+func listIter(store Store, q Query) iter.Seq2[file.FsFile, error] {
+	return func(yield func(file.FsFile, error) bool) {
+		var after Cursor
+		for {
+			page, err := store.ListPage(q, after)
+			if err != nil {
+				yield(file.FsFile{}, err)
+				return
+			}
+			for _, f := range page.Files {
+				if !yield(f, nil) {
+					return
+				}
+			}
+			if page.Next == "" {
+				return
+			}
+			after = page.Next
+		}
+	}
+}
+
+// Holds for files that come after the ones with the given key values. NULLs go
+// first in ascending order and last in descending one, same as in [Query]
+func cursorCond(order []Order, values []any) Cond {
+	alternatives := []Cond{}
+	for i, o := range order {
+		conds := []Cond{}
+		for j := range i {
+			if values[j] == nil {
+				conds = append(conds, IsNull(order[j].Key))
+			} else {
+				conds = append(conds, Eq(order[j].Key, values[j]))
+			}
+		}
+
+		v := values[i]
+		switch {
+		case o.Desc && v == nil:
+			// Nothing comes after NULLs
+			continue
+		case o.Desc:
+			conds = append(conds, Or(Lt(o.Key, v), IsNull(o.Key)))
+		case v == nil:
+			conds = append(conds, Not(IsNull(o.Key)))
+		default:
+			conds = append(conds, Gt(o.Key, v))
+		}
+		alternatives = append(alternatives, And(conds...))
+	}
+	return Or(alternatives...)
+}
+
+func cursorEncode(values []any) (Cursor, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return "", err
+	}
+	return Cursor(base64.RawURLEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+func cursorDecode(cursor Cursor) ([]any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(string(cursor))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", CursorInvalidErr, err)
+	}
+
+	var result []any
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%w: %w", CursorInvalidErr, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,124 @@
+package repo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+)
+
+func TestGet(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			one := testAudioFile("/music/1.flac", "One", "1")
+			one.FsFile.Hash = []byte{1}
+			assert.Nil(t, store.UpsertAudioFile(one))
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/music/copy.flac", Hash: []byte{1}}))
+
+			got, err := store.GetByPath("/music/1.flac")
+			assert.Nil(t, err)
+			assert.Equal(t, one.Tags, got.Tags)
+			assert.Equal(t, one.Properties, got.Properties)
+
+			got, err = store.GetByPath("/music/copy.flac")
+			assert.Nil(t, err)
+			assert.Nil(t, got.Tags)
+
+			_, err = store.GetByPath("/music/missing.flac")
+			assert.True(t, errors.Is(err, NotFoundErr))
+
+			files, err := store.GetByHash([]byte{1})
+			assert.Nil(t, err)
+			assert.Len(t, files, 2)
+			assert.Equal(t, "/music/copy.flac", files[1].Name)
+
+			count, err := store.Count(nil)
+			assert.Nil(t, err)
+			assert.Equal(t, 2, count)
+			count, err = store.Count(IsNull(Title{}))
+			assert.Nil(t, err)
+			assert.Equal(t, 1, count)
+		})
+	}
+}
+
+func TestFindAudio(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			one := testAudioFile("/music/1.flac", "One", "1")
+			two := testAudioFile("/music/2.flac", "Two", "2")
+			assert.Nil(t, store.UpsertAudioFile(two))
+			assert.Nil(t, store.UpsertAudioFile(one))
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/music/cover.jpg", Hash: []byte{1}}))
+
+			files, err := store.FindAudio(Query{Order: []Order{{Key: Filename{}}}})
+			assert.Nil(t, err)
+			assert.Len(t, files, 3)
+			// Same as getting them one by one
+			for _, af := range files {
+				got, err := store.GetByPath(af.FsFile.Name)
+				assert.Nil(t, err)
+				assert.Equal(t, got, af)
+			}
+			assert.Equal(t, one.Tags, files[0].Tags)
+			assert.Equal(t, two.Tags, files[1].Tags)
+			assert.Nil(t, files[2].Tags)
+
+			// Tags are only of the files matching the query
+			files, err = store.FindAudio(Query{Where: Eq(TrackNum{}, 2)})
+			assert.Nil(t, err)
+			assert.Len(t, files, 1)
+			assert.Equal(t, two.Tags, files[0].Tags)
+
+			files, err = store.FindAudio(Query{Where: Eq(TrackNum{}, 3)})
+			assert.Nil(t, err)
+			assert.Empty(t, files)
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, store.UpsertAudioFile(testAudioFile("/music/b.flac", "Same", "2")))
+			assert.Nil(t, store.UpsertAudioFile(testAudioFile("/music/a.flac", "Same", "1")))
+			assert.Nil(t, store.UpsertAudioFile(testAudioFile("/music/c.flac", "Other", "3")))
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/music/cover.jpg", Hash: []byte{1}}))
+
+			listNames := func(q Query) []string {
+				result := []string{}
+				for f, err := range store.List(q) {
+					assert.Nil(t, err)
+					result = append(result, f.Name)
+				}
+				return result
+			}
+
+			// Pages of two, ties are broken by insertion order, and the file without a title goes first
+			assert.Equal(t,
+				[]string{"/music/cover.jpg", "/music/c.flac", "/music/b.flac", "/music/a.flac"},
+				listNames(Query{Order: []Order{{Key: Title{}}}, Limit: 2}),
+			)
+			assert.Equal(t,
+				[]string{"/music/b.flac", "/music/a.flac", "/music/c.flac", "/music/cover.jpg"},
+				listNames(Query{Order: []Order{{Key: Tag{Name: file.TagTitle}, Desc: true}}, Limit: 1}),
+			)
+			assert.Equal(t,
+				[]string{"/music/a.flac"},
+				listNames(Query{Where: Eq(TrackNum{}, 1)}),
+			)
+
+			page, err := store.ListPage(Query{Limit: 3}, "")
+			assert.Nil(t, err)
+			assert.Len(t, page.Files, 3)
+			page, err = store.ListPage(Query{Limit: 3}, page.Next)
+			assert.Nil(t, err)
+			assert.Len(t, page.Files, 1)
+			assert.Equal(t, Cursor(""), page.Next)
+
+			_, err = store.ListPage(Query{Order: []Order{{Key: Title{}}}}, page.Next+"oops")
+			assert.True(t, errors.Is(err, CursorInvalidErr))
+		})
+	}
+}
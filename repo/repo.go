@@ -2,34 +2,36 @@ package repo
 
 import (
 	"database/sql"
-	"encoding/hex"
-	"fmt"
-	"log/slog"
+	"time"
 
 	"github.com/wetfloo/voidh/file"
 )
 
+// Library stored in an SQL database, SQLite or PostgreSQL
 type Repo struct {
-	db              *sql.DB
-	debugSelections bool
+	db      *sql.DB
+	dialect dialect
 }
 
 type Config struct {
-	DatabasePath    string
-	DebugSelections bool
-	RemoveIfExists  bool
+	DatabasePath string
 }
 
+// Opens the database, bringing its schema up to date. Builds without the sqlite_fts5 tag can't search
+// it, [Repo.Search] fails with [SearchUnsupportedErr] then. Databases that have been searched by a build
+// that can are refused by those, as they can't keep the index up to date
 func Init(cfg Config) (Repo, error) {
 	var result Repo
 
-	db, err := dbInit(cfg.DatabasePath, cfg.RemoveIfExists)
+	db, err := dbInit(cfg.DatabasePath)
 	if err != nil {
+		if db != nil {
+			db.Close()
+		}
 		return result, err
 	}
 
-	result = Repo{db: db, debugSelections: cfg.DebugSelections}
-	result.debugSelectAndPrint("init")
+	result = Repo{db: db, dialect: sqliteDialect{}}
 	return result, nil
 }
 
@@ -37,76 +39,207 @@ func (repo *Repo) Close() {
 	repo.db.Close()
 }
 
+// Inserts the file, or updates the one with the same name
 func (repo *Repo) Insert(file file.FsFile) error {
-	if _, err := dbInteract(
-		repo.db,
-		"INSERT INTO fs_file(fs_name, sha1) VALUES(?, ?)",
-		file.Name,
-		file.Hash,
-	); err != nil {
-		return err
-	}
+	return repo.Batch(func(tx Tx) error {
+		return tx.Insert(file)
+	})
+}
 
-	repo.debugSelectAndPrint("insert")
-	return nil
+func (tx *sqlTx) Insert(file file.FsFile) error {
+	_, err := tx.fsFileUpsert(file)
+	return err
+}
+
+// Updates all files matching the condition
+func (repo *Repo) Update(where Cond, file file.FsFile) error {
+	return repo.Batch(func(tx Tx) error {
+		return tx.Update(where, file)
+	})
 }
 
-func (repo *Repo) Update(criteria Criteria, file file.FsFile) error {
-	if _, err := dbInteract(
-		repo.db,
-		fmt.Sprintf("UPDATE fs_file SET fs_name = ?, sha1 = ? WHERE %s = ?", criteria.Key.dbKey()),
-		file.Name,
-		file.Hash,
-		criteria.Value,
-	); err != nil {
+func (tx *sqlTx) Update(where Cond, file file.FsFile) error {
+	matched, err := tx.fsFilesMatch(where)
+	if err != nil {
 		return err
 	}
 
-	repo.debugSelectAndPrint("update")
+	rootId, relName, err := tx.rootSplit(file.Name)
+	if err != nil {
+		return err
+	}
+
+	w, err := queryWriterMake(tx.dialect, where, tx.query)
+	if err != nil {
+		return err
+	}
+	w.write("UPDATE fs_file SET root_id = ")
+	w.arg(rootId)
+	w.write(", fs_name = ")
+	w.arg(relName)
+	w.write(", sha1 = ")
+	w.arg(file.Hash)
+	w.write(", size = ")
+	w.arg(file.Size)
+	w.write(", mtime = ")
+	w.arg(timeArg(file.ModTime))
+	w.write(" WHERE id IN (")
+	Query{Where: where}.selectWrite(&w, "f.id")
+	w.write(")")
+
+	if _, err := tx.Exec(w.sql.String(), w.args...); err != nil {
+		return err
+	}
+	for _, old := range matched {
+		if err := tx.eventLog(old.Name, old.Hash, file.Name, file.Hash); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (repo *Repo) Delete(criteria Criteria) error {
-	if _, err := dbInteract(
-		repo.db,
-		fmt.Sprintf("DELETE FROM fs_file WHERE %s = ?", criteria.Key.dbKey()),
-		criteria.Value,
-	); err != nil {
+// Deletes all files matching the condition, along with everything that belongs to them
+func (repo *Repo) Delete(where Cond) error {
+	return repo.Batch(func(tx Tx) error {
+		return tx.Delete(where)
+	})
+}
+
+func (tx *sqlTx) Delete(where Cond) error {
+	matched, err := tx.fsFilesMatch(where)
+	if err != nil {
 		return err
 	}
 
-	repo.debugSelectAndPrint("delete")
+	w, err := queryWriterMake(tx.dialect, where, tx.query)
+	if err != nil {
+		return err
+	}
+	w.write("DELETE FROM fs_file WHERE id IN (")
+	Query{Where: where}.selectWrite(&w, "f.id")
+	w.write(")")
+
+	if _, err := tx.Exec(w.sql.String(), w.args...); err != nil {
+		return err
+	}
+	for _, old := range matched {
+		if err := tx.eventLog(old.Name, old.Hash, "", nil); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (repo *Repo) debugSelectAndPrint(opName string) {
-	if !repo.debugSelections {
-		return
+// Lists files matching the query
+func (repo *Repo) Find(q Query) ([]file.FsFile, error) {
+	w, err := queryWriterMake(repo.dialect, q.Where, repo.query)
+	if err != nil {
+		return nil, err
 	}
+	q.selectWrite(&w, filenameExpr+", f.sha1, f.size, f.mtime")
 
-	rows, err := repo.db.Query("SELECT * FROM fs_file")
+	rows, err := repo.query(w.sql.String(), w.args...)
 	if err != nil {
-		slog.Debug("can't display the result", "err", err)
-		return
+		return nil, err
 	}
+	defer rows.Close()
 
+	result := []file.FsFile{}
 	for rows.Next() {
-		var id int
-		var fsPath string
-		sha1 := make([]byte, 20, 20)
+		var f file.FsFile
+		var size, mtime sql.NullInt64
+		if err := rows.Scan(&f.Name, &f.Hash, &size, &mtime); err != nil {
+			return nil, err
+		}
+		f.Size = size.Int64
+		if mtime.Valid {
+			f.ModTime = time.UnixMilli(mtime.Int64)
+		}
+		result = append(result, f)
+	}
+
+	return result, rows.Err()
+}
+
+// Replaces all virtual tracks backed by the given physical file, which must already be inserted
+func (repo *Repo) ReplaceVirtualTracks(source string, tracks []file.VirtualTrack) error {
+	return repo.Batch(func(tx Tx) error {
+		return tx.ReplaceVirtualTracks(source, tracks)
+	})
+}
+
+func (tx *sqlTx) ReplaceVirtualTracks(source string, tracks []file.VirtualTrack) error {
+	fsFileId, err := tx.fsFileId(source)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM virtual_track WHERE fs_file_id = ?", fsFileId); err != nil {
+		return err
+	}
 
-		if err = rows.Scan(&id, &fsPath, &sha1); err != nil {
-			slog.Debug("can't display the result for row", "err", err)
+	for _, track := range tracks {
+		if _, err := tx.Exec(
+			`INSERT INTO virtual_track(
+				fs_file_id, track_num, title, performer, isrc, start_sample, end_sample, sample_rate
+			) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+			fsFileId,
+			track.TrackNum,
+			track.Title,
+			track.Performer,
+			track.Isrc,
+			track.StartSample,
+			track.EndSample,
+			track.SampleRate,
+		); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		slog.Debug(
-			"new db result",
-			"opName", opName,
-			"id", id,
-			"fsPath", fsPath,
-			"sha1", hex.EncodeToString(sha1),
-		)
+// Lists all virtual tracks backed by the given physical file, ordered by track number
+func (repo *Repo) VirtualTracks(source string) ([]file.VirtualTrack, error) {
+	rootId, relName, err := repo.rootSplit(source)
+	if err != nil {
+		return nil, err
 	}
 
+	rows, err := repo.query(`SELECT
+		vt.track_num, vt.title, vt.performer, vt.isrc, vt.start_sample, vt.end_sample, vt.sample_rate
+		FROM virtual_track vt
+		JOIN fs_file f ON f.id = vt.fs_file_id
+		WHERE f.root_id = ? AND f.fs_name = ?
+		ORDER BY vt.track_num`,
+		rootId,
+		relName,
+	)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
+
+	result := []file.VirtualTrack{}
+	for rows.Next() {
+		track := file.VirtualTrack{Source: source}
+		if err := rows.Scan(
+			&track.TrackNum,
+			&track.Title,
+			&track.Performer,
+			&track.Isrc,
+			&track.StartSample,
+			&track.EndSample,
+			&track.SampleRate,
+		); err != nil {
+			return nil, err
+		}
+		result = append(result, track)
+	}
+
+	return result, rows.Err()
+}
+
+func (repo *Repo) query(query string, args ...any) (*sql.Rows, error) {
+	return repo.db.Query(repo.dialect.rebind(query), args...)
 }
@@ -0,0 +1,297 @@
+package repo
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wetfloo/voidh/ignore"
+)
+
+// How files of a root are hashed. Hashes of files hashed differently never match,
+// so the same file in two roots with different algorithms isn't a duplicate
+type HashAlgo string
+
+const (
+	HashSha1   HashAlgo = "sha1"
+	HashSha256 HashAlgo = "sha256"
+)
+
+var UnknownHashErr = fmt.Errorf("unknown hash algorithm")
+
+// Returned by [Store.AddRoot] for roots inside of another root, or with another root inside
+var RootOverlapErr = fmt.Errorf("root overlaps with another one")
+
+// Directory of the library, along with how its files are indexed
+type Root struct {
+	// Absolute and clean. Names of the files inside are stored relative to it
+	Path string
+	// Files of the root are never written to, e.g. never replaced with links to the content-addressable store
+	ReadOnly bool
+	// Rules of files to leave out, in the syntax of .gitignore, relative to the root. The ones
+	// without a slash match names at any depth, like *.log
+	Ignore []string
+	Hash   HashAlgo
+	// Files are only indexed by their contents if unset, without tags, properties, virtual tracks and pictures
+	ParseTags bool
+}
+
+func DefaultRoot(path string) Root {
+	return Root{
+		Path:      path,
+		Ignore:    []string{},
+		Hash:      HashSha1,
+		ParseTags: true,
+	}
+}
+
+func (algo HashAlgo) Hasher() (hash.Hash, error) {
+	switch algo {
+	case HashSha1:
+		return sha1.New(), nil
+	case HashSha256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("%q: %w", algo, UnknownHashErr)
+}
+
+// Name of the root directory with the trailing separator, which names of its files go after
+func rootPrefix(path string) string {
+	if strings.HasSuffix(path, string(filepath.Separator)) {
+		return path
+	}
+	return path + string(filepath.Separator)
+}
+
+func rootValidate(root Root) error {
+	if !filepath.IsAbs(root.Path) || filepath.Clean(root.Path) != root.Path {
+		return fmt.Errorf("root %s must be an absolute and clean path", root.Path)
+	}
+	if _, err := root.Hash.Hasher(); err != nil {
+		return err
+	}
+	// Same rules as the watcher goes by, malformed ones would only be skipped there
+	if _, errs := ignore.Parse(root.Ignore); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Fails with [RootOverlapErr] if the root is inside one of the others, or the other way around.
+// The one at the same path is fine, it's the one being updated
+func rootsOverlap(roots []Root, root Root) error {
+	prefix := rootPrefix(root.Path)
+	for _, other := range roots {
+		otherPrefix := rootPrefix(other.Path)
+		if otherPrefix != prefix && (strings.HasPrefix(prefix, otherPrefix) || strings.HasPrefix(otherPrefix, prefix)) {
+			return fmt.Errorf("%s and %s: %w", root.Path, other.Path, RootOverlapErr)
+		}
+	}
+	return nil
+}
+
+// Root holding the file, if any
+func rootOf(roots []Root, name string) (Root, bool) {
+	for _, root := range roots {
+		if strings.HasPrefix(name, rootPrefix(root.Path)) {
+			return root, true
+		}
+	}
+	return Root{}, false
+}
+
+// Roots of the library, ordered by path
+func (repo *Repo) Roots() ([]Root, error) {
+	rows, err := repo.query(`SELECT prefix, read_only, ignore_patterns, hash_algo, parse_tags
+		FROM root WHERE id != 0 ORDER BY prefix`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []Root{}
+	for rows.Next() {
+		var root Root
+		var prefix, ignore string
+		if err := rows.Scan(&prefix, &root.ReadOnly, &ignore, &root.Hash, &root.ParseTags); err != nil {
+			return nil, err
+		}
+		root.Path = filepath.Clean(prefix)
+		root.Ignore = strings.FieldsFunc(ignore, func(r rune) bool { return r == '\n' })
+		result = append(result, root)
+	}
+	return result, rows.Err()
+}
+
+// Adds the root, or updates the settings of the one with the same path. Files
+// already in the library under its path become its files
+func (repo *Repo) AddRoot(root Root) error {
+	if err := rootValidate(root); err != nil {
+		return err
+	}
+	roots, err := repo.Roots()
+	if err != nil {
+		return err
+	}
+	if err := rootsOverlap(roots, root); err != nil {
+		return err
+	}
+
+	prefix := rootPrefix(root.Path)
+	return repo.txRun(SourceUser, func(tx *sqlTx) error {
+		var id int64
+		if err := tx.QueryRow(
+			`INSERT INTO root(prefix, read_only, ignore_patterns, hash_algo, parse_tags) VALUES(?, ?, ?, ?, ?)
+			ON CONFLICT(prefix) DO UPDATE SET read_only = excluded.read_only, ignore_patterns = excluded.ignore_patterns,
+				hash_algo = excluded.hash_algo, parse_tags = excluded.parse_tags
+			RETURNING id`,
+			prefix,
+			root.ReadOnly,
+			strings.Join(root.Ignore, "\n"),
+			root.Hash,
+			root.ParseTags,
+		).Scan(&id); err != nil {
+			return err
+		}
+
+		// Files outside of any root are stored with their full names, which are cut down now.
+		// Lengths are in characters, same as in the string functions of both databases
+		prefixLen := utf8.RuneCountInString(prefix)
+		if _, err := tx.Exec(
+			"UPDATE fs_file SET root_id = ?, fs_name = substr(fs_name, ?) WHERE root_id = 0 AND substr(fs_name, 1, ?) = ?",
+			id,
+			prefixLen+1,
+			prefixLen,
+			prefix,
+		); err != nil {
+			return err
+		}
+		// Same for album directories, the root itself included, which is the empty one
+		_, err := tx.Exec(
+			`UPDATE album_picture SET root_id = ?, album_dir = CASE WHEN album_dir = ? THEN '' ELSE substr(album_dir, ?) END
+			WHERE root_id = 0 AND (album_dir = ? OR substr(album_dir, 1, ?) = ?)`,
+			id,
+			root.Path,
+			prefixLen+1,
+			root.Path,
+			prefixLen,
+			prefix,
+		)
+		return err
+	})
+}
+
+// Removes the root, along with all of its files, the pictures of its albums, and pictures nothing else links to
+func (repo *Repo) RemoveRoot(path string) error {
+	return repo.txRun(SourceUser, func(tx *sqlTx) error {
+		if err := tx.Delete(Under(path)); err != nil {
+			return err
+		}
+		prefix := rootPrefix(path)
+		if _, err := tx.Exec(
+			"DELETE FROM quarantine WHERE substr(name, 1, ?) = ?",
+			utf8.RuneCountInString(prefix),
+			prefix,
+		); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"DELETE FROM album_picture WHERE root_id IN (SELECT id FROM root WHERE prefix = ? AND id != 0)",
+			prefix,
+		); err != nil {
+			return err
+		}
+		if err := tx.picturesPrune(); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM root WHERE prefix = ? AND id != 0", prefix)
+		return err
+	})
+}
+
+// Finds the root the file belongs to, by the longest prefix of its name. Root 0 has
+// an empty one, it holds files outside of any root, with their full names
+const rootSplitQuery = `SELECT id, prefix FROM root
+	WHERE substr(?, 1, length(prefix)) = prefix
+	ORDER BY length(prefix) DESC LIMIT 1`
+
+// Id of the root the file belongs to, and its name relative to the root, from the row of [rootSplitQuery]
+func rootSplit(row *sql.Row, name string) (int64, string, error) {
+	var id int64
+	var prefix string
+	if err := row.Scan(&id, &prefix); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, name, nil
+		}
+		return 0, "", err
+	}
+	return id, name[len(prefix):], nil
+}
+
+func (tx *sqlTx) rootSplit(name string) (int64, string, error) {
+	return rootSplit(tx.QueryRow(rootSplitQuery, name), name)
+}
+
+func (repo *Repo) rootSplit(name string) (int64, string, error) {
+	return rootSplit(repo.db.QueryRow(repo.dialect.rebind(rootSplitQuery), name), name)
+}
+
+func (mem *Memory) Roots() ([]Root, error) {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	result := slices.Clone(mem.roots)
+	slices.SortFunc(result, func(a Root, b Root) int {
+		return strings.Compare(rootPrefix(a.Path), rootPrefix(b.Path))
+	})
+	return result, nil
+}
+
+func (mem *Memory) AddRoot(root Root) error {
+	if err := rootValidate(root); err != nil {
+		return err
+	}
+
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	if err := rootsOverlap(mem.roots, root); err != nil {
+		return err
+	}
+	root.Ignore = slices.Clone(root.Ignore)
+	for i, other := range mem.roots {
+		if other.Path == root.Path {
+			mem.roots[i] = root
+			return nil
+		}
+	}
+	mem.roots = append(mem.roots, root)
+	return nil
+}
+
+func (mem *Memory) RemoveRoot(path string) error {
+	mem.mu.Lock()
+	defer mem.mu.Unlock()
+
+	for _, rec := range mem.match(Under(path)) {
+		delete(mem.files, rec.fsFile.Name)
+		mem.eventLog(rec.fsFile.Name, rec.fsFile.Hash, "", nil)
+	}
+	mem.roots = slices.DeleteFunc(mem.roots, func(root Root) bool { return root.Path == path })
+	mem.quarantine = slices.DeleteFunc(mem.quarantine, func(q Quarantined) bool {
+		return strings.HasPrefix(q.Name, rootPrefix(path))
+	})
+	for dir := range mem.albumPictures {
+		if dir == path || strings.HasPrefix(dir, rootPrefix(path)) {
+			delete(mem.albumPictures, dir)
+		}
+	}
+	return nil
+}
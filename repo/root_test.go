@@ -0,0 +1,128 @@
+package repo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+)
+
+func TestRoots(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Files indexed before their root was added become its files
+			assert.Nil(t, store.UpsertAudioFile(testAudioFile("/music/a/1.flac", "One", "1")))
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/other/2.flac", Hash: []byte{2}}))
+			cover := []file.PictureLink{{Picture: file.Picture{Hash: []byte{9}, MimeType: "image/jpeg"}, PicType: 3}}
+			assert.Nil(t, store.ReplaceAlbumPictures("/music/a", cover))
+			assert.Nil(t, store.ReplaceAlbumPictures("/music/a/Album", cover))
+			assert.Nil(t, store.AddRoot(DefaultRoot("/music/a")))
+			// Pictures of albums in the root, and of the root itself, become its as well
+			pics, err := store.AlbumPictures("/music/a")
+			assert.Nil(t, err)
+			assert.Equal(t, cover, pics)
+			pics, err = store.AlbumPictures("/music/a/Album")
+			assert.Nil(t, err)
+			assert.Equal(t, cover, pics)
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/music/a/3.flac", Hash: []byte{3}}))
+
+			af, err := store.GetByPath("/music/a/1.flac")
+			assert.Nil(t, err)
+			assert.Equal(t, "/music/a/1.flac", af.FsFile.Name)
+			assert.Equal(t, []string{"/music/a/1.flac", "/music/a/3.flac", "/other/2.flac"}, testFindNames(t, store, Query{
+				Order: []Order{{Key: Filename{}}},
+			}))
+			assert.Equal(t, []string{"/music/a/3.flac"}, testFindNames(t, store, Query{Where: Eq(Filename{}, "/music/a/3.flac")}))
+
+			// Moved out of the root
+			assert.Nil(t, store.Update(Eq(Filename{}, "/music/a/3.flac"), file.FsFile{Name: "/other/3.flac", Hash: []byte{3}}))
+			assert.Equal(t, []string{"/other/3.flac"}, testFindNames(t, store, Query{Where: Eq(Hash{}, []byte{3})}))
+
+			err = store.AddRoot(DefaultRoot("/music"))
+			assert.True(t, errors.Is(err, RootOverlapErr))
+			err = store.AddRoot(Root{Path: "/music/b", Hash: "md5"})
+			assert.True(t, errors.Is(err, UnknownHashErr))
+			assert.NotNil(t, store.AddRoot(DefaultRoot("music/b")))
+			malformed := DefaultRoot("/music/b")
+			malformed.Ignore = []string{"*.log", "[unterminated"}
+			assert.NotNil(t, store.AddRoot(malformed))
+
+			updated := DefaultRoot("/music/a")
+			updated.ReadOnly = true
+			updated.Ignore = []string{"*.log", "!keep.log", "**/scans/"}
+			assert.Nil(t, store.AddRoot(updated))
+			assert.Nil(t, store.AddRoot(DefaultRoot("/music/b")))
+			roots, err := store.Roots()
+			assert.Nil(t, err)
+			assert.Equal(t, []Root{updated, DefaultRoot("/music/b")}, roots)
+
+			assert.Nil(t, store.RemoveRoot("/music/a"))
+			assert.Equal(t, []string{"/other/2.flac", "/other/3.flac"}, testFindNames(t, store, Query{
+				Order: []Order{{Key: Filename{}}},
+			}))
+			pics, err = store.AlbumPictures("/music/a/Album")
+			assert.Nil(t, err)
+			assert.Empty(t, pics)
+			roots, err = store.Roots()
+			assert.Nil(t, err)
+			assert.Equal(t, []Root{DefaultRoot("/music/b")}, roots)
+		})
+	}
+}
+
+func TestRootRelativeNames(t *testing.T) {
+	repo := testRepoInit(t)
+	assert.Nil(t, repo.AddRoot(DefaultRoot("/music")))
+	assert.Nil(t, repo.Insert(file.FsFile{Name: "/music/1.flac", Hash: []byte{1}}))
+
+	// Moving the whole library only takes changing its root
+	_, err := repo.db.Exec("UPDATE root SET prefix = '/mnt/music/' WHERE prefix = '/music/'")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/mnt/music/1.flac"}, testFindNames(t, &repo, Query{}))
+	af, err := repo.GetByPath("/mnt/music/1.flac")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, af.FsFile.Hash)
+
+	// Album pictures go along, and away with the root, along with the pictures nothing else links to
+	cover := []file.PictureLink{{Picture: file.Picture{Hash: []byte{9}, MimeType: "image/jpeg"}, PicType: 3}}
+	assert.Nil(t, repo.ReplaceAlbumPictures("/mnt/music/Album", cover))
+	_, err = repo.db.Exec("UPDATE root SET prefix = '/music/' WHERE prefix = '/mnt/music/'")
+	assert.Nil(t, err)
+	pics, err := repo.AlbumPictures("/music/Album")
+	assert.Nil(t, err)
+	assert.Equal(t, cover, pics)
+	assert.Nil(t, repo.RemoveRoot("/music"))
+	var count int
+	assert.Nil(t, repo.db.QueryRow("SELECT count(*) FROM picture").Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+func TestMoveAlbumPictures(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, store.AddRoot(DefaultRoot("/music")))
+			assert.Nil(t, store.AddRoot(DefaultRoot("/other")))
+			cover := []file.PictureLink{{Picture: file.Picture{Hash: []byte{1}, MimeType: "image/jpeg"}, PicType: 3}}
+			back := []file.PictureLink{{Picture: file.Picture{Hash: []byte{2}, MimeType: "image/png"}, PicType: 4}}
+			assert.Nil(t, store.ReplaceAlbumPictures("/music/Album", cover))
+			assert.Nil(t, store.ReplaceAlbumPictures("/music/Album/Disc 1", back))
+			assert.Nil(t, store.ReplaceAlbumPictures("/music/Albums", back))
+			assert.Nil(t, store.ReplaceAlbumPictures("/other/Moved", back))
+
+			// Into another root, replacing whatever was there
+			assert.Nil(t, store.MoveAlbumPictures("/music/Album", "/other/Moved"))
+			for dir, expected := range map[string][]file.PictureLink{
+				"/music/Album":        {},
+				"/music/Album/Disc 1": {},
+				"/music/Albums":       back,
+				"/other/Moved":        cover,
+				"/other/Moved/Disc 1": back,
+			} {
+				pics, err := store.AlbumPictures(dir)
+				assert.Nil(t, err)
+				assert.Equal(t, expected, pics, dir)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package repo
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Returned by [Repo.Search] when the binary is built without the sqlite_fts5 tag
+var SearchUnsupportedErr = fmt.Errorf("full-text search is not supported by this build, rebuild it with -tags sqlite_fts5")
+
+// Names usable for field scoping in search queries, like artist:beatles, mapped to the indexed columns
+var searchFields = map[string]string{
+	"title":  "title",
+	"artist": "artist",
+	"album":  "album",
+	"tag":    "tags",
+}
+
+// Letters with diacritics and what they fold to, for databases that can't fold them
+// themselves. Covers Latin-1 and Latin Extended-A, which is what music tags mostly use
+var searchFoldFrom, searchFoldTo = func() (string, string) {
+	pairs := [][2]string{
+		{"àáâãäåāăą", "a"}, {"çćĉċč", "c"}, {"ďđ", "d"}, {"èéêëēĕėęě", "e"},
+		{"ĝğġģ", "g"}, {"ĥħ", "h"}, {"ìíîïĩīĭįı", "i"}, {"ĵ", "j"}, {"ķ", "k"},
+		{"ĺļľŀł", "l"}, {"ñńņňŉ", "n"}, {"òóôõöøōŏő", "o"}, {"ŕŗř", "r"},
+		{"śŝşš", "s"}, {"ţťŧ", "t"}, {"ùúûüũūŭůűų", "u"}, {"ŵ", "w"},
+		{"ýÿŷ", "y"}, {"źżž", "z"},
+	}
+	var from, to strings.Builder
+	for _, pair := range pairs {
+		for _, r := range pair[0] {
+			from.WriteRune(r)
+			to.WriteString(pair[1])
+		}
+	}
+	return from.String(), to.String()
+}()
+
+var searchFoldReplacer = func() *strings.Replacer {
+	oldnew := []string{}
+	to := []rune(searchFoldTo)
+	for i, r := range []rune(searchFoldFrom) {
+		oldnew = append(oldnew, string(r), string(to[i]))
+	}
+	return strings.NewReplacer(oldnew...)
+}()
+
+type SearchHit struct {
+	Name  string
+	Title string
+	// The lower, the better. Hits are already sorted by it
+	Rank float64
+}
+
+type SearchSyntaxErr struct {
+	Query string
+	Msg   string
+}
+
+func (err SearchSyntaxErr) Error() string {
+	return fmt.Sprintf("invalid search query %q: %s", err.Query, err.Msg)
+}
+
+// Single word or phrase of a search query. Empty column means any of them
+type searchTerm struct {
+	column string
+	text   string
+}
+
+// Finds tracks by their tags, best matches first. Terms are separated by spaces,
+// and all of them must match. Double quotes group words into a phrase, a field name
+// followed by a colon limits the term to that field, like artist:beatles.
+// Every term matches as a prefix, diacritics are ignored
+func (repo *Repo) Search(query string) ([]SearchHit, error) {
+	terms, err := searchTermsParse(query)
+	if err != nil || len(terms) == 0 {
+		return []SearchHit{}, err
+	}
+	return repo.dialect.search(repo.db, terms)
+}
+
+func searchTermsParse(query string) ([]searchTerm, error) {
+	result := []searchTerm{}
+
+	rest := []rune(strings.TrimSpace(query))
+	for len(rest) > 0 {
+		if unicode.IsSpace(rest[0]) {
+			rest = rest[1:]
+			continue
+		}
+
+		var term searchTerm
+		if i := searchFieldEnd(rest); i > 0 {
+			if c, ok := searchFields[strings.ToLower(string(rest[:i]))]; ok {
+				term.column = c
+				rest = rest[i+1:]
+			}
+		}
+
+		if len(rest) > 0 && rest[0] == '"' {
+			end := -1
+			for i := 1; i < len(rest); i += 1 {
+				if rest[i] == '"' {
+					end = i
+					break
+				}
+			}
+			if end < 0 {
+				return result, SearchSyntaxErr{Query: query, Msg: "phrase is not closed"}
+			}
+			term.text = string(rest[1:end])
+			rest = rest[end+1:]
+		} else {
+			end := len(rest)
+			for i, r := range rest {
+				if unicode.IsSpace(r) {
+					end = i
+					break
+				}
+			}
+			term.text = string(rest[:end])
+			rest = rest[end:]
+		}
+
+		if strings.TrimSpace(term.text) == "" {
+			if term.column != "" {
+				return result, SearchSyntaxErr{Query: query, Msg: fmt.Sprintf("nothing to search for in %s", term.column)}
+			}
+			continue
+		}
+		result = append(result, term)
+	}
+
+	return result, nil
+}
+
+// Translates parsed terms into an FTS5 expression
+func searchExprBuild(terms []searchTerm) string {
+	exprs := []string{}
+	for _, term := range terms {
+		// Everything is quoted, so that FTS5 operators and punctuation in the query are taken literally
+		expr := `"` + strings.ReplaceAll(term.text, `"`, `""`) + `"*`
+		if term.column != "" {
+			expr = term.column + " : " + expr
+		}
+		exprs = append(exprs, expr)
+	}
+	return strings.Join(exprs, " AND ")
+}
+
+// Position of the colon ending a field name at the start of the term, or -1 if there's none
+func searchFieldEnd(term []rune) int {
+	for i, r := range term {
+		switch {
+		case r == ':':
+			return i
+		case !unicode.IsLetter(r):
+			return -1
+		}
+	}
+	return -1
+}
+
+// Lowercases the text and strips diacritics off of it
+func searchFold(s string) string {
+	return searchFoldReplacer.Replace(strings.ToLower(s))
+}
+
+// Splits folded text into words, the way full-text indexes do
+func searchWords(s string) []string {
+	return strings.FieldsFunc(searchFold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,102 @@
+//go:build sqlite_fts5
+
+package repo
+
+import (
+	"database/sql"
+)
+
+// Created outside of migrations, since builds without FTS5 can't even parse it.
+// Rows are keyed by track id. Diacritics are folded on both sides, so "bjork" finds "Björk"
+const searchSchema = `CREATE VIRTUAL TABLE track_search USING fts5(
+	title, artist, album, tags,
+	tokenize = 'unicode61 remove_diacritics 2',
+	prefix = '2 3'
+);
+
+CREATE TRIGGER track_search_delete AFTER DELETE ON track BEGIN
+	DELETE FROM track_search WHERE rowid = old.id;
+END;`
+
+// Everything worth searching for about the track, artists of all roles and album artists included
+const searchRowQuery = `INSERT INTO track_search(rowid, title, artist, album, tags)
+	SELECT t.id, t.title,
+		COALESCE((SELECT group_concat(a.name, ' ') FROM (
+			SELECT artist_id FROM track_artist WHERE track_id = t.id
+			UNION SELECT artist_id FROM album_artist WHERE album_id = t.album_id
+		) credit JOIN artist a ON a.id = credit.artist_id), ''),
+		COALESCE(al.title, ''),
+		COALESCE((SELECT group_concat(tg.value, ' ') FROM tag tg WHERE tg.track_id = t.id), '')
+	FROM track t
+	LEFT JOIN album al ON al.id = t.album_id`
+
+// Creates the search index if it's missing, and fills it with tracks that are already there
+func searchInit(db *sql.DB) error {
+	var exists bool
+	if err := db.QueryRow(
+		"SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'track_search'",
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(searchSchema); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(searchRowQuery); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Refreshes the indexed contents of the track, and of the other tracks of its album,
+// since album artists are shared by all of them
+func searchIndex(tx *sqlTx, trackId int64, albumId *int64) error {
+	if _, err := tx.Exec(
+		"DELETE FROM track_search WHERE rowid IN (SELECT id FROM track WHERE id = ? OR album_id = ?)",
+		trackId,
+		albumId,
+	); err != nil {
+		return err
+	}
+	_, err := tx.Exec(searchRowQuery+" WHERE t.id = ? OR t.album_id = ?", trackId, albumId)
+	return err
+}
+
+func search(db *sql.DB, terms []searchTerm) ([]SearchHit, error) {
+	result := []SearchHit{}
+
+	// Title and artist matches are worth more than an album one, which is worth more than some random tag
+	rows, err := db.Query(`SELECT `+filenameExpr+`, t.title, bm25(track_search, 10.0, 8.0, 5.0, 1.0) AS score
+		FROM track_search
+		JOIN track t ON t.id = track_search.rowid
+		JOIN fs_file f ON f.id = t.fs_file_id
+		JOIN root r ON r.id = f.root_id
+		WHERE track_search MATCH ?
+		ORDER BY score`,
+		searchExprBuild(terms),
+	)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hit SearchHit
+		if err := rows.Scan(&hit.Name, &hit.Title, &hit.Rank); err != nil {
+			return result, err
+		}
+		result = append(result, hit)
+	}
+
+	return result, rows.Err()
+}
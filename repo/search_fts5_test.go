@@ -0,0 +1,45 @@
+//go:build sqlite_fts5
+
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+)
+
+func TestSearch(t *testing.T) {
+	repo := testRepoInit(t)
+
+	one := testAudioFile("/music/1.flac", "Jóga", "1")
+	one.Tags = append(one.Tags, file.Tag{Key: file.TagGenre, Value: "Electronic"})
+	assert.Nil(t, repo.UpsertAudioFile(one))
+	two := testAudioFile("/music/2.flac", "Hunter", "2")
+	two.Tags[1].Value = "Björk"
+	assert.Nil(t, repo.UpsertAudioFile(two))
+
+	hits, err := repo.Search("jog")
+	assert.Nil(t, err)
+	assert.Len(t, hits, 1)
+	assert.Equal(t, "/music/1.flac", hits[0].Name)
+
+	hits, err = repo.Search("artist:bjork")
+	assert.Nil(t, err)
+	assert.Len(t, hits, 1)
+	assert.Equal(t, "Hunter", hits[0].Title)
+
+	// Album artist is shared, so both tracks are found
+	hits, err = repo.Search("artist:first album")
+	assert.Nil(t, err)
+	assert.Len(t, hits, 2)
+
+	hits, err = repo.Search("tag:electro")
+	assert.Nil(t, err)
+	assert.Len(t, hits, 1)
+
+	assert.Nil(t, repo.Delete(Eq(Filename{}, "/music/1.flac")))
+	hits, err = repo.Search("jóga")
+	assert.Nil(t, err)
+	assert.Len(t, hits, 0)
+}
@@ -0,0 +1,32 @@
+//go:build !sqlite_fts5
+
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// There's no index to keep, only search fails. Databases that have one, made by a build that
+// can search, are refused: this one can't keep it up to date, nor delete tracks it has rows for
+func searchInit(db *sql.DB) error {
+	var exists bool
+	if err := db.QueryRow(
+		"SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'track_search'",
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the database has a full-text search index this build can't keep up to date, rebuild it with -tags sqlite_fts5")
+	}
+	return nil
+}
+
+func searchIndex(_ *sqlTx, _ int64, _ *int64) error {
+	return nil
+}
+
+// Always fails with [SearchUnsupportedErr], build with -tags sqlite_fts5 to get the real one
+func search(_ *sql.DB, _ []searchTerm) ([]SearchHit, error) {
+	return []SearchHit{}, SearchUnsupportedErr
+}
@@ -0,0 +1,27 @@
+//go:build !sqlite_fts5
+
+package repo
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	repo, err := Init(Config{DatabasePath: path})
+	assert.Nil(t, err)
+	_, err = repo.Search("anything")
+	assert.True(t, errors.Is(err, SearchUnsupportedErr))
+
+	// Made by a build that can search, which is refused
+	_, err = repo.db.Exec("CREATE TABLE track_search (title TEXT)")
+	assert.Nil(t, err)
+	repo.Close()
+	_, err = Init(Config{DatabasePath: path})
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, SearchUnsupportedErr))
+}
@@ -0,0 +1,36 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSearchExprBuild(t *testing.T, query string) string {
+	terms, err := searchTermsParse(query)
+	assert.Nil(t, err)
+	return searchExprBuild(terms)
+}
+
+func TestSearchExprBuild(t *testing.T) {
+	assert.Equal(
+		t,
+		`artist : "beatles"* AND "abbey road"* AND "NOT"*`,
+		testSearchExprBuild(t, `  artist:beatles "abbey road" NOT`),
+	)
+	assert.Equal(
+		t,
+		`album : "let it be"* AND "ac/dc"* AND "say:""hi"""*`,
+		testSearchExprBuild(t, `Album:"let it be" ac/dc say:"hi"`),
+	)
+	assert.Equal(t, "", testSearchExprBuild(t, "   "))
+
+	_, err := searchTermsParse(`"unclosed`)
+	assert.IsType(t, SearchSyntaxErr{}, err)
+	_, err = searchTermsParse(`artist: beatles`)
+	assert.IsType(t, SearchSyntaxErr{}, err)
+}
+
+func TestSearchWords(t *testing.T) {
+	assert.Equal(t, []string{"bjork", "joga", "sigur", "ros"}, searchWords("Björk: Jóga / Sigur Rós"))
+}
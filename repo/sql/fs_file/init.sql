@@ -1,5 +0,0 @@
-CREATE TABLE IF NOT EXISTS fs_file (
-    id INTEGER NOT NULL PRIMARY KEY,
-    fs_name TEXT NOT NULL,
-    sha1 BLOB NOT NULL
-) STRICT;
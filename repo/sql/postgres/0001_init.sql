@@ -0,0 +1,133 @@
+-- Same schema as the SQLite one as of its 0003_file_stat. Times are in Unix milliseconds
+CREATE TABLE fs_file (
+    id BIGSERIAL PRIMARY KEY,
+    fs_name TEXT NOT NULL UNIQUE,
+    sha1 BYTEA NOT NULL,
+    size BIGINT,
+    mtime BIGINT,
+    added_at BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX fs_file_sha1 ON fs_file(sha1);
+CREATE INDEX fs_file_size ON fs_file(size);
+CREATE INDEX fs_file_mtime ON fs_file(mtime);
+CREATE INDEX fs_file_added_at ON fs_file(added_at);
+
+CREATE TABLE virtual_track (
+    id BIGSERIAL PRIMARY KEY,
+    fs_file_id BIGINT NOT NULL REFERENCES fs_file(id) ON DELETE CASCADE,
+    track_num INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    performer TEXT NOT NULL,
+    isrc TEXT NOT NULL,
+    start_sample BIGINT NOT NULL,
+    end_sample BIGINT NOT NULL,
+    sample_rate BIGINT NOT NULL,
+    UNIQUE (fs_file_id, track_num)
+);
+
+CREATE TABLE picture (
+    id BIGSERIAL PRIMARY KEY,
+    sha1 BYTEA NOT NULL UNIQUE,
+    mime_type TEXT NOT NULL,
+    width BIGINT NOT NULL,
+    height BIGINT NOT NULL,
+    size BIGINT NOT NULL
+);
+
+CREATE TABLE fs_file_picture (
+    fs_file_id BIGINT NOT NULL REFERENCES fs_file(id) ON DELETE CASCADE,
+    picture_id BIGINT NOT NULL REFERENCES picture(id),
+    pic_type BIGINT NOT NULL,
+    description TEXT NOT NULL,
+    PRIMARY KEY (fs_file_id, picture_id, pic_type)
+);
+
+CREATE TABLE album_picture (
+    album_dir TEXT NOT NULL,
+    picture_id BIGINT NOT NULL REFERENCES picture(id),
+    pic_type BIGINT NOT NULL,
+    description TEXT NOT NULL,
+    PRIMARY KEY (album_dir, picture_id, pic_type)
+);
+
+CREATE TABLE artist (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE album (
+    id BIGSERIAL PRIMARY KEY,
+    title TEXT NOT NULL,
+    artist_credit TEXT NOT NULL,
+    date TEXT NOT NULL,
+    mb_album_id TEXT NOT NULL,
+    UNIQUE (title, artist_credit)
+);
+
+CREATE TABLE album_artist (
+    album_id BIGINT NOT NULL REFERENCES album(id) ON DELETE CASCADE,
+    artist_id BIGINT NOT NULL REFERENCES artist(id),
+    position INTEGER NOT NULL,
+    PRIMARY KEY (album_id, artist_id)
+);
+
+CREATE TABLE track (
+    id BIGSERIAL PRIMARY KEY,
+    fs_file_id BIGINT NOT NULL UNIQUE REFERENCES fs_file(id) ON DELETE CASCADE,
+    album_id BIGINT REFERENCES album(id),
+    title TEXT NOT NULL,
+    track_num BIGINT,
+    disc_num BIGINT,
+    date TEXT NOT NULL,
+    audio_hash BYTEA
+);
+
+CREATE INDEX track_album_id ON track(album_id);
+CREATE INDEX track_audio_hash ON track(audio_hash);
+CREATE INDEX track_title ON track(title);
+CREATE INDEX track_date ON track(date);
+
+CREATE TABLE track_artist (
+    track_id BIGINT NOT NULL REFERENCES track(id) ON DELETE CASCADE,
+    artist_id BIGINT NOT NULL REFERENCES artist(id),
+    role TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (track_id, artist_id, role)
+);
+
+CREATE INDEX track_artist_artist_id ON track_artist(artist_id);
+
+CREATE TABLE tag (
+    id BIGSERIAL PRIMARY KEY,
+    track_id BIGINT NOT NULL REFERENCES track(id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    position INTEGER NOT NULL
+);
+
+CREATE INDEX tag_track_id ON tag(track_id);
+CREATE INDEX tag_key_value ON tag(key, value);
+
+CREATE TABLE track_property (
+    track_id BIGINT NOT NULL PRIMARY KEY REFERENCES track(id) ON DELETE CASCADE,
+    codec TEXT NOT NULL,
+    sample_rate BIGINT NOT NULL,
+    channels INTEGER NOT NULL,
+    bits_per_sample INTEGER NOT NULL,
+    samples_total BIGINT NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    bitrate BIGINT NOT NULL
+);
+
+CREATE INDEX track_property_codec ON track_property(codec);
+CREATE INDEX track_property_sample_rate ON track_property(sample_rate);
+CREATE INDEX track_property_duration_ms ON track_property(duration_ms);
+
+-- Search document of the track, weighted A to D by title, artists, album and other tags
+CREATE TABLE track_search (
+    track_id BIGINT NOT NULL PRIMARY KEY REFERENCES track(id) ON DELETE CASCADE,
+    doc TSVECTOR NOT NULL
+);
+
+CREATE INDEX track_search_doc ON track_search USING GIN (doc);
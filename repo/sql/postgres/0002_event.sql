@@ -0,0 +1,24 @@
+-- Same as the SQLite 0004_event
+CREATE TABLE event (
+    id BIGSERIAL PRIMARY KEY,
+    at BIGINT NOT NULL,
+    kind TEXT NOT NULL,
+    source TEXT NOT NULL,
+    old_name TEXT,
+    new_name TEXT,
+    old_sha1 BYTEA,
+    new_sha1 BYTEA
+);
+
+CREATE INDEX event_at ON event(at);
+CREATE INDEX event_old_name ON event(old_name);
+CREATE INDEX event_new_name ON event(new_name);
+
+CREATE FUNCTION event_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'event log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER event_append_only BEFORE UPDATE OR DELETE ON event
+    FOR EACH ROW EXECUTE FUNCTION event_append_only();
@@ -0,0 +1,19 @@
+-- Names of files are stored relative to their root. Root 0 has an empty prefix, it holds files
+-- outside of any root, with their full names, which is what all files have had until now
+CREATE TABLE root (
+    id BIGSERIAL PRIMARY KEY,
+    -- Path of the root with the trailing separator
+    prefix TEXT NOT NULL UNIQUE,
+    read_only BOOLEAN NOT NULL DEFAULT FALSE,
+    -- Separated by newlines
+    ignore_patterns TEXT NOT NULL DEFAULT '',
+    hash_algo TEXT NOT NULL DEFAULT 'sha1',
+    parse_tags BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+INSERT INTO root(id, prefix) VALUES (0, '');
+
+ALTER TABLE fs_file ADD COLUMN root_id BIGINT NOT NULL DEFAULT 0 REFERENCES root(id);
+
+ALTER TABLE fs_file DROP CONSTRAINT fs_file_fs_name_key;
+CREATE UNIQUE INDEX fs_file_root_name ON fs_file(root_id, fs_name);
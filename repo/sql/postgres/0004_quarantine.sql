@@ -0,0 +1,9 @@
+-- Same as the SQLite 0006_quarantine
+CREATE TABLE quarantine (
+    name TEXT PRIMARY KEY,
+    size BIGINT NOT NULL,
+    mtime BIGINT NOT NULL,
+    reason TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    at BIGINT NOT NULL
+);
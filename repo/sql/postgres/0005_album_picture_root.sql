@@ -0,0 +1,11 @@
+-- Directories of album pictures are stored relative to their root, same as names of files, and go
+-- along with it when it's removed. The root directory itself is the empty one. Directories outside
+-- of any root stay in root 0 with their full names. The separator is the last character of a prefix
+ALTER TABLE album_picture ADD COLUMN root_id BIGINT NOT NULL DEFAULT 0 REFERENCES root(id);
+
+UPDATE album_picture ap SET root_id = r.id, album_dir = substr(ap.album_dir, length(r.prefix) + 1)
+FROM root r
+WHERE r.id != 0 AND substr(ap.album_dir || substr(r.prefix, length(r.prefix)), 1, length(r.prefix)) = r.prefix;
+
+ALTER TABLE album_picture DROP CONSTRAINT album_picture_pkey;
+ALTER TABLE album_picture ADD PRIMARY KEY (root_id, album_dir, picture_id, pic_type);
@@ -0,0 +1,44 @@
+-- Tables existed before migrations were introduced, so they're only created if missing
+CREATE TABLE IF NOT EXISTS fs_file (
+    id INTEGER NOT NULL PRIMARY KEY,
+    fs_name TEXT NOT NULL,
+    sha1 BLOB NOT NULL
+) STRICT;
+
+CREATE TABLE IF NOT EXISTS virtual_track (
+    id INTEGER NOT NULL PRIMARY KEY,
+    fs_file_id INTEGER NOT NULL REFERENCES fs_file(id) ON DELETE CASCADE,
+    track_num INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    performer TEXT NOT NULL,
+    isrc TEXT NOT NULL,
+    start_sample INTEGER NOT NULL,
+    end_sample INTEGER NOT NULL,
+    sample_rate INTEGER NOT NULL,
+    UNIQUE (fs_file_id, track_num)
+) STRICT;
+
+CREATE TABLE IF NOT EXISTS picture (
+    id INTEGER NOT NULL PRIMARY KEY,
+    sha1 BLOB NOT NULL UNIQUE,
+    mime_type TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    size INTEGER NOT NULL
+) STRICT;
+
+CREATE TABLE IF NOT EXISTS fs_file_picture (
+    fs_file_id INTEGER NOT NULL REFERENCES fs_file(id) ON DELETE CASCADE,
+    picture_id INTEGER NOT NULL REFERENCES picture(id),
+    pic_type INTEGER NOT NULL,
+    description TEXT NOT NULL,
+    PRIMARY KEY (fs_file_id, picture_id, pic_type)
+) STRICT;
+
+CREATE TABLE IF NOT EXISTS album_picture (
+    album_dir TEXT NOT NULL,
+    picture_id INTEGER NOT NULL REFERENCES picture(id),
+    pic_type INTEGER NOT NULL,
+    description TEXT NOT NULL,
+    PRIMARY KEY (album_dir, picture_id, pic_type)
+) STRICT;
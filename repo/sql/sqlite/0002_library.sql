@@ -0,0 +1,73 @@
+-- File names become unique, so audio files can be upserted by them. Only the latest row is kept
+DELETE FROM fs_file WHERE id NOT IN (SELECT MAX(id) FROM fs_file GROUP BY fs_name);
+CREATE UNIQUE INDEX fs_file_fs_name ON fs_file(fs_name);
+
+CREATE TABLE artist (
+    id INTEGER NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+) STRICT;
+
+CREATE TABLE album (
+    id INTEGER NOT NULL PRIMARY KEY,
+    title TEXT NOT NULL,
+    -- Album artists as displayed, part of the album identity along with the title
+    artist_credit TEXT NOT NULL,
+    date TEXT NOT NULL,
+    mb_album_id TEXT NOT NULL,
+    UNIQUE (title, artist_credit)
+) STRICT;
+
+CREATE TABLE album_artist (
+    album_id INTEGER NOT NULL REFERENCES album(id) ON DELETE CASCADE,
+    artist_id INTEGER NOT NULL REFERENCES artist(id),
+    position INTEGER NOT NULL,
+    PRIMARY KEY (album_id, artist_id)
+) STRICT;
+
+CREATE TABLE track (
+    id INTEGER NOT NULL PRIMARY KEY,
+    fs_file_id INTEGER NOT NULL UNIQUE REFERENCES fs_file(id) ON DELETE CASCADE,
+    album_id INTEGER REFERENCES album(id),
+    title TEXT NOT NULL,
+    track_num INTEGER,
+    disc_num INTEGER,
+    date TEXT NOT NULL,
+    audio_hash BLOB
+) STRICT;
+
+CREATE INDEX track_album_id ON track(album_id);
+CREATE INDEX track_audio_hash ON track(audio_hash);
+
+-- Credits of the track, like main artists, composers and conductors
+CREATE TABLE track_artist (
+    track_id INTEGER NOT NULL REFERENCES track(id) ON DELETE CASCADE,
+    artist_id INTEGER NOT NULL REFERENCES artist(id),
+    role TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (track_id, artist_id, role)
+) STRICT;
+
+CREATE INDEX track_artist_artist_id ON track_artist(artist_id);
+
+-- All tags of the track as they are, including the ones above
+CREATE TABLE tag (
+    id INTEGER NOT NULL PRIMARY KEY,
+    track_id INTEGER NOT NULL REFERENCES track(id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    position INTEGER NOT NULL
+) STRICT;
+
+CREATE INDEX tag_track_id ON tag(track_id);
+CREATE INDEX tag_key_value ON tag(key, value);
+
+CREATE TABLE track_property (
+    track_id INTEGER NOT NULL PRIMARY KEY REFERENCES track(id) ON DELETE CASCADE,
+    codec TEXT NOT NULL,
+    sample_rate INTEGER NOT NULL,
+    channels INTEGER NOT NULL,
+    bits_per_sample INTEGER NOT NULL,
+    samples_total INTEGER NOT NULL,
+    duration_ms INTEGER NOT NULL,
+    bitrate INTEGER NOT NULL
+) STRICT;
@@ -0,0 +1,16 @@
+-- Times are in Unix milliseconds. Size and modification time are unknown for files indexed before
+ALTER TABLE fs_file ADD COLUMN size INTEGER;
+ALTER TABLE fs_file ADD COLUMN mtime INTEGER;
+ALTER TABLE fs_file ADD COLUMN added_at INTEGER NOT NULL DEFAULT 0;
+UPDATE fs_file SET added_at = CAST(unixepoch('subsec') * 1000 AS INTEGER);
+
+CREATE INDEX fs_file_sha1 ON fs_file(sha1);
+CREATE INDEX fs_file_size ON fs_file(size);
+CREATE INDEX fs_file_mtime ON fs_file(mtime);
+CREATE INDEX fs_file_added_at ON fs_file(added_at);
+
+CREATE INDEX track_title ON track(title);
+CREATE INDEX track_date ON track(date);
+CREATE INDEX track_property_codec ON track_property(codec);
+CREATE INDEX track_property_sample_rate ON track_property(sample_rate);
+CREATE INDEX track_property_duration_ms ON track_property(duration_ms);
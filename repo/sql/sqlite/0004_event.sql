@@ -0,0 +1,26 @@
+-- Append-only log of changes made to the library. Names and hashes are kept as they
+-- were, so they outlive the files. Times are in Unix milliseconds
+CREATE TABLE event (
+    id INTEGER NOT NULL PRIMARY KEY,
+    at INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    source TEXT NOT NULL,
+    old_name TEXT,
+    new_name TEXT,
+    old_sha1 BLOB,
+    new_sha1 BLOB
+) STRICT;
+
+CREATE INDEX event_at ON event(at);
+CREATE INDEX event_old_name ON event(old_name);
+CREATE INDEX event_new_name ON event(new_name);
+
+CREATE TRIGGER event_no_update BEFORE UPDATE ON event
+BEGIN
+    SELECT RAISE(ABORT, 'event log is append-only');
+END;
+
+CREATE TRIGGER event_no_delete BEFORE DELETE ON event
+BEGIN
+    SELECT RAISE(ABORT, 'event log is append-only');
+END;
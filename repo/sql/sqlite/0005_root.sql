@@ -0,0 +1,21 @@
+-- Names of files are stored relative to their root. Root 0 has an empty prefix, it holds files
+-- outside of any root, with their full names, which is what all files have had until now
+CREATE TABLE root (
+    id INTEGER NOT NULL PRIMARY KEY,
+    -- Path of the root with the trailing separator
+    prefix TEXT NOT NULL UNIQUE,
+    read_only INTEGER NOT NULL DEFAULT 0,
+    -- Separated by newlines
+    ignore_patterns TEXT NOT NULL DEFAULT '',
+    hash_algo TEXT NOT NULL DEFAULT 'sha1',
+    parse_tags INTEGER NOT NULL DEFAULT 1
+) STRICT;
+
+INSERT INTO root(id, prefix) VALUES (0, '');
+
+-- SQLite can't add a column referencing another table with a default, the
+-- repo removes files of a root along with it instead of a foreign key
+ALTER TABLE fs_file ADD COLUMN root_id INTEGER NOT NULL DEFAULT 0;
+
+DROP INDEX fs_file_fs_name;
+CREATE UNIQUE INDEX fs_file_root_name ON fs_file(root_id, fs_name);
@@ -0,0 +1,11 @@
+-- Files the watcher has given up on, after failing to index them over and over. Names are
+-- full ones, size and modification time are the ones the file had then, it's tried again
+-- once they are different. Times are in Unix milliseconds
+CREATE TABLE quarantine (
+    name TEXT NOT NULL PRIMARY KEY,
+    size INTEGER NOT NULL,
+    mtime INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    at INTEGER NOT NULL
+) STRICT;
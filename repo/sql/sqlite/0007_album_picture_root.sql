@@ -0,0 +1,25 @@
+-- Directories of album pictures are stored relative to their root, same as names of files, and go
+-- along with it when it's removed. The root directory itself is the empty one. Directories outside
+-- of any root stay in root 0 with their full names. The separator is the last character of a prefix
+CREATE TABLE album_picture_root (
+    root_id INTEGER NOT NULL DEFAULT 0,
+    album_dir TEXT NOT NULL,
+    picture_id INTEGER NOT NULL REFERENCES picture(id),
+    pic_type INTEGER NOT NULL,
+    description TEXT NOT NULL,
+    PRIMARY KEY (root_id, album_dir, picture_id, pic_type)
+) STRICT;
+
+INSERT INTO album_picture_root(root_id, album_dir, picture_id, pic_type, description)
+SELECT
+    COALESCE(r.id, 0),
+    CASE WHEN r.id IS NULL THEN ap.album_dir ELSE substr(ap.album_dir, length(r.prefix) + 1) END,
+    ap.picture_id,
+    ap.pic_type,
+    ap.description
+FROM album_picture ap
+LEFT JOIN root r ON r.id != 0
+    AND substr(ap.album_dir || substr(r.prefix, length(r.prefix)), 1, length(r.prefix)) = r.prefix;
+
+DROP TABLE album_picture;
+ALTER TABLE album_picture_root RENAME TO album_picture;
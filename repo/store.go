@@ -0,0 +1,72 @@
+package repo
+
+import (
+	"fmt"
+	"iter"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+// Returned when a file asked for, or one that is a prerequisite, isn't in the library
+var NotFoundErr = fmt.Errorf("not found in the library")
+
+// Returned when the database is locked by someone else for longer than it's waited for.
+// Nothing is wrong with what was asked, it's likely to work when tried again later
+var BusyErr = fmt.Errorf("the database is busy")
+
+// Everything the library can do, no matter where it's stored. Implemented by
+// [Repo] for SQLite and PostgreSQL, and by [Memory] for tests
+type Store interface {
+	Tx
+	Close()
+
+	// Runs all writes made by fn in a single transaction, which is rolled back if fn fails
+	Batch(fn func(tx Tx) error) error
+	// Same as Batch, with the changes recorded as made by the given source
+	BatchFrom(source EventSource, fn func(tx Tx) error) error
+
+	Find(q Query) ([]file.FsFile, error)
+	// Fails with [NotFoundErr] if there's no such file
+	GetByPath(path string) (file.AudioFile, error)
+	// Same as GetByPath for all files matching the query, without a query per file
+	FindAudio(q Query) ([]file.AudioFile, error)
+	GetByHash(hash []byte) ([]file.FsFile, error)
+	Count(where Cond) (int, error)
+	ListPage(q Query, after Cursor) (Page, error)
+	List(q Query) iter.Seq2[file.FsFile, error]
+
+	// Events of the file that has, or last had, the given name, oldest first
+	History(path string) ([]Event, error)
+	// Events of the files of the tracks matching the condition, oldest first
+	TrackHistory(where Cond) ([]Event, error)
+	// Latest events of all files, newest first
+	RecentEvents(limit int) ([]Event, error)
+	// Finds tracks by their tags, best matches first
+	Search(query string) ([]SearchHit, error)
+	VirtualTracks(source string) ([]file.VirtualTrack, error)
+	FilePictures(fsName string) ([]file.PictureLink, error)
+	AlbumPictures(albumDir string) ([]file.PictureLink, error)
+
+	// Roots of the library, ordered by path
+	Roots() ([]Root, error)
+	// Adds the root, or updates the settings of the one with the same path. Fails
+	// with [RootOverlapErr] if it's inside of another root, or the other way around
+	AddRoot(root Root) error
+	// Removes the root, along with all of its files and the pictures of its albums
+	RemoveRoot(path string) error
+
+	// Files the watcher has given up on, ordered by name
+	Quarantined() ([]Quarantined, error)
+	// Fails with [NotFoundErr] if the file isn't in quarantine
+	QuarantinedGet(name string) (Quarantined, error)
+	// Puts the file in quarantine, or updates it if it's there already
+	Quarantine(q Quarantined) error
+	// Takes the file out of quarantine, or all files inside of it if it's a directory.
+	// Fails with [NotFoundErr] if there are none
+	Release(name string) error
+}
+
+var (
+	_ Store = (*Repo)(nil)
+	_ Store = (*Memory)(nil)
+)
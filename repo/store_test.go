@@ -0,0 +1,65 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Connection string of a PostgreSQL server to run store tests against, they are skipped for it if unset
+const testPostgresEnv = "VOIDH_TEST_POSTGRES"
+
+// All the stores to run the same tests against
+func testStores(t *testing.T) map[string]Store {
+	result := map[string]Store{
+		"sqlite": testRepoInitPtr(t),
+		"memory": NewMemory(),
+	}
+	if dsn := os.Getenv(testPostgresEnv); dsn != "" {
+		result["postgres"] = testPostgresInit(t, dsn)
+	}
+	return result
+}
+
+func testRepoInitPtr(t *testing.T) *Repo {
+	repo := testRepoInit(t)
+	return &repo
+}
+
+// Every test gets a schema of its own, dropped afterwards
+func testPostgresInit(t *testing.T, dsn string) *Repo {
+	repo, err := InitPostgres(PostgresConfig{Dsn: testPostgresSchema(t, dsn)})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(repo.Close)
+	return &repo
+}
+
+// Makes an empty schema, dropped after the test, and returns the DSN that uses it
+func testPostgresSchema(t *testing.T, dsn string) string {
+	schema := fmt.Sprintf("voidh_test_%d", time.Now().UnixNano())
+
+	admin, err := sql.Open("postgres", dsn)
+	assert.Nil(t, err)
+	_, err = admin.Exec("CREATE SCHEMA " + schema)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+
+	switch {
+	case strings.Contains(dsn, "://") && strings.Contains(dsn, "?"):
+		return dsn + "&search_path=" + schema
+	case strings.Contains(dsn, "://"):
+		return dsn + "?search_path=" + schema
+	default:
+		return dsn + " search_path=" + schema
+	}
+}
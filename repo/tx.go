@@ -0,0 +1,181 @@
+package repo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/wetfloo/voidh/file"
+)
+
+// Writes to the library. Every write made on a [Store] directly is a transaction
+// of its own, writes made on the Tx given by [Store.Batch] are committed together
+type Tx interface {
+	// Inserts the file, or updates the one with the same name
+	Insert(file file.FsFile) error
+	// Updates all files matching the condition
+	Update(where Cond, file file.FsFile) error
+	// Deletes all files matching the condition, along with everything that belongs to them
+	Delete(where Cond) error
+	// Inserts or updates the audio file along with its tags, album, artist credits and properties
+	UpsertAudioFile(af file.AudioFile) error
+	// Replaces all virtual tracks backed by the given physical file, which must already be inserted
+	ReplaceVirtualTracks(source string, tracks []file.VirtualTrack) error
+	// Replaces all pictures attached to the given file, which must already be inserted
+	ReplaceFilePictures(fsName string, pics []file.PictureLink) error
+	// Replaces all pictures attached to the album in the given directory
+	ReplaceAlbumPictures(albumDir string, pics []file.PictureLink) error
+	// Gives pictures of albums in the directory, and in the ones inside, the new directory, replacing the ones it had
+	MoveAlbumPictures(oldDir string, dir string) error
+}
+
+// Transaction taking queries with ? placeholders, no matter the database.
+// Statements are prepared once and reused until the transaction ends
+type sqlTx struct {
+	tx       *sql.Tx
+	dialect  dialect
+	prepared map[string]*sql.Stmt
+	source   EventSource
+}
+
+// Runs all writes made by fn in a single transaction, which is rolled back if fn
+// fails or panics. Grouping writes, like the ones of a whole scan, is much faster
+// than making them one by one
+func (repo *Repo) Batch(fn func(tx Tx) error) error {
+	return repo.BatchFrom(SourceUser, fn)
+}
+
+// Same as [Repo.Batch], with the changes recorded as made by the given source
+func (repo *Repo) BatchFrom(source EventSource, fn func(tx Tx) error) error {
+	return repo.txRun(source, func(tx *sqlTx) error {
+		return fn(tx)
+	})
+}
+
+func (repo *Repo) txRun(source EventSource, fn func(tx *sqlTx) error) error {
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return busyWrap(repo.dialect, err)
+	}
+	sqlTx := &sqlTx{tx: tx, dialect: repo.dialect, prepared: map[string]*sql.Stmt{}, source: source}
+	defer sqlTx.rollback()
+
+	if err := fn(sqlTx); err != nil {
+		return busyWrap(repo.dialect, err)
+	}
+	return busyWrap(repo.dialect, sqlTx.commit())
+}
+
+func (tx *sqlTx) stmt(query string) (*sql.Stmt, error) {
+	if stmt, ok := tx.prepared[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := tx.tx.Prepare(tx.dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	tx.prepared[query] = stmt
+	return stmt, nil
+}
+
+func (tx *sqlTx) Exec(query string, args ...any) (sql.Result, error) {
+	stmt, err := tx.stmt(query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Exec(args...)
+}
+
+func (tx *sqlTx) QueryRow(query string, args ...any) *sql.Row {
+	stmt, err := tx.stmt(query)
+	if err != nil {
+		// The error surfaces on Scan, same as with any other failed query
+		return tx.tx.QueryRow(tx.dialect.rebind(query), args...)
+	}
+	return stmt.QueryRow(args...)
+}
+
+func (tx *sqlTx) query(query string, args ...any) (*sql.Rows, error) {
+	return tx.tx.Query(tx.dialect.rebind(query), args...)
+}
+
+// Fails with [NotFoundErr] if there's no such file
+func (tx *sqlTx) fsFileId(name string) (int64, error) {
+	var result int64
+	rootId, relName, err := tx.rootSplit(name)
+	if err != nil {
+		return result, err
+	}
+	err = tx.QueryRow("SELECT id FROM fs_file WHERE root_id = ? AND fs_name = ?", rootId, relName).Scan(&result)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, fmt.Errorf("%s: %w", name, NotFoundErr)
+	}
+	return result, err
+}
+
+// Inserts the file or updates the one with the same name, returning its id
+func (tx *sqlTx) fsFileUpsert(f file.FsFile) (int64, error) {
+	rootId, relName, err := tx.rootSplit(f.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	oldName := ""
+	var oldHash []byte
+	err = tx.QueryRow("SELECT sha1 FROM fs_file WHERE root_id = ? AND fs_name = ?", rootId, relName).Scan(&oldHash)
+	if err == nil {
+		oldName = f.Name
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	var result int64
+	if err := tx.QueryRow(fsFileUpsertQuery, fsFileUpsertArgs(rootId, relName, f)...).Scan(&result); err != nil {
+		return 0, err
+	}
+	return result, tx.eventLog(oldName, oldHash, f.Name, f.Hash)
+}
+
+// Names and hashes of the files matching the condition
+func (tx *sqlTx) fsFilesMatch(where Cond) ([]file.FsFile, error) {
+	w, err := queryWriterMake(tx.dialect, where, tx.query)
+	if err != nil {
+		return nil, err
+	}
+	Query{Where: where}.selectWrite(&w, filenameExpr+", f.sha1")
+
+	rows, err := tx.query(w.sql.String(), w.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []file.FsFile{}
+	for rows.Next() {
+		var f file.FsFile
+		if err := rows.Scan(&f.Name, &f.Hash); err != nil {
+			return nil, err
+		}
+		result = append(result, f)
+	}
+	return result, rows.Err()
+}
+
+func (tx *sqlTx) commit() error {
+	tx.stmtsClose()
+	return tx.tx.Commit()
+}
+
+// No-op if the transaction is already committed
+func (tx *sqlTx) rollback() {
+	tx.stmtsClose()
+	tx.tx.Rollback()
+}
+
+func (tx *sqlTx) stmtsClose() {
+	for query, stmt := range tx.prepared {
+		stmt.Close()
+		delete(tx.prepared, query)
+	}
+}
@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+)
+
+func TestBatchCommits(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			err := store.Batch(func(tx Tx) error {
+				for i := range 500 {
+					if err := tx.Insert(file.FsFile{Name: fmt.Sprintf("/music/%03d.flac", i), Hash: []byte{byte(i)}}); err != nil {
+						return err
+					}
+				}
+				return tx.UpsertAudioFile(testAudioFile("/music/000.flac", "Zero", "0"))
+			})
+			assert.Nil(t, err)
+
+			assert.Len(t, testFindNames(t, store, Query{}), 500)
+			assert.Equal(t, []string{"/music/000.flac"}, testFindNames(t, store, Query{Where: Eq(Title{}, "Zero")}))
+		})
+	}
+}
+
+func TestBatchRollsBack(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, store.Insert(file.FsFile{Name: "/music/kept.flac", Hash: []byte{1}}))
+
+			failure := errors.New("failure")
+			err := store.Batch(func(tx Tx) error {
+				assert.Nil(t, tx.Insert(file.FsFile{Name: "/music/lost.flac", Hash: []byte{2}}))
+				assert.Nil(t, tx.Delete(Eq(Filename{}, "/music/kept.flac")))
+				return failure
+			})
+			assert.Equal(t, failure, err)
+			assert.Equal(t, []string{"/music/kept.flac"}, testFindNames(t, store, Query{}))
+
+			assert.Panics(t, func() {
+				store.Batch(func(tx Tx) error {
+					assert.Nil(t, tx.Insert(file.FsFile{Name: "/music/lost.flac", Hash: []byte{2}}))
+					panic("failure")
+				})
+			})
+			assert.Equal(t, []string{"/music/kept.flac"}, testFindNames(t, store, Query{}))
+		})
+	}
+}
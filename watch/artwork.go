@@ -0,0 +1,79 @@
+package watch
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/wetfloo/voidh/artwork"
+	"github.com/wetfloo/voidh/file"
+)
+
+// Caches pictures of a freshly created file. Sidecar pictures are read along with the other ones
+// of their directory, as they belong to its album, audio files have their embedded pictures read
+func artworkRead(cache artwork.Cache, read *fileRead) error {
+	name := read.fsFile.Name
+	sidecar := artwork.IsSidecar(name)
+	var embedded []artwork.Embedded
+	var err error
+	if sidecar {
+		embedded, err = artwork.Sidecars(filepath.Dir(name))
+	} else {
+		embedded, err = artwork.Extract(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	pics := []file.PictureLink{}
+	for _, pic := range embedded {
+		link, err := cache.Put(pic)
+		if err != nil {
+			return err
+		}
+		pics = append(pics, link)
+	}
+	// Left unset if anything fails, so that the pictures linked already aren't replaced with only some of them
+	read.pictures = pics
+	read.sidecar = sidecar
+	return nil
+}
+
+// Must be called with the lock held. Links the pictures of the file that has been read
+func (watch *Watch) artworkWrite(read fileRead) error {
+	if read.sidecar {
+		return watch.repo.ReplaceAlbumPictures(filepath.Dir(read.fsFile.Name), read.pictures)
+	}
+	if len(read.pictures) == 0 {
+		return nil
+	}
+	return watch.repo.ReplaceFilePictures(read.fsFile.Name, read.pictures)
+}
+
+// Must be called with the lock held. Brings the album in the directory of the sidecar picture that's gone up
+// to date. One of the sidecar pictures left is read again, off the event loop, which links all of them. Albums
+// without any left have their pictures unlinked
+func (watch *Watch) sidecarGone(name string) error {
+	root := watch.rootOf(name)
+	if root == nil || !root.ParseTags || !artwork.IsSidecar(name) {
+		return nil
+	}
+
+	dir := filepath.Dir(name)
+	entries, err := os.ReadDir(dir)
+	// Gone along with the directory, its files are forgotten already
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() && artwork.IsSidecar(path) && !watch.ignores(root, path, false) {
+			watch.settle(root, path)
+			return nil
+		}
+	}
+	return watch.repo.ReplaceAlbumPictures(dir, nil)
+}
@@ -0,0 +1,201 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wetfloo/voidh/repo"
+)
+
+// Returned when the file has changed while it was read, without an event to tell.
+// It's read again later, once it's done changing
+var FileChangingErr = fmt.Errorf("file changed while it was read")
+
+type RetryCfg struct {
+	// How many times a path is tried before it's given up on
+	Attempts int
+	// Pause before the first retry, doubled for every one after it
+	Backoff    time.Duration
+	BackoffMax time.Duration
+}
+
+func DefaultRetryCfg() RetryCfg {
+	return RetryCfg{
+		Attempts:   5,
+		Backoff:    time.Second,
+		BackoffMax: time.Minute,
+	}
+}
+
+// How many errors are kept for [Watch.Errors] before they are only logged
+const errorsBuffer = 64
+
+// Failure to bring the library up to date with a file or directory. The watcher
+// goes on with everything else, and may try the path again later
+type FileErr struct {
+	Name string
+	// Tries so far, the failed one included
+	Attempts int
+	// Whether the path is to be tried again. Files that aren't are put in quarantine
+	Retrying bool
+	Err      error
+}
+
+func (err *FileErr) Error() string {
+	return fmt.Sprintf("%s, attempt %d: %v", err.Name, err.Attempts, err.Err)
+}
+
+func (err *FileErr) Unwrap() error {
+	return err.Err
+}
+
+// Path that has failed, and may be waiting to be tried again
+type failure struct {
+	attempts int
+	// Nil unless a retry is waiting
+	timer *time.Timer
+}
+
+// Errors the watcher has run into, the ones of files and directories being [FileErr]. None of them stop
+// it, it's up to the receiver what to make of them. Errors that don't fit the buffer are only logged
+func (watch *Watch) Errors() <-chan error {
+	return watch.errs
+}
+
+func (watch *Watch) errorReport(err error) {
+	select {
+	case watch.errs <- err:
+	default:
+		slog.Warn("error while watching", "err", err)
+	}
+}
+
+// Changes how many times failing paths are tried, and how long it's waited in between
+func (watch *Watch) RetryUse(cfg RetryCfg) {
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+
+	watch.retryCfg = cfg
+}
+
+// Must be called with the lock held. Does fn for the file or directory, trying it again later if it fails
+func (watch *Watch) try(name string, fn func() error) {
+	if err := fn(); err != nil {
+		watch.failed(name, err, func() {
+			watch.try(name, fn)
+		})
+		return
+	}
+	watch.succeeded(name)
+}
+
+// Must be called with the lock held. Reports the failure, and has retry called later, replacing the retry
+// waiting for the path, if any. Files that are still failing after all of the attempts are put in quarantine
+func (watch *Watch) failed(name string, err error, retry func()) {
+	f, ok := watch.failures[name]
+	if !ok {
+		f = &failure{}
+		watch.failures[name] = f
+	}
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	f.attempts += 1
+
+	// Files given up on are in quarantine by the time the failure is reported
+	retrying := f.attempts < watch.retryCfg.Attempts
+	if !retrying {
+		delete(watch.failures, name)
+		watch.quarantine(name, f.attempts, err)
+	}
+	watch.errorReport(&FileErr{Name: name, Attempts: f.attempts, Retrying: retrying, Err: err})
+	if !retrying {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(min(watch.retryCfg.Backoff<<(f.attempts-1), watch.retryCfg.BackoffMax), func() {
+		watch.mu.Lock()
+		defer watch.mu.Unlock()
+
+		if watch.failures[name] != f || f.timer != timer {
+			return
+		}
+		f.timer = nil
+		retry()
+	})
+	f.timer = timer
+}
+
+// Must be called with the lock held. Forgets the failures of the path, it's fine now
+func (watch *Watch) succeeded(name string) {
+	if f, ok := watch.failures[name]; ok {
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+		delete(watch.failures, name)
+	}
+}
+
+// Must be called with the lock held. Forgets the failures of the directory and of everything
+// inside, without trying them again
+func (watch *Watch) failuresForget(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for name, f := range watch.failures {
+		if name == dir || strings.HasPrefix(name, prefix) {
+			if f.timer != nil {
+				f.timer.Stop()
+			}
+			delete(watch.failures, name)
+		}
+	}
+}
+
+// Must be called with the lock held. Gives up on the file until it changes. Directories,
+// and files that are gone, aren't put in quarantine, there's nothing to tell when to try them again
+func (watch *Watch) quarantine(name string, attempts int, reason error) {
+	info, err := os.Stat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	if err := watch.repo.Quarantine(repo.Quarantined{
+		Name:     name,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Reason:   reason.Error(),
+		Attempts: attempts,
+		At:       time.Now(),
+	}); err != nil {
+		watch.errorReport(&FileErr{Name: name, Attempts: attempts, Err: err})
+		return
+	}
+	slog.Warn("Quarantined", "fileName", name, "attempts", attempts, "reason", reason)
+}
+
+// Must be called with the lock held. Whether the file is in quarantine, and hasn't changed
+// since. Files that have changed are taken out of it, they are tried again
+func (watch *Watch) quarantined(name string, info os.FileInfo) bool {
+	q, err := watch.repo.QuarantinedGet(name)
+	if errors.Is(err, repo.NotFoundErr) {
+		return false
+	} else if err != nil {
+		watch.errorReport(&FileErr{Name: name, Attempts: 1, Err: err})
+		return false
+	}
+	if q.Same(info.Size(), info.ModTime()) {
+		slog.Debug("Skipping file in quarantine", "fileName", name)
+		return true
+	}
+
+	if err := watch.repo.Release(name); err != nil && !errors.Is(err, repo.NotFoundErr) {
+		watch.errorReport(&FileErr{Name: name, Attempts: 1, Err: err})
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/artwork"
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/repo"
+)
+
+// Library that fails writes of files with the given name, as many times as set
+type testFailingStore struct {
+	*repo.Memory
+	mu    sync.Mutex
+	fails map[string]int
+	err   error
+}
+
+func (store *testFailingStore) BatchFrom(source repo.EventSource, fn func(tx repo.Tx) error) error {
+	return store.Memory.BatchFrom(source, func(tx repo.Tx) error {
+		return fn(testFailingTx{Tx: tx, store: store})
+	})
+}
+
+func (store *testFailingStore) fail(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for suffix, n := range store.fails {
+		if strings.HasSuffix(name, suffix) && n > 0 {
+			store.fails[suffix] = n - 1
+			return store.err
+		}
+	}
+	return nil
+}
+
+type testFailingTx struct {
+	repo.Tx
+	store *testFailingStore
+}
+
+func (tx testFailingTx) Insert(fsFile file.FsFile) error {
+	if err := tx.store.fail(fsFile.Name); err != nil {
+		return err
+	}
+	return tx.Tx.Insert(fsFile)
+}
+
+func testFailingWatch(t *testing.T, dir string, err error, fails map[string]int) (*Watch, *testFailingStore) {
+	cache, cacheErr := artwork.NewCache(t.TempDir(), artwork.DefaultThumbCfg())
+	assert.Nil(t, cacheErr)
+	store := &testFailingStore{Memory: repo.NewMemory(), fails: fails, err: err}
+	root := repo.DefaultRoot(dir)
+	// Text files aren't parsed either way, they are only inserted
+	root.ParseTags = false
+	assert.Nil(t, store.AddRoot(root))
+
+	watch, newErr := New(store, cache)
+	if !assert.Nil(t, newErr) {
+		t.FailNow()
+	}
+	go watch.Start()
+	t.Cleanup(watch.Stop)
+	return watch, store
+}
+
+func TestWatchRetry(t *testing.T) {
+	root := t.TempDir()
+	busy := fmt.Errorf("%w: locked", repo.BusyErr)
+	watch, store := testFailingWatch(t, root, busy, map[string]int{"song.txt": 2})
+
+	song := filepath.Join(root, "song.txt")
+	assert.Nil(t, os.WriteFile(song, []byte("song"), 0o644))
+	for attempt := range 2 {
+		select {
+		case err := <-watch.Errors():
+			var fileErr *FileErr
+			assert.True(t, errors.As(err, &fileErr))
+			assert.Equal(t, FileErr{Name: song, Attempts: attempt + 1, Retrying: true, Err: busy}, *fileErr)
+			assert.True(t, errors.Is(err, repo.BusyErr))
+		case <-time.After(10 * time.Second):
+			t.Fatal("no error reported")
+		}
+	}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{song}, testNames(t, store))
+	}, 15*time.Second, 10*time.Millisecond)
+}
+
+func TestWatchQuarantine(t *testing.T) {
+	root := t.TempDir()
+	broken := fmt.Errorf("broken")
+	watch, store := testFailingWatch(t, root, broken, map[string]int{"song.txt": 2})
+	watch.RetryUse(RetryCfg{Attempts: 2, Backoff: 10 * time.Millisecond, BackoffMax: 10 * time.Millisecond})
+
+	song := filepath.Join(root, "song.txt")
+	assert.Nil(t, os.WriteFile(song, []byte("song"), 0o644))
+	for attempt := range 2 {
+		select {
+		case err := <-watch.Errors():
+			assert.Equal(t, &FileErr{Name: song, Attempts: attempt + 1, Retrying: attempt == 0, Err: broken}, err)
+		case <-time.After(3 * settleQuiet):
+			t.Fatal("no error reported")
+		}
+	}
+	// Still failing after all of the attempts, it's given up on
+	list, err := store.Quarantined()
+	assert.Nil(t, err)
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, song, list[0].Name)
+		assert.Equal(t, "broken", list[0].Reason)
+	}
+
+	// Left alone when written to, as long as it has the same size and modification time after
+	info, err := os.Stat(song)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(song, []byte("SONG"), 0o644))
+	assert.Nil(t, os.Chtimes(song, info.ModTime(), info.ModTime()))
+	time.Sleep(settleQuiet + settleQuiet/2)
+	assert.Empty(t, testNames(t, store))
+
+	// Same for scans
+	stats, err := watch.Scan(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, stats.Quarantined)
+	assert.Empty(t, testNames(t, store))
+
+	// Tried again once it's changed
+	assert.Nil(t, os.WriteFile(song, []byte("fixed"), 0o644))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{song}, testNames(t, store))
+	}, 2*settleQuiet, 10*time.Millisecond)
+	list, err = store.Quarantined()
+	assert.Nil(t, err)
+	assert.Empty(t, list)
+}
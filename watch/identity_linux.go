@@ -0,0 +1,15 @@
+package watch
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// Device and inode of the file, which stay the same when it's renamed or moved within the filesystem
+func identityOf(info fs.FileInfo) (identity, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return identity{}, false
+	}
+	return identity{dev: uint64(stat.Dev), ino: stat.Ino}, true
+}
@@ -0,0 +1,171 @@
+package watch
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/wetfloo/voidh/cas"
+	"github.com/wetfloo/voidh/ignore"
+)
+
+// File of ignore rules for the directory it's in, and everything inside of it.
+// Same as .gitignore: rules of directories deeper down take precedence
+const ignoreFileName = ".voidhignore"
+
+// Files that are never worth indexing: partial downloads, temporary files of editors and
+// sync tools, and metadata file managers and NAS systems leave around
+var ignoreDefaults = []string{
+	metaDir + "/",
+	cas.TempPrefix + "*",
+	"*.part",
+	"*.partial",
+	"*.crdownload",
+	"*.tmp",
+	"*.temp",
+	"*~",
+	".*.swp",
+	".~lock.*#",
+	".DS_Store",
+	"._*",
+	"Thumbs.db",
+	"desktop.ini",
+	"@eaDir/",
+	"#recycle/",
+	".Trash-*/",
+	".stfolder/",
+	".stversions/",
+	".syncthing.*.tmp",
+	"~syncthing~*.tmp",
+	"*.!sync",
+	".sync/",
+	".dropbox.cache/",
+}
+
+// Audio files, and the ones that come with them: cue sheets, rip logs, pictures, lyrics
+var ignoreExtensions = []string{
+	".flac", ".mp3", ".m4a", ".m4b", ".mp4", ".alac", ".aac", ".ogg", ".oga", ".opus",
+	".wav", ".aif", ".aiff", ".ape", ".wv", ".mpc", ".tta", ".wma", ".dsf", ".dff",
+	".cue", ".log", ".m3u", ".m3u8", ".accurip", ".ffp", ".md5", ".sfv", ".txt", ".nfo", ".lrc",
+	".jpg", ".jpeg", ".png", ".gif", ".webp", ".pdf",
+}
+
+type IgnoreCfg struct {
+	// Rules for all roots, relative to each of them, in the syntax of .gitignore
+	Patterns []string
+	// Extensions of the only files that are indexed, with dots, no matter the case. All files are when it's empty
+	Extensions []string
+}
+
+func DefaultIgnoreCfg() IgnoreCfg {
+	return IgnoreCfg{
+		Patterns:   slices.Clone(ignoreDefaults),
+		Extensions: slices.Clone(ignoreExtensions),
+	}
+}
+
+// Must be called with the lock held. Whether the file or directory is to be left out of the root:
+// files of other kinds than the ones indexed, and whatever the rules ignore, along with everything
+// inside of ignored directories. Rules for all roots come first, then the ones of the root, and
+// then ones of the ignore files of every directory on the way
+func (watch *Watch) ignores(root *watchRoot, path string, dir bool) bool {
+	if path == root.Path {
+		return false
+	}
+	name := filepath.Base(path)
+	// Files being linked by the content-addressable store are gone by the time they are handled
+	if strings.HasPrefix(name, cas.TempPrefix) {
+		return true
+	}
+	// Ignore files aren't indexed themselves, they change what is
+	if !dir && name == ignoreFileName {
+		return true
+	}
+	if !dir && len(watch.ignoreCfg.Extensions) > 0 && !slices.ContainsFunc(watch.ignoreCfg.Extensions, func(ext string) bool {
+		return strings.EqualFold(ext, filepath.Ext(path))
+	}) {
+		return true
+	}
+	rel, err := filepath.Rel(root.Path, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	// Files can't be taken back in if a directory they are in is ignored
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		isDir := dir || i < len(parts)-1
+		ignored := watch.ignoreRules.Match(strings.Join(parts[:i+1], "/"), isDir, false)
+		ignored = root.ignoreRules.Match(strings.Join(parts[:i+1], "/"), isDir, ignored)
+		for j := 0; j <= i; j++ {
+			rules := watch.ignoreFile(filepath.Join(root.Path, filepath.Join(parts[:j]...)))
+			ignored = rules.Match(strings.Join(parts[j:i+1], "/"), isDir, ignored)
+		}
+		if ignored {
+			return true
+		}
+	}
+	return false
+}
+
+// Must be called with the lock held. Rules of the ignore file of the directory, empty if there's none
+func (watch *Watch) ignoreFile(dir string) ignore.Rules {
+	if rules, ok := watch.ignoreFiles[dir]; ok {
+		return rules
+	}
+
+	rules := ignore.Rules{}
+	path := filepath.Join(dir, ignoreFileName)
+	f, err := os.Open(path)
+	if err == nil {
+		lines := []string{}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			watch.errorReport(&FileErr{Name: path, Attempts: 1, Err: err})
+		}
+		var errs []error
+		rules, errs = ignore.Parse(lines)
+		for _, err := range errs {
+			watch.errorReport(&FileErr{Name: path, Attempts: 1, Err: err})
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		watch.errorReport(&FileErr{Name: path, Attempts: 1, Err: err})
+	}
+	watch.ignoreFiles[dir] = rules
+	return rules
+}
+
+// Must be called with the lock held. Forgets the rules of ignore files inside the directory,
+// and its own, so that they are read again. Files already there are only left out, or taken
+// back in, by the next scan
+func (watch *Watch) ignoreFilesForget(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for d := range watch.ignoreFiles {
+		if d == dir || strings.HasPrefix(d, prefix) {
+			delete(watch.ignoreFiles, d)
+		}
+	}
+}
+
+// Changes what's left out of all roots. Meant to be called before [Watch.Scan] and
+// [Watch.Start], files already there are only left out, or taken back in, by the next scan
+func (watch *Watch) IgnoreUse(cfg IgnoreCfg) {
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+
+	rules, errs := ignore.Parse(cfg.Patterns)
+	for _, err := range errs {
+		slog.Warn("malformed ignore rule", "err", err)
+	}
+	watch.ignoreCfg = cfg
+	watch.ignoreRules = rules
+}
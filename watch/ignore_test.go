@@ -0,0 +1,70 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/repo"
+)
+
+func TestWatchIgnore(t *testing.T) {
+	root := t.TempDir()
+	path := func(elem ...string) string {
+		return filepath.Join(append([]string{root}, elem...)...)
+	}
+	assert.Nil(t, os.MkdirAll(path("Album", "@eaDir"), 0o755))
+	assert.Nil(t, os.MkdirAll(path("Album", "Scans"), 0o755))
+	for _, name := range []string{
+		"song.flac", "song.flac.part", "notes.txt~", ".DS_Store", "@eaDir/song.flac", "archive.zip",
+		"rip.log", "keep.log", "Scans/front.jpg",
+	} {
+		assert.Nil(t, os.WriteFile(path("Album", name), []byte(name), 0o644))
+	}
+	assert.Nil(t, os.WriteFile(path(ignoreFileName), []byte("*.log\nScans/\n"), 0o644))
+	// Rules of directories deeper down take precedence
+	assert.Nil(t, os.WriteFile(path("Album", ignoreFileName), []byte("!keep.log\n"), 0o644))
+
+	watch, store := testWatch(t, root)
+	_, err := watch.Scan(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{path("Album", "keep.log"), path("Album", "song.flac")}, testNames(t, store))
+
+	// Same for the files showing up later
+	assert.Nil(t, os.WriteFile(path("Album", "other.log"), []byte("other"), 0o644))
+	assert.Nil(t, os.WriteFile(path("Album", "other.tmp"), []byte("other"), 0o644))
+	assert.Nil(t, os.WriteFile(path("Album", "other.mp3"), []byte("other"), 0o644))
+	expected := []string{path("Album", "keep.log"), path("Album", "other.mp3"), path("Album", "song.flac")}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(expected, testNames(t, store))
+	}, 2*settleQuiet, 10*time.Millisecond)
+	time.Sleep(settleQuiet / 2)
+	assert.Equal(t, expected, testNames(t, store))
+
+	// All files are indexed without the allowlist, the rules still apply
+	watch.IgnoreUse(IgnoreCfg{Patterns: []string{"*.zip"}})
+	_, err = watch.Scan(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		path("Album", ".DS_Store"),
+		path("Album", "@eaDir", "song.flac"),
+		path("Album", "keep.log"),
+		path("Album", "notes.txt~"),
+		path("Album", "other.mp3"),
+		path("Album", "other.tmp"),
+		path("Album", "song.flac"),
+		path("Album", "song.flac.part"),
+	}, testNames(t, store))
+
+	// Roots have rules of their own
+	r := repo.DefaultRoot(root)
+	r.Ignore = []string{"*.tmp", "*.part"}
+	assert.Nil(t, store.AddRoot(r))
+	assert.Nil(t, watch.RootsSync())
+	_, err = watch.Scan(nil)
+	assert.Nil(t, err)
+	assert.NotContains(t, testNames(t, store), path("Album", "other.tmp"))
+	assert.NotContains(t, testNames(t, store), path("Album", "song.flac.part"))
+}
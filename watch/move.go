@@ -0,0 +1,250 @@
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wetfloo/voidh/artwork"
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/repo"
+)
+
+// How long a renamed file waits for its new name to show up, before it's taken for removed.
+// Both events are sent by the system at once, unless the file is moved out of the roots
+const movePairWindow = time.Second
+
+// What tells a file or directory apart from the others, no matter its name
+type identity struct {
+	dev uint64
+	ino uint64
+}
+
+// File or directory that has been renamed, and waits for the Create of its new name
+type move struct {
+	root *watchRoot
+	dir  bool
+	// As it's indexed, unset for directories
+	fsFile file.FsFile
+	id     identity
+	hasId  bool
+	// Whether the file was waiting to settle, in which case it has to wait under the new name
+	unsettled bool
+	// Files that have shown up since, and can only be told to be this one by their hashes. It
+	// waits for them to be read, which takes them to settle first, and may take longer than the window
+	candidates map[string]bool
+	timer      *time.Timer
+}
+
+// Must be called with the lock held. Holds off forgetting the renamed file or directory,
+// as it may show up under a new name. Moved files keep being the same files of the library,
+// with everything that belongs to them, instead of being removed and indexed anew
+func (watch *Watch) moveStart(root *watchRoot, name string) error {
+	// Directories are reported twice, by themselves and by their parent
+	if _, ok := watch.moves[name]; ok {
+		return nil
+	}
+
+	id, hasId := watch.ids[name]
+	m := &move{
+		root:       root,
+		dir:        watch.dirs[name],
+		id:         id,
+		hasId:      hasId,
+		unsettled:  watch.settleCancel(name),
+		candidates: map[string]bool{},
+	}
+	if m.dir && !hasId {
+		// Directories can only be told by their identity, without one all that's left is to index them anew
+		return watch.dirForget(name)
+	}
+	if !m.dir {
+		af, err := watch.repo.GetByPath(name)
+		if errors.Is(err, repo.NotFoundErr) {
+			delete(watch.ids, name)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		m.fsFile = af.FsFile
+	}
+
+	m.timer = time.AfterFunc(movePairWindow, func() {
+		watch.mu.Lock()
+		defer watch.mu.Unlock()
+
+		if watch.moves[name] != m {
+			return
+		}
+		if watch.moveWaiting(m) {
+			m.timer.Reset(movePairWindow)
+			return
+		}
+		delete(watch.moves, name)
+		watch.try(name, func() error {
+			if m.dir {
+				return watch.dirForget(name)
+			}
+			return watch.fsFileForget(name)
+		})
+		slog.Debug("Moved out of the roots", "fileName", name)
+	})
+	watch.moves[name] = m
+	return nil
+}
+
+// Must be called with the lock held. Finds the move the file or directory that has just
+// shown up is the end of. Files are matched by their identity, or by their hash where
+// there's no identity to go by, directories are matched by identity only
+func (watch *Watch) moveFind(root *watchRoot, dir bool, id identity, hasId bool, hash []byte) (string, *move) {
+	for name, m := range watch.moves {
+		if m.dir != dir {
+			continue
+		}
+		if hasId && m.hasId {
+			// Hashes are reused, which only works within the same hash algorithm
+			if m.id == id && m.root.Hash == root.Hash {
+				return name, m
+			}
+			continue
+		}
+		if hash != nil && bytes.Equal(m.fsFile.Hash, hash) {
+			return name, m
+		}
+	}
+	return "", nil
+}
+
+// Must be called with the lock held. Has the moves of files that the new one can't be told apart from
+// without its hash wait for it to be read, so that they aren't taken for removed in the meantime
+func (watch *Watch) moveCandidate(root *watchRoot, name string, hasId bool) {
+	for _, m := range watch.moves {
+		if !m.dir && !(hasId && m.hasId) && m.root.Hash == root.Hash {
+			m.candidates[name] = true
+		}
+	}
+}
+
+// Must be called with the lock held. Whether any of the files that may be the moved one are yet to be read
+func (watch *Watch) moveWaiting(m *move) bool {
+	for name := range m.candidates {
+		if watch.settling[name] != nil || watch.pool.has(name) {
+			return true
+		}
+		delete(m.candidates, name)
+	}
+	return false
+}
+
+// Must be called with the lock held. Gives the file of the library the new name. If that
+// fails, the move is left waiting, so that it's found again when the file is tried again
+func (watch *Watch) fileMoved(oldName string, m *move, root *watchRoot, fsFile file.FsFile) error {
+	// Files saved by writing a temporary one and renaming it over the original stay the original
+	// files, they are read again for their new contents
+	if _, err := watch.repo.GetByPath(fsFile.Name); err == nil {
+		if err := watch.fsFileForget(oldName); err != nil {
+			return err
+		}
+		watch.moveEnd(oldName, m)
+		watch.settle(root, fsFile.Name)
+		return nil
+	} else if !errors.Is(err, repo.NotFoundErr) {
+		return err
+	}
+
+	if err := watch.write(func(tx repo.Tx) error {
+		return tx.Update(repo.Eq(repo.Filename{}, oldName), fsFile)
+	}); err != nil {
+		return err
+	}
+	watch.moveEnd(oldName, m)
+	// Sidecar pictures belong to the album of their directory, which may be a different one now.
+	// They're read again, off the event loop, and so are the ones of the album they've left
+	if root.ParseTags && artwork.IsSidecar(fsFile.Name) {
+		watch.settle(root, fsFile.Name)
+	}
+	if filepath.Dir(oldName) != filepath.Dir(fsFile.Name) {
+		if err := watch.sidecarGone(oldName); err != nil {
+			slog.Warn("can't index artwork", "fileName", oldName, "err", err)
+		}
+	}
+	slog.Debug("Moved", "oldName", oldName, "fileName", fsFile.Name)
+	return nil
+}
+
+// Must be called with the lock held. Stops waiting for the new name of the file, it's been found
+func (watch *Watch) moveEnd(oldName string, m *move) {
+	m.timer.Stop()
+	delete(watch.moves, oldName)
+	delete(watch.ids, oldName)
+}
+
+// Must be called with the lock held. Stops waiting for the new names of the directory and
+// of everything inside, their old names are left as they are
+func (watch *Watch) movesForget(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for name, m := range watch.moves {
+		if name == dir || strings.HasPrefix(name, prefix) {
+			m.timer.Stop()
+			delete(watch.moves, name)
+		}
+	}
+}
+
+// Must be called with the lock held. Gives all files inside the directory their new names, and watches it under the new one
+func (watch *Watch) dirMoved(oldDir string, m *move, root *watchRoot, dir string) error {
+	m.timer.Stop()
+	delete(watch.moves, oldDir)
+	watch.dirsUnwatch(oldDir)
+	watch.ignoreFilesForget(oldDir)
+	unsettled := watch.settleCancelDir(oldDir)
+
+	moved := map[string]identity{}
+	oldPrefix := oldDir + string(filepath.Separator)
+	for name, id := range watch.ids {
+		if name == oldDir || strings.HasPrefix(name, oldPrefix) {
+			delete(watch.ids, name)
+			moved[dir+strings.TrimPrefix(name, oldDir)] = id
+		}
+	}
+	maps.Copy(watch.ids, moved)
+
+	files, err := watch.repo.Find(repo.Query{Where: repo.Under(oldDir)})
+	if err != nil {
+		return err
+	}
+	if err := watch.write(func(tx repo.Tx) error {
+		for _, f := range files {
+			oldName := f.Name
+			f.Name = dir + strings.TrimPrefix(oldName, oldDir)
+			if err := tx.Update(repo.Eq(repo.Filename{}, oldName), f); err != nil {
+				return err
+			}
+		}
+		// Sidecar pictures stay with the albums they belong to
+		return tx.MoveAlbumPictures(oldDir, dir)
+	}); err != nil {
+		return err
+	}
+	slog.Debug("Moved directory", "oldDir", oldDir, "dir", dir, "files", len(files))
+
+	if err := watch.dirWatch(root, dir, false); err != nil {
+		return err
+	}
+	for _, name := range unsettled {
+		watch.settle(root, dir+strings.TrimPrefix(name, oldDir))
+	}
+	return nil
+}
+
+// Must be called with the lock held. Remembers the identity of the file or directory, if it has one
+func (watch *Watch) identityKeep(name string, info fs.FileInfo) {
+	if id, ok := identityOf(info); ok {
+		watch.ids[name] = id
+	}
+}
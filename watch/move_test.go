@@ -0,0 +1,136 @@
+package watch
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/repo"
+)
+
+func testEventKinds(t *testing.T, store repo.Store, path string) []repo.EventKind {
+	history, err := store.History(path)
+	assert.Nil(t, err)
+	result := []repo.EventKind{}
+	for _, e := range history {
+		result = append(result, e.Kind)
+	}
+	return result
+}
+
+func TestWatchMove(t *testing.T) {
+	root := t.TempDir()
+	_, store := testWatch(t, root)
+	eventually := func(expected []string) {
+		assert.Eventually(t, func() bool {
+			return assert.ObjectsAreEqual(expected, testNames(t, store))
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "Album", "Disc 1"), 0o755))
+	song := filepath.Join(root, "Album", "Disc 1", "song.txt")
+	assert.Nil(t, os.WriteFile(song, []byte("song"), 0o644))
+	eventually([]string{song})
+
+	// Files keep being the same ones when renamed
+	renamed := filepath.Join(root, "Album", "Disc 1", "renamed.txt")
+	assert.Nil(t, os.Rename(song, renamed))
+	eventually([]string{renamed})
+	assert.Equal(t, []repo.EventKind{repo.EventCreate, repo.EventRename}, testEventKinds(t, store, renamed))
+
+	// And so do files of renamed directories, at any depth, and albums keep their pictures
+	cover := []file.PictureLink{{Picture: file.Picture{Hash: []byte{1}, MimeType: "image/jpeg"}, PicType: 3}}
+	assert.Nil(t, store.ReplaceAlbumPictures(filepath.Join(root, "Album", "Disc 1"), cover))
+	assert.Nil(t, os.Rename(filepath.Join(root, "Album"), filepath.Join(root, "Moved")))
+	moved := filepath.Join(root, "Moved", "Disc 1", "renamed.txt")
+	eventually([]string{moved})
+	assert.Equal(t, []repo.EventKind{repo.EventCreate, repo.EventRename, repo.EventRename}, testEventKinds(t, store, moved))
+	pics, err := store.AlbumPictures(filepath.Join(root, "Moved", "Disc 1"))
+	assert.Nil(t, err)
+	assert.Equal(t, cover, pics)
+	pics, err = store.AlbumPictures(filepath.Join(root, "Album", "Disc 1"))
+	assert.Nil(t, err)
+	assert.Empty(t, pics)
+
+	// Directories moved keep being watched under their new names
+	added := filepath.Join(root, "Moved", "Disc 1", "added.txt")
+	assert.Nil(t, os.WriteFile(added, []byte("added"), 0o644))
+	eventually([]string{added, moved})
+
+	// Saved by renaming a temporary file over it, which is the same file still
+	tmp := filepath.Join(root, "Moved", "Disc 1", ".added.txt.swp")
+	assert.Nil(t, os.WriteFile(tmp, []byte("changed"), 0o644))
+	assert.Nil(t, os.Rename(tmp, added))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]repo.EventKind{repo.EventCreate, repo.EventModify}, testEventKinds(t, store, added))
+	}, 5*time.Second, 10*time.Millisecond)
+	eventually([]string{added, moved})
+
+	// Moved out of the roots, which is the same as removed, once it's clear it won't show up again
+	assert.Nil(t, os.Rename(moved, filepath.Join(t.TempDir(), "renamed.txt")))
+	eventually([]string{added})
+	assert.Equal(t, repo.EventDelete, testEventKinds(t, store, moved)[3])
+}
+
+func TestWatchMoveHash(t *testing.T) {
+	root := t.TempDir()
+	watch, store := testWatch(t, root)
+
+	song := filepath.Join(root, "song.txt")
+	assert.Nil(t, os.WriteFile(song, []byte("song"), 0o644))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{song}, testNames(t, store))
+	}, 2*settleQuiet, 10*time.Millisecond)
+
+	// Without its identity, like where the system has none, the file is only told by its hash,
+	// once it's settled under the new name and read, which takes longer than the move waits by itself
+	watch.mu.Lock()
+	delete(watch.ids, song)
+	watch.mu.Unlock()
+	renamed := filepath.Join(root, "renamed.txt")
+	assert.Nil(t, os.Rename(song, renamed))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{renamed}, testNames(t, store))
+	}, 2*settleQuiet, 10*time.Millisecond)
+	assert.Equal(t, []repo.EventKind{repo.EventCreate, repo.EventRename}, testEventKinds(t, store, renamed))
+}
+
+func TestWatchSidecarGone(t *testing.T) {
+	root := t.TempDir()
+	album := filepath.Join(root, "Album")
+	other := filepath.Join(root, "Other")
+	assert.Nil(t, os.MkdirAll(album, 0o755))
+	assert.Nil(t, os.MkdirAll(other, 0o755))
+	_, store := testWatch(t, root)
+	pictures := func(dir string, n int) {
+		assert.Eventually(t, func() bool {
+			pics, err := store.AlbumPictures(dir)
+			return err == nil && len(pics) == n
+		}, 10*time.Second, 10*time.Millisecond)
+	}
+
+	for i, name := range []string{"cover.png", "back.png"} {
+		var buf bytes.Buffer
+		assert.Nil(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, i+1, i+1))))
+		assert.Nil(t, os.WriteFile(filepath.Join(album, name), buf.Bytes(), 0o644))
+	}
+	pictures(album, 2)
+
+	// The album is left with the pictures that are still there
+	assert.Nil(t, os.Remove(filepath.Join(album, "back.png")))
+	pictures(album, 1)
+	pics, err := store.AlbumPictures(album)
+	assert.Nil(t, err)
+	assert.Equal(t, "cover.png", pics[0].Desc)
+
+	// Or without any, once the last one is moved into another album
+	assert.Nil(t, os.Rename(filepath.Join(album, "cover.png"), filepath.Join(other, "cover.png")))
+	pictures(other, 1)
+	pictures(album, 0)
+}
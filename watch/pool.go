@@ -0,0 +1,385 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wetfloo/voidh/artwork"
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/file/probe"
+	"github.com/wetfloo/voidh/repo"
+)
+
+type PoolCfg struct {
+	// How many files are read at once
+	Workers int
+	// Bytes per second all of the workers read at most, 0 for no limit. Keeps scans
+	// of large libraries from taking the disk away from everything else
+	ReadRate int64
+}
+
+func DefaultPoolCfg() PoolCfg {
+	return PoolCfg{
+		Workers:  runtime.NumCPU(),
+		ReadRate: 0,
+	}
+}
+
+// Which files are read first
+type priority int
+
+const (
+	// Files changed while being watched, someone may be waiting to see them
+	priorityLive priority = iota
+	// Files found by a scan, there can be lots of them
+	priorityScan
+	priorityCount
+)
+
+// File read by a worker, ready to be indexed
+type fileRead struct {
+	fsFile file.FsFile
+	// Tags and properties, nil unless the root parses tags and the file is an audio file
+	af *file.AudioFile
+	// Virtual tracks of the file, or of the FLAC files it references if it's a cue sheet, by their sources
+	virtualTracks map[string][]file.VirtualTrack
+	// Pictures embedded into the file, or of the album in its directory if it's a sidecar picture. Cached already
+	pictures []file.PictureLink
+	sidecar  bool
+}
+
+// File waiting to be read, or being read
+type job struct {
+	root     *watchRoot
+	name     string
+	priority priority
+	// Cancelled when the file changes again, or is gone, whatever is read is stale then
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Called by the worker when it's done, even if the job has been cancelled
+	done func(j *job)
+	// Set once it's done
+	read fileRead
+	err  error
+}
+
+// Reads files off the event loop: hashes and parses them, and caches their pictures,
+// with as many workers as configured, each with hashers of its own
+type pool struct {
+	artworkCache artwork.Cache
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// Jobs waiting for a worker, by priority
+	queues [priorityCount][]*job
+	// Jobs waiting or being read, by file names
+	jobs     map[string]*job
+	throttle *throttle
+	stopped  bool
+	workers  sync.WaitGroup
+}
+
+func poolNew(cfg PoolCfg, artworkCache artwork.Cache) *pool {
+	result := &pool{
+		artworkCache: artworkCache,
+		jobs:         map[string]*job{},
+		throttle:     &throttle{rate: cfg.ReadRate},
+	}
+	result.cond = sync.NewCond(&result.mu)
+
+	workers := max(cfg.Workers, 1)
+	result.workers.Add(workers)
+	for range workers {
+		go result.work()
+	}
+	return result
+}
+
+// Queues the file to be read, cancelling the job already there for it, if any: the
+// file has changed since. Done is called from a worker, unless the pool is stopped
+func (p *pool) read(root *watchRoot, name string, priority priority, done func(j *job)) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	result := &job{root: root, name: name, priority: priority, ctx: ctx, cancel: cancel, done: done}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		cancel()
+		// The caller may be holding locks done needs
+		go p.finish(result)
+		return result
+	}
+	if old, ok := p.jobs[name]; ok {
+		old.cancel()
+	}
+	p.jobs[name] = result
+	p.queues[priority] = append(p.queues[priority], result)
+	p.cond.Signal()
+	return result
+}
+
+// Cancels the job for the file, as it's gone or has changed. Tells whether there was one
+func (p *pool) cancel(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	j, ok := p.jobs[name]
+	if ok {
+		j.cancel()
+		delete(p.jobs, name)
+	}
+	return ok
+}
+
+// Whether the file is waiting to be read, or being read
+func (p *pool) has(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.jobs[name]
+	return ok
+}
+
+// Cancels jobs for files inside the directory, returning their names
+func (p *pool) cancelDir(dir string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := []string{}
+	prefix := dir + string(filepath.Separator)
+	for name, j := range p.jobs {
+		if strings.HasPrefix(name, prefix) {
+			j.cancel()
+			delete(p.jobs, name)
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// Cancels all jobs, and waits for the workers to finish with the ones they are reading.
+// Must not be called while holding locks done needs
+func (p *pool) stop() {
+	p.mu.Lock()
+	p.stopped = true
+	for _, j := range p.jobs {
+		j.cancel()
+	}
+	clear(p.jobs)
+	queued := []*job{}
+	for _, queue := range p.queues {
+		queued = append(queued, queue...)
+	}
+	p.queues = [priorityCount][]*job{}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	for _, j := range queued {
+		p.finish(j)
+	}
+	p.workers.Wait()
+}
+
+// Takes the next job, live ones first. Nil once the pool is stopped
+func (p *pool) take() *job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		if p.stopped {
+			return nil
+		}
+		for i, queue := range p.queues {
+			if len(queue) > 0 {
+				p.queues[i] = queue[1:]
+				return queue[0]
+			}
+		}
+		p.cond.Wait()
+	}
+}
+
+func (p *pool) work() {
+	defer p.workers.Done()
+
+	// Hashers are reused between files, one for each algorithm
+	hashers := map[repo.HashAlgo]hash.Hash{}
+	for j := p.take(); j != nil; j = p.take() {
+		// Cancelled while waiting, there's nothing to read
+		if j.ctx.Err() != nil {
+			p.finish(j)
+			continue
+		}
+		hasher, ok := hashers[j.root.Hash]
+		if !ok {
+			var err error
+			hasher, err = j.root.Hash.Hasher()
+			if err != nil {
+				j.err = err
+				p.finish(j)
+				continue
+			}
+			hashers[j.root.Hash] = hasher
+		}
+
+		j.read, j.err = fileReadFull(j.ctx, j.root, j.name, hasher, p.throttle, p.artworkCache)
+		p.finish(j)
+	}
+}
+
+// Hands the job in. It's only let go of once done is, until then the file is still being read
+func (p *pool) finish(j *job) {
+	if j.err == nil {
+		j.err = j.ctx.Err()
+	}
+
+	j.done(j)
+
+	p.mu.Lock()
+	if p.jobs[j.name] == j {
+		delete(p.jobs, j.name)
+	}
+	p.mu.Unlock()
+}
+
+// Hashes the file, and parses it if the root parses tags. Files that can't be parsed are still read, just without tags,
+// virtual tracks or pictures
+func fileReadFull(
+	ctx context.Context,
+	root *watchRoot,
+	name string,
+	hasher hash.Hash,
+	throttle *throttle,
+	artworkCache artwork.Cache,
+) (fileRead, error) {
+	var result fileRead
+
+	fsFile, err := fsFileRead(ctx, name, hasher, throttle)
+	if err != nil {
+		return result, err
+	}
+	result.fsFile = fsFile
+	if !root.ParseTags {
+		return result, nil
+	}
+
+	if af, err := probe.Read(fsFile); err == nil {
+		result.af = &af
+	} else if !errors.Is(err, probe.UnsupportedFormatErr) {
+		slog.Warn("can't read audio file", "fileName", name, "err", err)
+	}
+	if result.virtualTracks, err = virtualTracksRead(name); err != nil {
+		slog.Warn("can't read virtual tracks", "fileName", name, "err", err)
+	}
+	if err := artworkRead(artworkCache, &result); err != nil {
+		slog.Warn("can't read artwork", "fileName", name, "err", err)
+	}
+	return result, ctx.Err()
+}
+
+// Keeps all of the workers together reading no more bytes per second than the rate
+type throttle struct {
+	mu   sync.Mutex
+	rate int64
+	// When everything read so far is paid off
+	next time.Time
+}
+
+// Waits for the bytes that have just been read to be paid off, unless there's no limit
+func (t *throttle) wait(ctx context.Context, n int) error {
+	if t == nil || t.rate <= 0 || n <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / t.rate))
+	delay := t.next.Sub(now)
+	t.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Reader that stops once its context is cancelled, and keeps to the throttle
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	throttle *throttle
+}
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if err := r.throttle.wait(r.ctx, n); err != nil {
+		return n, err
+	}
+	return n, err
+}
+
+func fsFileRead(ctx context.Context, filePath string, hasher hash.Hash, throttle *throttle) (file.FsFile, error) {
+	result := file.FsFile{Name: filePath}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return result, err
+	}
+	result.Size = info.Size()
+	result.ModTime = info.ModTime()
+
+	result.Hash, err = fileHashCalc(ctx, filePath, hasher, throttle)
+	if err != nil {
+		return result, err
+	}
+
+	// Written to in the meantime, the hash is of neither the old contents nor the new ones
+	info, err = os.Stat(filePath)
+	if err != nil {
+		return result, err
+	}
+	if info.Size() != result.Size || !info.ModTime().Equal(result.ModTime) {
+		return result, fmt.Errorf("%s: %w", filePath, FileChangingErr)
+	}
+	return result, nil
+}
+
+func fileHashCalc(ctx context.Context, filePath string, hasher hash.Hash, throttle *throttle) ([]byte, error) {
+	var result []byte
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+
+	hasher.Reset()
+
+	if _, err := io.Copy(hasher, throttledReader{ctx: ctx, r: file, throttle: throttle}); err != nil {
+		return result, err
+	}
+
+	result = hasher.Sum(nil)
+	return result, nil
+}
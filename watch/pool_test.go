@@ -0,0 +1,102 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/artwork"
+	"github.com/wetfloo/voidh/repo"
+)
+
+func TestPool(t *testing.T) {
+	dir := t.TempDir()
+	root := &watchRoot{Root: repo.DefaultRoot(dir)}
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+	for _, name := range []string{"first.txt", "scanned.txt", "live.txt", "changed.txt"} {
+		assert.Nil(t, os.WriteFile(path(name), []byte(name), 0o644))
+	}
+
+	p := poolNew(PoolCfg{Workers: 1}, artwork.Cache{})
+	defer p.stop()
+	done := make(chan *job, 5)
+	release := make(chan struct{})
+	// Keeps the only worker busy until everything else is queued
+	p.read(root, path("first.txt"), priorityScan, func(j *job) {
+		<-release
+		done <- j
+	})
+	time.Sleep(10 * time.Millisecond)
+	p.read(root, path("scanned.txt"), priorityScan, func(j *job) { done <- j })
+	p.read(root, path("changed.txt"), priorityScan, func(j *job) { done <- j })
+	p.read(root, path("live.txt"), priorityLive, func(j *job) { done <- j })
+	// Changed again before it's read, the first read is cancelled
+	p.read(root, path("changed.txt"), priorityLive, func(j *job) { done <- j })
+	close(release)
+
+	names := []string{}
+	for range 5 {
+		j := <-done
+		if j.err != nil {
+			assert.ErrorIs(t, j.err, context.Canceled)
+			names = append(names, "cancelled "+filepath.Base(j.name))
+			continue
+		}
+		hash := sha1.Sum([]byte(filepath.Base(j.name)))
+		assert.Equal(t, hash[:], j.read.fsFile.Hash)
+		names = append(names, filepath.Base(j.name))
+	}
+	// Live ones first, cancelled ones are done without being read
+	assert.Equal(t, []string{"first.txt", "live.txt", "changed.txt", "scanned.txt", "cancelled changed.txt"}, names)
+}
+
+func TestThrottle(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "song.txt")
+	assert.Nil(t, os.WriteFile(name, make([]byte, 32*1024), 0o644))
+
+	start := time.Now()
+	_, err := fsFileRead(context.Background(), name, sha1.New(), &throttle{rate: 64 * 1024})
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	// Cancelled reads don't wait for the throttle
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = fsFileRead(ctx, name, sha1.New(), &throttle{rate: 1})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFileReadFullArtwork(t *testing.T) {
+	dir := t.TempDir()
+	root := &watchRoot{Root: repo.DefaultRoot(dir)}
+	cache, err := artwork.NewCache(t.TempDir(), artwork.DefaultThumbCfg())
+	assert.Nil(t, err)
+	var buf bytes.Buffer
+	assert.Nil(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+	cover := filepath.Join(dir, "cover.png")
+	assert.Nil(t, os.WriteFile(cover, buf.Bytes(), 0o644))
+
+	// Pictures are cached by the worker, along with their thumbnails, so that indexing only links them
+	read, err := fileReadFull(context.Background(), root, cover, sha1.New(), nil, cache)
+	assert.Nil(t, err)
+	assert.True(t, read.sidecar)
+	assert.Len(t, read.pictures, 1)
+	assert.FileExists(t, cache.Path(read.pictures[0].Hash))
+	assert.FileExists(t, cache.ThumbnailPath(read.pictures[0].Hash, artwork.DefaultThumbCfg().Sizes[0]))
+
+	// Nothing is parsed for roots that don't parse tags
+	root.ParseTags = false
+	read, err = fileReadFull(context.Background(), root, cover, sha1.New(), nil, cache)
+	assert.Nil(t, err)
+	assert.False(t, read.sidecar)
+	assert.Nil(t, read.pictures)
+}
@@ -0,0 +1,173 @@
+package watch
+
+import (
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/wetfloo/voidh/cas"
+	"github.com/wetfloo/voidh/ignore"
+	"github.com/wetfloo/voidh/repo"
+)
+
+// Root being watched, with what it takes to index its files
+type watchRoot struct {
+	repo.Root
+	// Compiled Ignore of the root
+	ignoreRules ignore.Rules
+	// Nil unless files are to be ingested into the content-addressable store of the root
+	blobs *cas.Store
+}
+
+// Adds the root to the library, or updates its settings, and starts watching it.
+// Files already there are indexed right away
+func (watch *Watch) AddRoot(root repo.Root) error {
+	if err := watch.repo.AddRoot(root); err != nil {
+		return err
+	}
+
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+
+	wr, err := watch.rootWatch(root)
+	if err != nil {
+		return err
+	}
+	stats, err := watch.scan([]*watchRoot{wr}, nil)
+	if err != nil {
+		return err
+	}
+	slog.Info("Added root", "root", root.Path, "added", stats.Added, "moved", stats.Moved)
+	return nil
+}
+
+// Stops watching the root, and removes it from the library along with all of its files
+func (watch *Watch) RemoveRoot(path string) error {
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+
+	watch.rootForget(path)
+	return watch.repo.RemoveRoot(path)
+}
+
+// Catches up with roots added to, removed from, or changed in the library by someone else,
+// like another process sharing the database. New roots are indexed right away
+func (watch *Watch) RootsSync() error {
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+
+	return watch.rootsSync(true)
+}
+
+// Must be called with the lock held. With index unset, files of new roots are left for the scan
+func (watch *Watch) rootsSync(index bool) error {
+	roots, err := watch.repo.Roots()
+	if err != nil {
+		return err
+	}
+
+	for path := range watch.roots {
+		if !slices.ContainsFunc(roots, func(root repo.Root) bool { return root.Path == path }) {
+			watch.rootForget(path)
+		}
+	}
+
+	added := []*watchRoot{}
+	for _, root := range roots {
+		wr, ok := watch.roots[root.Path]
+		if ok && slices.Equal(wr.Ignore, root.Ignore) && wr.ReadOnly == root.ReadOnly &&
+			wr.Hash == root.Hash && wr.ParseTags == root.ParseTags {
+			continue
+		}
+
+		// Changed settings apply to files indexed from now on, the ones already there are left as they are
+		unsettled := []string{}
+		if ok {
+			unsettled = watch.rootForget(root.Path)
+		}
+		wr, err := watch.rootWatch(root)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			added = append(added, wr)
+		}
+		for _, name := range unsettled {
+			watch.settle(wr, name)
+		}
+	}
+
+	if !index || len(added) == 0 {
+		return nil
+	}
+	stats, err := watch.scan(added, nil)
+	if err != nil {
+		return err
+	}
+	slog.Info("Synced roots", "added", stats.Added, "moved", stats.Moved)
+	return nil
+}
+
+// Must be called with the lock held. Starts watching the root, its files are left for the scan
+func (watch *Watch) rootWatch(root repo.Root) (*watchRoot, error) {
+	// Workers make hashers of their own, the algorithm is only checked here
+	if _, err := root.Hash.Hasher(); err != nil {
+		return nil, err
+	}
+	result := &watchRoot{Root: root}
+	rules, errs := ignore.Parse(root.Ignore)
+	for _, err := range errs {
+		slog.Warn("malformed ignore rule", "root", root.Path, "err", err)
+	}
+	result.ignoreRules = rules
+
+	if watch.casCfg != nil && !root.ReadOnly {
+		blobs, err := cas.New(root.Path, *watch.casCfg)
+		if err != nil {
+			return nil, err
+		}
+		result.blobs = &blobs
+	}
+
+	watch.roots[root.Path] = result
+	if err := watch.dirWatch(result, root.Path, false); err != nil {
+		delete(watch.roots, root.Path)
+		return nil, err
+	}
+	return result, nil
+}
+
+// Must be called with the lock held. Stops watching the root, its files stay in the library. Nothing
+// that's pending for its files is done anymore: they aren't read, nor tried again, nor taken for moved
+// away. Returns the names of the files that were waiting to settle, or were being read
+func (watch *Watch) rootForget(path string) []string {
+	watch.dirsUnwatch(path)
+	watch.identitiesForget(path)
+	watch.ignoreFilesForget(path)
+	unsettled := watch.settleCancelDir(path)
+	watch.movesForget(path)
+	watch.failuresForget(path)
+	delete(watch.roots, path)
+	return unsettled
+}
+
+// Must be called with the lock held. Whether the root is still watched, with the same settings.
+// Whatever was pending for the files of roots that are gone or changed is stale
+func (watch *Watch) rootCurrent(root *watchRoot) bool {
+	return watch.roots[root.Path] == root
+}
+
+// Must be called with the lock held. Root holding the file, nil if it's in none of them
+func (watch *Watch) rootOf(name string) *watchRoot {
+	for path, root := range watch.roots {
+		prefix := path
+		if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+			prefix += string(filepath.Separator)
+		}
+		if strings.HasPrefix(name, prefix) {
+			return root
+		}
+	}
+	return nil
+}
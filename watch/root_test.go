@@ -0,0 +1,82 @@
+package watch
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/artwork"
+	"github.com/wetfloo/voidh/cas"
+	"github.com/wetfloo/voidh/repo"
+)
+
+func TestRoots(t *testing.T) {
+	first := t.TempDir()
+	watch, store := testWatch(t, first)
+
+	second := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(second, "there.txt"), []byte("there"), 0o644))
+	root := repo.DefaultRoot(second)
+	root.Ignore = []string{"*.log"}
+	// Files already there are indexed as soon as the root is added
+	assert.Nil(t, watch.AddRoot(root))
+	assert.Equal(t, []string{filepath.Join(second, "there.txt")}, testNames(t, store))
+
+	assert.Nil(t, os.WriteFile(filepath.Join(first, "one.txt"), []byte("one"), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(second, "two.txt"), []byte("two"), 0o644))
+	assert.Nil(t, os.WriteFile(filepath.Join(second, "debug.log"), []byte("log"), 0o644))
+	expected := []string{filepath.Join(first, "one.txt"), filepath.Join(second, "there.txt"), filepath.Join(second, "two.txt")}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(expected, testNames(t, store))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Files that haven't settled yet when their root is removed aren't indexed anymore
+	assert.Nil(t, os.WriteFile(filepath.Join(second, "late.txt"), []byte("late"), 0o644))
+	assert.Eventually(t, func() bool {
+		watch.mu.Lock()
+		defer watch.mu.Unlock()
+		return watch.settling[filepath.Join(second, "late.txt")] != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, watch.RemoveRoot(second))
+	assert.Equal(t, []string{filepath.Join(first, "one.txt")}, testNames(t, store))
+	time.Sleep(settleQuiet + settleQuiet/2)
+	assert.Equal(t, []string{filepath.Join(first, "one.txt")}, testNames(t, store))
+	roots, err := store.Roots()
+	assert.Nil(t, err)
+	assert.Equal(t, []repo.Root{repo.DefaultRoot(first)}, roots)
+
+	// Roots added by someone else are picked up
+	assert.Nil(t, store.AddRoot(repo.DefaultRoot(second)))
+	assert.Nil(t, watch.RootsSync())
+	assert.Len(t, testNames(t, store), 5)
+}
+
+func TestRootWithoutTagsIngested(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := artwork.NewCache(t.TempDir(), artwork.DefaultThumbCfg())
+	assert.Nil(t, err)
+	store := repo.NewMemory()
+	root := repo.DefaultRoot(dir)
+	root.ParseTags = false
+	assert.Nil(t, store.AddRoot(root))
+
+	watch, err := New(store, cache)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	assert.Nil(t, watch.CasUse(cas.DefaultCfg()))
+	go watch.Start()
+	t.Cleanup(watch.Stop)
+
+	// Files are only indexed by their contents, but they're still ingested
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "song.flac"), []byte("song"), 0o644))
+	blobs, err := cas.New(dir, cas.DefaultCfg())
+	assert.Nil(t, err)
+	hash := sha1.Sum([]byte("song"))
+	assert.Eventually(t, func() bool {
+		return blobs.Has(hash[:])
+	}, 5*time.Second, 10*time.Millisecond)
+}
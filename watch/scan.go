@@ -0,0 +1,251 @@
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/repo"
+)
+
+// How often a scan reports its progress, in files seen
+const scanProgressEvery = 1000
+
+// What a scan has found and done, so far or in total
+type ScanStats struct {
+	// Files found on disk, changed or not
+	Seen int
+	// Files read in full, as they are new, or their size or modification time is not what it was
+	Hashed  int
+	Added   int
+	Changed int
+	Moved   int
+	Removed int
+	// Files left alone, as they are in quarantine and haven't changed since
+	Quarantined int
+	// Files that couldn't be brought up to date, reported through [Watch.Errors]
+	Failed int
+}
+
+// Brings the library up to date with the roots, catching up on everything that
+// happened while they weren't watched. Files with the same size and modification
+// time as when they were indexed are trusted to be the same, and aren't read. Meant
+// to be run before [Watch.Start]: events that come in the meantime are queued until
+// then. Files that fail don't stop it, they are reported through [Watch.Errors] and
+// tried again later, if there's hope. Progress, if given, is called every now and
+// then, and once more at the end
+func (watch *Watch) Scan(progress func(ScanStats)) (ScanStats, error) {
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
+
+	roots := []*watchRoot{}
+	for _, root := range watch.roots {
+		roots = append(roots, root)
+	}
+	return watch.scan(roots, progress)
+}
+
+// Must be called with the lock held, which is let go while files are read, so that
+// events are handled in the meantime. Files are matched by their hashes across all
+// of the roots, so files moved from one root to another are found as well
+func (watch *Watch) scan(roots []*watchRoot, progress func(ScanStats)) (ScanStats, error) {
+	var result ScanStats
+	report := func() {
+		if progress != nil {
+			progress(result)
+		}
+	}
+
+	indexed := map[string]file.FsFile{}
+	for _, root := range roots {
+		for f, err := range watch.repo.List(repo.Query{Where: repo.Under(root.Path)}) {
+			if err != nil {
+				return result, err
+			}
+			indexed[f.Name] = f
+		}
+	}
+
+	// Files that are new or changed are read by the workers, after whatever has changed live
+	jobs := []*job{}
+	// As they were indexed, for the files that were
+	changed := map[string]file.FsFile{}
+	reads := make(chan *job)
+	// Files that can't be seen, or are inside directories that can't, are left as they are, they aren't gone
+	walkFailed := func(root *watchRoot, path string, entry fs.DirEntry, err error) error {
+		if !errors.Is(err, fs.ErrNotExist) {
+			prefix := path + string(filepath.Separator)
+			for name := range indexed {
+				if name == path || strings.HasPrefix(name, prefix) {
+					delete(indexed, name)
+				}
+			}
+		}
+		return watch.walkFailed(root.Path, path, entry, err)
+	}
+	for _, root := range roots {
+		err := filepath.WalkDir(root.Path, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return walkFailed(root, path, entry, err)
+			}
+			if watch.ignores(root, path, entry.IsDir()) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.IsDir() {
+				if entry.Name() == metaDir {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+
+			result.Seen += 1
+			if result.Seen%scanProgressEvery == 0 {
+				report()
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				return walkFailed(root, path, entry, err)
+			}
+			old, ok := indexed[path]
+			delete(indexed, path)
+			if ok && !old.ModTime.IsZero() && old.Size == info.Size() && old.ModTime.UnixMilli() == info.ModTime().UnixMilli() {
+				return nil
+			}
+			if ok {
+				changed[path] = old
+			}
+			if watch.quarantined(path, info) {
+				result.Quarantined += 1
+				return nil
+			}
+
+			jobs = append(jobs, watch.pool.read(root, path, priorityScan, func(j *job) {
+				reads <- j
+			}))
+			return nil
+		})
+		if err != nil {
+			for _, j := range jobs {
+				j.cancel()
+			}
+			// Nobody is there to take what's read, it's thrown away
+			go func(pending int) {
+				for range pending {
+					<-reads
+				}
+			}(len(jobs))
+			return result, err
+		}
+	}
+
+	watch.mu.Unlock()
+	for range jobs {
+		<-reads
+	}
+	watch.mu.Lock()
+
+	// Files that fail are read again later, as if they were written to
+	fail := func(j *job, err error) {
+		result.Failed += 1
+		watch.failed(j.name, err, func() {
+			if watch.rootCurrent(j.root) {
+				watch.settle(j.root, j.name)
+			}
+		})
+	}
+
+	// New files are held back until all of the roots are seen, some of them can be the ones that were moved
+	added := []*job{}
+	for _, j := range jobs {
+		// Changed or gone while it was read, the events take care of it. Roots removed in the meantime take their files along
+		if errors.Is(j.err, fs.ErrNotExist) || j.ctx.Err() != nil || !watch.rootCurrent(j.root) {
+			continue
+		} else if j.err != nil {
+			fail(j, j.err)
+			continue
+		}
+		result.Hashed += 1
+
+		fsFile := j.read.fsFile
+		old, ok := changed[j.name]
+		switch {
+		case !ok:
+			added = append(added, j)
+			continue
+		case bytes.Equal(old.Hash, fsFile.Hash):
+			// Touched, but not changed, only the size and modification time are updated
+			if err := watch.repo.BatchFrom(repo.SourceScan, func(tx repo.Tx) error {
+				return tx.Update(repo.Eq(repo.Filename{}, j.name), fsFile)
+			}); err != nil {
+				fail(j, err)
+			}
+			continue
+		}
+		if err := watch.fileIndex(j.root, j.read, repo.SourceScan); err != nil {
+			fail(j, err)
+			continue
+		}
+		result.Changed += 1
+	}
+
+	// Whatever is indexed and wasn't seen is gone, unless it's found under another name
+	gone := map[string][]string{}
+	for name, f := range indexed {
+		gone[string(f.Hash)] = append(gone[string(f.Hash)], name)
+	}
+	for _, a := range added {
+		fsFile := a.read.fsFile
+		names := gone[string(fsFile.Hash)]
+		if len(names) == 0 {
+			if err := watch.fileIndex(a.root, a.read, repo.SourceScan); err != nil {
+				fail(a, err)
+				continue
+			}
+			result.Added += 1
+			continue
+		}
+
+		oldName := names[0]
+		gone[string(fsFile.Hash)] = names[1:]
+		delete(indexed, oldName)
+		if err := watch.repo.BatchFrom(repo.SourceScan, func(tx repo.Tx) error {
+			return tx.Update(repo.Eq(repo.Filename{}, oldName), fsFile)
+		}); err != nil {
+			fail(a, err)
+			continue
+		}
+		// Sidecar pictures belong to the album of their directory, which may be a different one now
+		if a.read.sidecar {
+			if err := watch.artworkWrite(a.read); err != nil {
+				slog.Warn("can't index artwork", "fileName", fsFile.Name, "err", err)
+			}
+		}
+		result.Moved += 1
+	}
+
+	if err := watch.repo.BatchFrom(repo.SourceScan, func(tx repo.Tx) error {
+		for name := range indexed {
+			if err := tx.Delete(repo.Eq(repo.Filename{}, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return result, err
+	}
+	result.Removed = len(indexed)
+
+	report()
+	return result, nil
+}
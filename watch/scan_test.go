@@ -0,0 +1,129 @@
+package watch
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/artwork"
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/repo"
+)
+
+func testIndexed(t *testing.T, store repo.Store, path string) file.FsFile {
+	fsFile, err := fsFileRead(context.Background(), path, sha1.New(), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Insert(fsFile))
+	return fsFile
+}
+
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	store := repo.NewMemory()
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "Album"), 0o755))
+	path := func(name string) string {
+		return filepath.Join(root, "Album", name)
+	}
+
+	// Indexed before, and never changed
+	assert.Nil(t, os.WriteFile(path("same.txt"), []byte("same"), 0o644))
+	testIndexed(t, store, path("same.txt"))
+	// Indexed before, but changed since
+	assert.Nil(t, os.WriteFile(path("changed.txt"), []byte("before"), 0o644))
+	testIndexed(t, store, path("changed.txt"))
+	assert.Nil(t, os.WriteFile(path("changed.txt"), []byte("after!"), 0o644))
+	later := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(path("changed.txt"), later, later))
+	// Indexed before, only touched since
+	assert.Nil(t, os.WriteFile(path("touched.txt"), []byte("touched"), 0o644))
+	testIndexed(t, store, path("touched.txt"))
+	assert.Nil(t, os.Chtimes(path("touched.txt"), later, later))
+	// Indexed before, moved since
+	assert.Nil(t, os.WriteFile(path("old.txt"), []byte("moved"), 0o644))
+	testIndexed(t, store, path("old.txt"))
+	assert.Nil(t, os.Rename(path("old.txt"), path("new.txt")))
+	// Indexed before, gone since
+	assert.Nil(t, os.WriteFile(path("gone.txt"), []byte("gone"), 0o644))
+	testIndexed(t, store, path("gone.txt"))
+	assert.Nil(t, os.Remove(path("gone.txt")))
+	// Never indexed
+	assert.Nil(t, os.WriteFile(path("added.txt"), []byte("added"), 0o644))
+
+	cache, err := artwork.NewCache(t.TempDir(), artwork.DefaultThumbCfg())
+	assert.Nil(t, err)
+	assert.Nil(t, store.AddRoot(repo.DefaultRoot(root)))
+	watch, err := New(store, cache)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer watch.Stop()
+
+	reports := 0
+	stats, err := watch.Scan(func(_ ScanStats) { reports += 1 })
+	assert.Nil(t, err)
+	assert.Equal(t, ScanStats{Seen: 5, Hashed: 4, Added: 1, Changed: 1, Moved: 1, Removed: 1}, stats)
+	assert.Equal(t, 1, reports)
+	assert.Equal(t, []string{path("added.txt"), path("changed.txt"), path("new.txt"), path("same.txt"), path("touched.txt")}, testNames(t, store))
+
+	changed, err := store.GetByPath(path("changed.txt"))
+	assert.Nil(t, err)
+	hash := sha1.Sum([]byte("after!"))
+	assert.Equal(t, hash[:], changed.FsFile.Hash)
+
+	history, err := store.History(path("new.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, repo.EventRename, history[len(history)-1].Kind)
+	assert.Equal(t, repo.SourceScan, history[len(history)-1].Source)
+
+	// Nothing to do the second time around
+	stats, err = watch.Scan(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, ScanStats{Seen: 5}, stats)
+}
+
+func TestScanUnreadableDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("directories can't be made unreadable for root")
+	}
+	root := t.TempDir()
+	store := repo.NewMemory()
+	for _, dir := range []string{"Locked", "Open"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(root, dir), 0o755))
+		assert.Nil(t, os.WriteFile(filepath.Join(root, dir, "song.txt"), []byte(dir), 0o644))
+	}
+	locked := filepath.Join(root, "Locked")
+	testIndexed(t, store, filepath.Join(locked, "song.txt"))
+	assert.Nil(t, os.Chmod(locked, 0))
+	t.Cleanup(func() { os.Chmod(locked, 0o755) })
+
+	cache, err := artwork.NewCache(t.TempDir(), artwork.DefaultThumbCfg())
+	assert.Nil(t, err)
+	assert.Nil(t, store.AddRoot(repo.DefaultRoot(root)))
+	watch, err := New(store, cache)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer watch.Stop()
+
+	// The rest of the root is scanned, and what's inside the directory is left in the library
+	stats, err := watch.Scan(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, ScanStats{Seen: 1, Hashed: 1, Added: 1}, stats)
+	assert.Equal(t, []string{filepath.Join(locked, "song.txt"), filepath.Join(root, "Open", "song.txt")}, testNames(t, store))
+
+	select {
+	case err := <-watch.Errors():
+		var fileErr *FileErr
+		assert.True(t, errors.As(err, &fileErr))
+		assert.Equal(t, locked, fileErr.Name)
+		assert.True(t, errors.Is(err, fs.ErrPermission))
+	default:
+		t.Fatal("no error reported")
+	}
+}
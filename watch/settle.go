@@ -0,0 +1,166 @@
+package watch
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wetfloo/voidh/repo"
+)
+
+// How long a file has to be left alone before it's read: no events for it, and the same
+// size and modification time as on the last one. Files being written by rips and syncs
+// are read once, when they are done. The events of closing files after writing them would
+// tell that sooner, but fsnotify doesn't let those through yet
+const settleQuiet = 2 * time.Second
+
+// File that has been created or written to, and is waiting to settle
+type settling struct {
+	root    *watchRoot
+	size    int64
+	modTime time.Time
+	timer   *time.Timer
+}
+
+// Must be called with the lock held. Puts off reading the file until it settles. Events
+// coming in the meantime only put it off further, the file is read once for all of them
+func (watch *Watch) settle(root *watchRoot, name string) {
+	// Whatever is being read is stale already
+	watch.pool.cancel(name)
+
+	s, ok := watch.settling[name]
+	if !ok {
+		s = &settling{root: root}
+		watch.settling[name] = s
+		s.timer = time.AfterFunc(settleQuiet, func() {
+			watch.mu.Lock()
+			defer watch.mu.Unlock()
+
+			if watch.settling[name] != s {
+				return
+			}
+			watch.settled(name, s)
+		})
+	} else {
+		s.timer.Reset(settleQuiet)
+	}
+
+	if info, err := os.Stat(name); err == nil {
+		s.size = info.Size()
+		s.modTime = info.ModTime()
+	}
+}
+
+// Must be called with the lock held. Stops waiting for the file to settle, and reading it, as it's gone.
+// Tells whether it was waiting
+func (watch *Watch) settleCancel(name string) bool {
+	s, ok := watch.settling[name]
+	if ok {
+		s.timer.Stop()
+		delete(watch.settling, name)
+	}
+	if watch.pool.cancel(name) {
+		ok = true
+	}
+	return ok
+}
+
+// Must be called with the lock held. Stops waiting for files inside the directory to settle, and reading them, returning their names
+func (watch *Watch) settleCancelDir(dir string) []string {
+	result := watch.pool.cancelDir(dir)
+	prefix := dir + string(filepath.Separator)
+	for name := range watch.settling {
+		if strings.HasPrefix(name, prefix) {
+			watch.settleCancel(name)
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// Must be called with the lock held. Has the file that has been quiet for long enough
+// read, unless it's still changing, is gone, is in quarantine, or its root is
+func (watch *Watch) settled(name string, s *settling) {
+	if !watch.rootCurrent(s.root) {
+		delete(watch.settling, name)
+		return
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		delete(watch.settling, name)
+		return
+	}
+	// Written to without events, which happens with memory mapped files and some network filesystems
+	if info.Size() != s.size || !info.ModTime().Equal(s.modTime) {
+		s.size = info.Size()
+		s.modTime = info.ModTime()
+		s.timer.Reset(settleQuiet)
+		return
+	}
+	delete(watch.settling, name)
+	if watch.quarantined(name, info) {
+		return
+	}
+
+	watch.pool.read(s.root, name, priorityLive, func(j *job) {
+		watch.mu.Lock()
+		defer watch.mu.Unlock()
+
+		// Changed again or gone while it was read, the next event takes care of it. Same for its root
+		if j.ctx.Err() != nil || !watch.rootCurrent(j.root) {
+			return
+		}
+		if err := watch.fileSettled(j); err != nil {
+			// Read again, whatever has been read may be stale by then
+			watch.failed(name, err, func() {
+				if watch.rootCurrent(j.root) {
+					watch.settle(j.root, name)
+				}
+			})
+			return
+		}
+		watch.succeeded(name)
+	})
+}
+
+// Must be called with the lock held. Brings the library up to date with the file that has settled and been read
+func (watch *Watch) fileSettled(j *job) error {
+	if errors.Is(j.err, fs.ErrNotExist) {
+		return nil
+	} else if j.err != nil {
+		return j.err
+	}
+	fsFile := j.read.fsFile
+
+	old, err := watch.repo.GetByPath(j.name)
+	switch {
+	case err == nil && bytes.Equal(old.FsFile.Hash, fsFile.Hash):
+		// Touched, but not changed, only the size and modification time are updated. Sidecar
+		// pictures are linked again, they may have been moved into another album
+		if err := watch.write(func(tx repo.Tx) error {
+			return tx.Insert(fsFile)
+		}); err != nil || !j.read.sidecar {
+			return err
+		}
+		return watch.artworkWrite(j.read)
+	case errors.Is(err, repo.NotFoundErr):
+		id, hasId := watch.ids[j.name]
+		if oldName, m := watch.moveFind(j.root, false, id, hasId, fsFile.Hash); m != nil {
+			return watch.fileMoved(oldName, m, j.root, fsFile)
+		}
+	case err != nil:
+		return err
+	}
+
+	if err := watch.fileIndex(j.root, j.read, repo.SourceWatcher); err != nil {
+		return err
+	}
+	slog.Debug("Settled", "fileName", j.name, "fileHash", hex.EncodeToString(fsFile.Hash))
+	return nil
+}
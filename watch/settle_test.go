@@ -0,0 +1,49 @@
+package watch
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/repo"
+)
+
+func TestWatchSettle(t *testing.T) {
+	root := t.TempDir()
+	_, store := testWatch(t, root)
+
+	// Written bit by bit, like a rip in progress, with the gaps shorter than it takes to settle
+	song := filepath.Join(root, "song.txt")
+	f, err := os.Create(song)
+	assert.Nil(t, err)
+	content := []byte{}
+	for i := range 5 {
+		chunk := []byte{byte('a' + i)}
+		_, err := f.Write(chunk)
+		assert.Nil(t, err)
+		content = append(content, chunk...)
+		time.Sleep(settleQuiet / 4)
+		assert.Empty(t, testNames(t, store))
+	}
+	assert.Nil(t, f.Close())
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{song}, testNames(t, store))
+	}, 2*settleQuiet, 10*time.Millisecond)
+	// Read once it's done, and only then
+	assert.Equal(t, []repo.EventKind{repo.EventCreate}, testEventKinds(t, store, song))
+	af, err := store.GetByPath(song)
+	assert.Nil(t, err)
+	hash := sha1.Sum(content)
+	assert.Equal(t, hash[:], af.FsFile.Hash)
+
+	// Removed before it settles, it's never read
+	gone := filepath.Join(root, "gone.txt")
+	assert.Nil(t, os.WriteFile(gone, []byte("gone"), 0o644))
+	assert.Nil(t, os.Remove(gone))
+	time.Sleep(settleQuiet + settleQuiet/2)
+	assert.Equal(t, []string{song}, testNames(t, store))
+}
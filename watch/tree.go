@@ -0,0 +1,111 @@
+package watch
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/wetfloo/voidh/repo"
+)
+
+// Directory of the library metadata, like the content-addressable store. It's never watched
+const metaDir = ".voidh"
+
+// Must be called with the lock held. Watches the directory of the root and all of its
+// subdirectories. With index set, files found inside are indexed as well, as there are no events for them
+func (watch *Watch) dirWatch(root *watchRoot, dir string, index bool) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return watch.walkFailed(dir, path, entry, err)
+		}
+		if watch.ignores(root, path, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return watch.walkFailed(dir, path, entry, err)
+		}
+		if !entry.IsDir() {
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+			if index {
+				watch.try(path, func() error {
+					return watch.fileCreated(root, path, info)
+				})
+			} else {
+				watch.identityKeep(path, info)
+			}
+			return nil
+		}
+
+		if entry.Name() == metaDir {
+			return filepath.SkipDir
+		}
+		watch.identityKeep(path, info)
+		if err := watch.watcher.Add(path); err != nil {
+			return watch.walkFailed(dir, path, entry, err)
+		}
+		watch.dirs[path] = true
+		slog.Debug("Watching directory", "dir", path)
+		return nil
+	})
+}
+
+// Must be called with the lock held. Reports the file or directory that can't be walked, and skips it,
+// the walk goes on with everything else. Unless it's the one the walk started at, there's nothing left then
+func (watch *Watch) walkFailed(start string, path string, entry fs.DirEntry, err error) error {
+	if path == start {
+		return err
+	}
+	// Gone in the meantime, the events take care of it
+	if !errors.Is(err, fs.ErrNotExist) {
+		watch.errorReport(&FileErr{Name: path, Attempts: 1, Err: err})
+	}
+	if entry != nil && !entry.IsDir() {
+		return nil
+	}
+	return filepath.SkipDir
+}
+
+// Must be called with the lock held. Stops watching the directory, along with its subdirectories
+func (watch *Watch) dirsUnwatch(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for path := range watch.dirs {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			// Watches of removed directories are dropped by the system already, that's fine
+			watch.watcher.Remove(path)
+			delete(watch.dirs, path)
+		}
+	}
+}
+
+// Must be called with the lock held. Forgets identities of the directory and everything inside
+func (watch *Watch) identitiesForget(dir string) {
+	prefix := dir + string(filepath.Separator)
+	for path := range watch.ids {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			delete(watch.ids, path)
+		}
+	}
+}
+
+// Must be called with the lock held. Stops watching the directory that is gone, along
+// with its subdirectories, and forgets about all files that were inside
+func (watch *Watch) dirForget(dir string) error {
+	watch.dirsUnwatch(dir)
+	watch.identitiesForget(dir)
+	watch.ignoreFilesForget(dir)
+	watch.settleCancelDir(dir)
+	slog.Debug("Forgetting directory", "dir", dir)
+
+	return watch.write(func(tx repo.Tx) error {
+		return tx.Delete(repo.Under(dir))
+	})
+}
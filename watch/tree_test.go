@@ -0,0 +1,63 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wetfloo/voidh/artwork"
+	"github.com/wetfloo/voidh/repo"
+)
+
+func testWatch(t *testing.T, dir string) (*Watch, *repo.Memory) {
+	cache, err := artwork.NewCache(t.TempDir(), artwork.DefaultThumbCfg())
+	assert.Nil(t, err)
+	store := repo.NewMemory()
+	assert.Nil(t, store.AddRoot(repo.DefaultRoot(dir)))
+
+	watch, err := New(store, cache)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	go watch.Start()
+	t.Cleanup(watch.Stop)
+	return watch, store
+}
+
+func testNames(t *testing.T, store repo.Store) []string {
+	files, err := store.Find(repo.Query{Order: []repo.Order{{Key: repo.Filename{}}}})
+	assert.Nil(t, err)
+	result := []string{}
+	for _, f := range files {
+		result = append(result, f.Name)
+	}
+	return result
+}
+
+func TestWatchRecursive(t *testing.T) {
+	root := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "Artist", "Old"), 0o755))
+	_, store := testWatch(t, root)
+
+	// Existing subdirectories are watched from the start
+	old := filepath.Join(root, "Artist", "Old", "notes.txt")
+	assert.Nil(t, os.WriteFile(old, []byte("old"), 0o644))
+
+	// A whole album moved in at once, its files are there before its directory is watched
+	staging := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(staging, "New", "Disc 1"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(staging, "New", "Disc 1", "notes.txt"), []byte("new"), 0o644))
+	assert.Nil(t, os.Rename(filepath.Join(staging, "New"), filepath.Join(root, "Artist", "New")))
+	added := filepath.Join(root, "Artist", "New", "Disc 1", "notes.txt")
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{added, old}, testNames(t, store))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, os.RemoveAll(filepath.Join(root, "Artist", "New")))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{old}, testNames(t, store))
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,119 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/file/cue"
+	"github.com/wetfloo/voidh/file/flac"
+)
+
+// Reads virtual tracks of a freshly created file, by their sources. For FLAC files that's their embedded
+// cue sheet or a sidecar .cue with the same base name, for .cue files that's every FLAC they reference
+func virtualTracksRead(path string) (map[string][]file.VirtualTrack, error) {
+	result := map[string][]file.VirtualTrack{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".flac":
+		tracks, found, err := virtualTracksFromFlac(path)
+		if err != nil || !found {
+			return result, err
+		}
+		result[path] = tracks
+
+	case ".cue":
+		sheet, err := cueSheetRead(path)
+		if err != nil {
+			return result, err
+		}
+		for _, f := range sheet.Files {
+			source := filepath.Join(filepath.Dir(path), filepath.FromSlash(strings.ReplaceAll(f.Name, "\\", "/")))
+			if !strings.EqualFold(filepath.Ext(source), ".flac") {
+				continue
+			}
+			if _, err := os.Stat(source); err != nil {
+				continue
+			}
+
+			streamInfo, err := flacStreamInfoRead(source)
+			if err != nil {
+				return result, err
+			}
+			tracks, err := cue.FromSheet(sheet, source, streamInfo)
+			if err != nil {
+				return result, err
+			}
+			result[source] = tracks
+		}
+	}
+
+	return result, nil
+}
+
+func virtualTracksFromFlac(path string) ([]file.VirtualTrack, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	stream, err := flac.ReadStream(f, flac.ReadCfg{ReadMetadata: true, ReadFrames: false})
+	if err != nil {
+		return nil, false, err
+	}
+
+	tracks, found, err := cue.FromFlac(stream, path)
+	if err != nil || found {
+		return tracks, found, err
+	}
+
+	sidecarPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".cue"
+	if _, err := os.Stat(sidecarPath); err != nil {
+		return nil, false, nil
+	}
+	sheet, err := cueSheetRead(sidecarPath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, block := range stream.Metadata {
+		if streamInfo, ok := block.(flac.StreamInfo); ok {
+			tracks, err := cue.FromSheet(sheet, path, streamInfo)
+			return tracks, true, err
+		}
+	}
+
+	return nil, true, cue.NoStreamInfoErr
+}
+
+func flacStreamInfoRead(path string) (flac.StreamInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return flac.StreamInfo{}, err
+	}
+	defer f.Close()
+
+	stream, err := flac.ReadStream(f, flac.ReadCfg{ReadMetadata: true, ReadFrames: false})
+	if err != nil {
+		return flac.StreamInfo{}, err
+	}
+
+	for _, block := range stream.Metadata {
+		if streamInfo, ok := block.(flac.StreamInfo); ok {
+			return streamInfo, nil
+		}
+	}
+
+	return flac.StreamInfo{}, cue.NoStreamInfoErr
+}
+
+func cueSheetRead(path string) (cue.Sheet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return cue.Sheet{}, err
+	}
+	defer f.Close()
+
+	return cue.Parse(f)
+}
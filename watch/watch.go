@@ -1,43 +1,112 @@
 package watch
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
-	"hash"
-	"io"
+	"errors"
+	"io/fs"
 	"log/slog"
 	"os"
-	"strings"
-	"time"
+	"path/filepath"
+	"sync"
 
 	"github.com/fsnotify/fsnotify"
-	debounce "github.com/wetfloo/go_debounce"
+	"github.com/wetfloo/voidh/artwork"
+	"github.com/wetfloo/voidh/cas"
 	"github.com/wetfloo/voidh/file"
+	"github.com/wetfloo/voidh/ignore"
 	"github.com/wetfloo/voidh/repo"
 )
 
 type Watch struct {
+	// Held while an event is handled, and while roots are added or removed
+	mu      sync.Mutex
 	watcher *fsnotify.Watcher
-	repo    repo.Repo
-	hasher  hash.Hash
+	// Roots being watched, by their paths
+	roots map[string]*watchRoot
+	// Directories being watched, the roots included
+	dirs map[string]bool
+	// Identities of the files and directories being watched, by their names
+	ids map[string]identity
+	// Files and directories renamed, by their old names
+	moves map[string]*move
+	// Files waiting to settle, by their names
+	settling     map[string]*settling
+	repo         repo.Store
+	artworkCache artwork.Cache
+	// Nil unless files are to be ingested into content-addressable stores, one per root
+	casCfg *cas.Cfg
+	// Reads files, so that events are handled while they are
+	pool *pool
+	// Paths that have failed, by their names
+	failures map[string]*failure
+	retryCfg RetryCfg
+	// What's left out of all roots
+	ignoreCfg   IgnoreCfg
+	ignoreRules ignore.Rules
+	// Rules of ignore files, by the directories they are in, read when they are first needed
+	ignoreFiles map[string]ignore.Rules
+	errs        chan error
 }
 
-func New(repo repo.Repo, dir string) (Watch, error) {
-	var result Watch
-
+// Starts watching all roots of the library. Files that are already there are left for [Watch.Scan]
+func New(repo repo.Store, artworkCache artwork.Cache) (*Watch, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		return result, err
+		return nil, err
 	}
 
-	if err := watcher.Add(dir); err != nil {
-		return result, err
+	result := &Watch{
+		watcher:      watcher,
+		roots:        map[string]*watchRoot{},
+		dirs:         map[string]bool{},
+		ids:          map[string]identity{},
+		moves:        map[string]*move{},
+		settling:     map[string]*settling{},
+		repo:         repo,
+		artworkCache: artworkCache,
+		pool:         poolNew(DefaultPoolCfg(), artworkCache),
+		failures:     map[string]*failure{},
+		retryCfg:     DefaultRetryCfg(),
+		ignoreFiles:  map[string]ignore.Rules{},
+		errs:         make(chan error, errorsBuffer),
+	}
+	result.ignoreCfg = DefaultIgnoreCfg()
+	// Rules that come with the watcher are well-formed
+	result.ignoreRules, _ = ignore.Parse(result.ignoreCfg.Patterns)
+	if err := result.rootsSync(false); err != nil {
+		result.pool.stop()
+		watcher.Close()
+		return nil, err
 	}
+	return result, nil
+}
 
-	hasher := sha1.New()
+// Makes the watcher ingest every new file into the store of its root, unless the root is read-only
+func (watch *Watch) CasUse(cfg cas.Cfg) error {
+	watch.mu.Lock()
+	defer watch.mu.Unlock()
 
-	result = Watch{watcher, repo, hasher}
-	return result, nil
+	watch.casCfg = &cfg
+	for _, root := range watch.roots {
+		if root.ReadOnly {
+			continue
+		}
+		blobs, err := cas.New(root.Path, cfg)
+		if err != nil {
+			return err
+		}
+		root.blobs = &blobs
+	}
+	return nil
+}
+
+// Changes how many files are read at once, and how fast. Meant to be called before [Watch.Scan] and [Watch.Start]
+func (watch *Watch) PoolUse(cfg PoolCfg) {
+	watch.mu.Lock()
+	old := watch.pool
+	watch.pool = poolNew(cfg, watch.artworkCache)
+	watch.mu.Unlock()
+
+	old.stop()
 }
 
 func (watch *Watch) Start() error {
@@ -48,129 +117,208 @@ func (watch *Watch) Start() error {
 				slog.Debug("No more events, channel closed")
 				return nil
 			}
+			watch.mu.Lock()
 			watch.fsUpdateHandle(event)
+			watch.mu.Unlock()
 		case err, chanOk := <-watch.watcher.Errors:
 			if !chanOk {
 				slog.Debug("No more errors, channel closed")
 				return nil
 			}
-			slog.Warn("error while watching", "err", err)
+			watch.errorReport(err)
 		}
 	}
 }
 
 func (watch *Watch) Stop() {
+	watch.mu.Lock()
+
+	for _, m := range watch.moves {
+		m.timer.Stop()
+	}
+	for _, s := range watch.settling {
+		s.timer.Stop()
+	}
+	for _, f := range watch.failures {
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+	}
+	// Timers that have gone off already find nothing to do
+	clear(watch.moves)
+	clear(watch.settling)
+	clear(watch.failures)
+	watch.mu.Unlock()
+
+	// Workers may be waiting for the lock to hand in what they have read
+	watch.pool.stop()
 	watch.watcher.Close()
 }
 
+// Must be called with the lock held. Failures are reported, and tried again later
 func (watch *Watch) fsUpdateHandle(event fsnotify.Event) {
-	switch {
-	case event.Has(fsnotify.Create):
-		fileHash, err := fileHashCalc(event.Name, watch.hasher)
-		if err != nil {
-			panic(err)
+	name := event.Name
+	// Directories that are gone can't be told from files by their root, only by having been watched
+	if event.Has(fsnotify.Remove) && watch.dirs[name] {
+		watch.try(name, func() error {
+			return watch.dirForget(name)
+		})
+		return
+	}
+
+	root := watch.rootOf(name)
+	// Ignore files aren't indexed themselves, they change what is
+	if root != nil && filepath.Base(name) == ignoreFileName {
+		delete(watch.ignoreFiles, filepath.Dir(name))
+		slog.Info("Ignore rules changed, files already there are left out or taken in by the next scan", "fileName", name)
+		return
+	}
+	dir := watch.dirs[name]
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Lstat(name); err == nil {
+			dir = info.IsDir()
 		}
-		if err := watch.repo.Insert(file.FsFile{
-			Name: event.Name,
-			Hash: fileHash,
-		}); err != nil {
-			panic(err)
+	}
+	if root == nil || watch.ignores(root, name, dir) {
+		// Roots themselves can be moved away, they are forgotten right away, same as when removed
+		if event.Has(fsnotify.Rename) && watch.dirs[name] {
+			watch.try(name, func() error {
+				return watch.dirForget(name)
+			})
 		}
-		slog.Debug("fsnotify.Create", "fileName", event.Name, "fileHash", hex.EncodeToString(fileHash))
+		return
+	}
 
-	case event.Has(fsnotify.Write):
-		debounce.New(2 * time.Second)(func() {
-			fileHash, err := fileHashCalc(event.Name, watch.hasher)
-			if err != nil {
-				panic(err)
-			}
-			if err := watch.repo.Update(repo.Criteria{
-				Key:   repo.Filename{},
-				Value: fileHash,
-			}, file.FsFile{
-				Name: event.Name,
-				Hash: fileHash,
-			}); err != nil {
-				panic(err)
-			}
-			slog.Debug("fsnotify.Write", "fileName", event.Name, "fileHash", hex.EncodeToString(fileHash))
+	switch {
+	case event.Has(fsnotify.Create):
+		watch.try(name, func() error {
+			return watch.created(root, name)
 		})
 
-	case event.Has(fsnotify.Remove):
-		if err := watch.fsFileForget(event.Name); err != nil {
-			panic(err)
-		}
-		slog.Debug("fsnotify.Remove", "fileName", event.Name)
+	case event.Has(fsnotify.Write):
+		watch.settle(root, name)
 
-	// TODO: this event also means that we need to re-attach the watcher, maybe?
+	// The event is for the old name, the new one gets a Create of its own if it's still watched
 	case event.Has(fsnotify.Rename):
-		// File is no longer on our path, forget about it (could happen if moved to a whole new location, for example)
-		// TODO: allow to watch for more than one path, this assumes there's only one
-		// TODO: does this expand to absolute path? If not, we should always do that
-		if hasPrefixEvenWithSurround(event.Name, "\"", watch.watcher.WatchList()[0]) {
-			if err := watch.fsFileForget(event.Name); err != nil {
-				panic(err)
-			}
-			return
-		}
+		watch.try(name, func() error {
+			return watch.moveStart(root, name)
+		})
+		slog.Debug("fsnotify.Rename", "fileName", name)
 
-		fileHash, err := fileHashCalc(event.Name, watch.hasher)
-		if err != nil {
-			panic(err)
-		}
-		if err := watch.repo.Update(repo.Criteria{
-			Key:   repo.Hash{},
-			Value: fileHash,
-		}, file.FsFile{
-			Name: event.Name,
-			Hash: fileHash,
-		}); err != nil {
-			panic(err)
-		}
-		slog.Debug("fsnotify.Rename", "fileName", event.Name, "fileHash", hex.EncodeToString(fileHash))
+	case event.Has(fsnotify.Remove):
+		watch.settleCancel(name)
+		watch.try(name, func() error {
+			return watch.fsFileForget(name)
+		})
+		slog.Debug("fsnotify.Remove", "fileName", name)
 	}
 	// other events are do not change file structure, so no need to update the db
 }
 
-func (watch *Watch) fsFileForget(name string) error {
-	if err := watch.repo.Delete(repo.Criteria{
-		Key:   repo.Filename{},
-		Value: name,
-	}); err != nil {
+// Must be called with the lock held
+func (watch *Watch) created(root *watchRoot, name string) error {
+	info, err := os.Stat(name)
+	// Gone already, like temporary files renamed over the ones they replace. Its own event is on the way
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
 		return err
 	}
+	if info.IsDir() {
+		return watch.dirCreated(root, name, info)
+	}
+	return watch.fileCreated(root, name, info)
+}
 
+// Must be called with the lock held. Indexes the new file once it settles, along with
+// everything that comes with it, unless it's a file that has been moved, which only gets its new name
+func (watch *Watch) fileCreated(root *watchRoot, name string, info fs.FileInfo) error {
+	id, hasId := identityOf(info)
+	if hasId {
+		watch.ids[name] = id
+	}
+	// No need to read a file moved as a whole, it's the same file. Others are matched by their hashes, once they are read
+	if oldName, m := watch.moveFind(root, false, id, hasId, nil); m != nil {
+		fsFile := file.FsFile{Name: name, Hash: m.fsFile.Hash, Size: info.Size(), ModTime: info.ModTime()}
+		if err := watch.fileMoved(oldName, m, root, fsFile); err != nil {
+			return err
+		}
+		if !m.unsettled {
+			return nil
+		}
+	} else {
+		watch.moveCandidate(root, name, hasId)
+	}
+	watch.settle(root, name)
 	return nil
 }
 
-func fileHashCalc(filePath string, hasher hash.Hash) ([]byte, error) {
-	var result []byte
-
-	file, err := os.Open(filePath)
-	if err != nil {
-		return result, err
+// Must be called with the lock held. Watches the new directory, and indexes the files
+// inside, as they aren't reported, they can be there before it's watched
+func (watch *Watch) dirCreated(root *watchRoot, dir string, info fs.FileInfo) error {
+	id, hasId := identityOf(info)
+	if oldDir, m := watch.moveFind(root, true, id, hasId, nil); m != nil && hasId {
+		return watch.dirMoved(oldDir, m, root, dir)
 	}
-	defer file.Close()
+	return watch.dirWatch(root, dir, true)
+}
 
-	hasher.Reset()
+// Indexes the file that has just been read, with its virtual tracks, artwork, and the blob
+// of its contents, as far as the settings of its root go. Only the file itself is required,
+// the rest is skipped if it can't be done
+func (watch *Watch) fileIndex(root *watchRoot, read fileRead, source repo.EventSource) error {
+	fsFile := read.fsFile
+	name := fsFile.Name
+	if !root.ParseTags {
+		// Only indexed by its contents, which are still ingested
+		if err := watch.repo.BatchFrom(source, func(tx repo.Tx) error {
+			return tx.Insert(fsFile)
+		}); err != nil {
+			return err
+		}
+	} else {
+		if err := watch.fsFileIndex(read, source); err != nil {
+			return err
+		}
+		for source, tracks := range read.virtualTracks {
+			if err := watch.repo.ReplaceVirtualTracks(source, tracks); err != nil {
+				slog.Warn("can't index virtual tracks", "fileName", source, "err", err)
+			}
+		}
+		if err := watch.artworkWrite(read); err != nil {
+			slog.Warn("can't index artwork", "fileName", name, "err", err)
+		}
+	}
 
-	if _, err := io.Copy(hasher, file); err != nil {
-		return result, err
+	if root.blobs != nil {
+		if err := root.blobs.Ingest(name, fsFile.Hash); err != nil {
+			slog.Warn("can't ingest file", "fileName", name, "err", err)
+		}
 	}
+	return nil
+}
 
-	result = hasher.Sum(nil)
-	return result, nil
+// Stores the file along with its tags and properties. Files that couldn't be parsed are still stored, just without them
+func (watch *Watch) fsFileIndex(read fileRead, source repo.EventSource) error {
+	return watch.repo.BatchFrom(source, func(tx repo.Tx) error {
+		if read.af == nil {
+			return tx.Insert(read.fsFile)
+		}
+		return tx.UpsertAudioFile(*read.af)
+	})
+}
+
+func (watch *Watch) fsFileForget(name string) error {
+	delete(watch.ids, name)
+	if err := watch.write(func(tx repo.Tx) error {
+		return tx.Delete(repo.Eq(repo.Filename{}, name))
+	}); err != nil {
+		return err
+	}
+	return watch.sidecarGone(name)
 }
 
-// Checks if a given string has prefix, if not, tries to surround it
-// with a given string and tries again
-func hasPrefixEvenWithSurround(s string, sur string, prefix string) bool {
-	if strings.HasPrefix(s, prefix) {
-		return true
-	}
-	var builder strings.Builder
-	builder.WriteString(sur)
-	builder.WriteString(s)
-	builder.WriteString(sur)
-	return strings.HasPrefix(builder.String(), prefix)
+// Writes to the library, with the changes recorded in its history as made by the watcher
+func (watch *Watch) write(fn func(tx repo.Tx) error) error {
+	return watch.repo.BatchFrom(repo.SourceWatcher, fn)
 }